@@ -0,0 +1,174 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func init() {
+	SchemeBuilder.Register(&BookkeeperBackup{}, &BookkeeperBackupList{})
+}
+
+//+kubebuilder:object:root=true
+
+// BookkeeperBackupList contains a list of BookkeeperBackup
+type BookkeeperBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []BookkeeperBackup `json:"items"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:resource:shortName=bkbackup
+// +kubebuilder:printcolumn:name="Cluster",type=string,JSONPath=`.spec.clusterName`,description="The backed up bookkeeper cluster"
+// +kubebuilder:printcolumn:name="Schedule",type=string,JSONPath=`.spec.schedule`,description="The schedule of the backups"
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`,description="The phase of the backup"
+// +kubebuilder:printcolumn:name="Snapshot",type=string,JSONPath=`.status.snapshot`,description="The last snapshot taken"
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// BookkeeperBackup takes snapshots of the ZooKeeper metadata of a
+// BookkeeperCluster, once or on a schedule
+type BookkeeperBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   BookkeeperBackupSpec   `json:"spec,omitempty"`
+	Status BookkeeperBackupStatus `json:"status,omitempty"`
+}
+
+// BookkeeperBackupSpec defines the desired state of BookkeeperBackup
+type BookkeeperBackupSpec struct {
+	// ClusterName is the name of the BookkeeperCluster, in the namespace of
+	// the backup, whose metadata is backed up
+	ClusterName string `json:"clusterName"`
+
+	// Schedule is the cron schedule of the backups. If it is not set, a
+	// single backup is taken when the BookkeeperBackup is created.
+	// +optional
+	Schedule string `json:"schedule,omitempty"`
+
+	// Storage is where the snapshots are stored
+	Storage BackupStorageSpec `json:"storage"`
+}
+
+// BackupStorageSpec is where the snapshots are stored, either a PVC or S3
+// compatible storage
+type BackupStorageSpec struct {
+	// PersistentVolumeClaim stores the snapshots in a PVC
+	// +optional
+	PersistentVolumeClaim *PVCBackupStorage `json:"persistentVolumeClaim,omitempty"`
+
+	// S3 stores the snapshots in S3 compatible storage
+	// +optional
+	S3 *S3BackupStorage `json:"s3,omitempty"`
+}
+
+// PVCBackupStorage stores the snapshots in a PVC
+type PVCBackupStorage struct {
+	// ClaimName is the name of the PVC, in the namespace of the backup
+	ClaimName string `json:"claimName"`
+
+	// Path is the directory of the snapshots in the PVC. Defaults to the
+	// root of the volume.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// S3BackupStorage stores the snapshots in S3 compatible storage
+type S3BackupStorage struct {
+	// Endpoint is the URL of the storage. Defaults to the AWS S3 endpoint of
+	// the region.
+	// +optional
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// Region of the bucket. Defaults to "us-east-1".
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// Bucket is the name of the bucket
+	Bucket string `json:"bucket"`
+
+	// Prefix is prepended to the names of the snapshot objects
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// SecretName is the name of the Secret holding the accessKeyID,
+	// secretAccessKey and optional sessionToken keys of the credentials.
+	// Without it, the Jobs use the web identity token of their service
+	// account (IRSA) or the instance profile of the node.
+	// +optional
+	SecretName string `json:"secretName,omitempty"`
+
+	// ServiceAccountName is the service account running the backup and
+	// restore Jobs, e.g. one bound to an IAM role
+	// +optional
+	ServiceAccountName string `json:"serviceAccountName,omitempty"`
+}
+
+type BookkeeperBackupPhase string
+
+const (
+	BookkeeperBackupRunning   BookkeeperBackupPhase = "Running"
+	BookkeeperBackupCompleted BookkeeperBackupPhase = "Completed"
+	BookkeeperBackupFailed    BookkeeperBackupPhase = "Failed"
+	BookkeeperBackupScheduled BookkeeperBackupPhase = "Scheduled"
+)
+
+// BookkeeperBackupStatus defines the observed state of BookkeeperBackup
+type BookkeeperBackupStatus struct {
+	// Phase is Running, Completed or Failed for a single backup, and
+	// Scheduled for scheduled backups
+	// +optional
+	Phase BookkeeperBackupPhase `json:"phase,omitempty"`
+
+	// Message gives details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Snapshot is the name of the snapshot taken by a single backup
+	// +optional
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// LastScheduleTime is when the last scheduled backup started
+	// +optional
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+
+	// LastSuccessfulTime is when the last scheduled backup completed
+	// +optional
+	LastSuccessfulTime *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+
+	// CompletionTime is when the single backup completed or failed
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// RestoreSpec restores the ZooKeeper metadata of a new cluster from a
+// snapshot before the bookies start
+type RestoreSpec struct {
+	// BackupName is the name of the BookkeeperBackup, in the namespace of the
+	// cluster, whose storage holds the snapshot
+	BackupName string `json:"backupName"`
+
+	// Snapshot is the name of the snapshot to restore
+	Snapshot string `json:"snapshot"`
+}
+
+// ValidateRestore checks that the snapshot to restore is fully given
+func (bk *BookkeeperCluster) ValidateRestore() error {
+	if r := bk.Spec.Restore; r != nil && (r.BackupName == "" || r.Snapshot == "") {
+		return fmt.Errorf("spec.restore should name a backup and a snapshot")
+	}
+	return nil
+}
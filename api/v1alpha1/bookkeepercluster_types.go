@@ -114,6 +114,44 @@ const (
 	// for the liveness probe
 	DefaultLivenessProbeTimeoutSeconds = 5
 
+	// DefaultLocalStorageClassName is the storage class name used for the
+	// operator-created local PersistentVolumes
+	DefaultLocalStorageClassName = "bookkeeper-local"
+
+	// DefaultBookiePort is the port the bookies serve clients on
+	DefaultBookiePort = 3181
+
+	// DefaultHTTPServerPort is the default port of the bookie admin HTTP server
+	DefaultHTTPServerPort = 8080
+
+	// DefaultMetricsPort is the default port of the bookie Prometheus endpoint
+	DefaultMetricsPort = 8000
+
+	// DefaultStartupProbePeriodSeconds is the default probe period (in seconds)
+	// for the startup probe
+	DefaultStartupProbePeriodSeconds = 10
+
+	// DefaultStartupProbeFailureThreshold is the default probe failure threshold
+	// for the startup probe. Together with the period it gives bookies up to
+	// 10 minutes to replay their journals before liveness checks start.
+	DefaultStartupProbeFailureThreshold = 60
+
+	// DefaultStartupProbeSuccessThreshold is the default probe success threshold
+	// for the startup probe
+	DefaultStartupProbeSuccessThreshold = 1
+
+	// DefaultStartupProbeTimeoutSeconds is the default probe timeout (in seconds)
+	// for the startup probe
+	DefaultStartupProbeTimeoutSeconds = 5
+
+	// DefaultTerminationGracePeriodSeconds is the default termination grace
+	// period (in seconds) of the bookie pods
+	DefaultTerminationGracePeriodSeconds = 60
+
+	// DefaultDrainSeconds is the default time (in seconds) the preStop hook
+	// waits for in-flight requests to complete
+	DefaultDrainSeconds = 15
+
 	// OperatorNameEnvVar is env variable for operator name
 	OperatorNameEnvVar = "OPERATOR_NAME"
 )
@@ -139,6 +177,9 @@ type BookkeeperClusterList struct {
 // +kubebuilder:printcolumn:name="Desired Version",type=string,JSONPath=`.spec.version`,description="The desired bookkeeper version"
 // +kubebuilder:printcolumn:name="Desired Members",type=integer,JSONPath=`.status.replicas`,description="The number of desired bookkeeper members"
 // +kubebuilder:printcolumn:name="Ready Members",type=integer,JSONPath=`.status.readyReplicas`,description="The number of ready bookkeeper members"
+// +kubebuilder:printcolumn:name="Available Bookies",type=integer,JSONPath=`.status.availableBookies`,description="The number of bookies registered as read-write in zookeeper",priority=1
+// +kubebuilder:printcolumn:name="Read-Only Bookies",type=integer,JSONPath=`.status.readOnlyBookies`,description="The number of bookies registered as read-only in zookeeper",priority=1
+// +kubebuilder:printcolumn:name="Paused",type=string,JSONPath=`.status.conditions[?(@.type=="Paused")].status`,description="Whether the reconciliation of the cluster is paused"
 // +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 // +k8s:openapi-gen=true
 
@@ -157,16 +198,34 @@ func (bk *BookkeeperCluster) WithDefaults() (changed bool) {
 	return changed
 }
 
+// isNew returns true until the operator first synced the cluster version.
+// Defaults changing the bookie pod template are only set on new clusters,
+// so that upgrading the operator does not restart existing bookies.
+func (bk *BookkeeperCluster) isNew() bool {
+	return bk.Status.CurrentVersion == ""
+}
+
 // ClusterSpec defines the desired state of BookkeeperCluster
 type BookkeeperClusterSpec struct {
-	// ZookeeperUri specifies the hostname/IP address and port in the format
-	// "hostname:port".
+	// ZookeeperUri specifies the ZooKeeper connect string in the format
+	// "hostname:port[,hostname:port...][/chroot]".
 	// By default, the value "zookeeper-client:2181" is used, that corresponds to the
 	// default Zookeeper service created by the Pravega Zookkeeper operator
 	// available at: https://github.com/pravega/zookeeper-operator
 	// +optional
 	ZookeeperUri string `json:"zookeeperUri"`
 
+	// Zookeeper configures the authentication and the encryption of the
+	// connections of the operator and the bookies to ZooKeeper
+	// +optional
+	Zookeeper *ZookeeperClientSpec `json:"zookeeper,omitempty"`
+
+	// Restore writes the ZooKeeper metadata of a snapshot taken by a
+	// BookkeeperBackup before the metadata of the cluster is initialized.
+	// It is ignored once the cluster is initialized.
+	// +optional
+	Restore *RestoreSpec `json:"restore,omitempty"`
+
 	// Image defines the BookKeeper Docker image to use.
 	// By default, "pravega/bookkeeper" will be used.
 	// +optional
@@ -194,6 +253,20 @@ type BookkeeperClusterSpec struct {
 	// +optional
 	AutoRecovery *bool `json:"autoRecovery"`
 
+	// AutoRepair lets the operator repair bookies failing with a cookie
+	// mismatch, e.g. after their volumes were lost. The stale cookie is
+	// deleted from ZooKeeper and the bookie is formatted, so that it joins
+	// the cluster again empty and auto recovery re-replicates its ledgers.
+	// Defaults to false.
+	// +optional
+	AutoRepair bool `json:"autoRepair,omitempty"`
+
+	// Paused stops the reconciliation of the cluster, e.g. while its pods
+	// are debugged manually. The operator leaves the cluster and its
+	// resources alone and only updates the status. Defaults to false.
+	// +optional
+	Paused bool `json:"paused,omitempty"`
+
 	// ServiceAccountName configures the service account used on BookKeeper instances
 	ServiceAccountName string `json:"serviceAccountName,omitempty"`
 
@@ -212,6 +285,13 @@ type BookkeeperClusterSpec struct {
 	// +optional
 	Options map[string]string `json:"options"`
 
+	// SecretOptions is the Bookkeeper configuration read from Secrets, e.g.
+	// passwords, which should not be set in Options as those are stored in
+	// the bookie ConfigMap. Each option is passed to the bookies as the
+	// BK_<option> environment variable.
+	// +optional
+	SecretOptions map[string]corev1.SecretKeySelector `json:"secretOptions,omitempty"`
+
 	// JVM is the JVM options for bookkeeper. It will be passed to the JVM for performance tuning.
 	// If this field is not specified, the operator will use a set of default
 	// options that is good enough for general deployment.
@@ -260,11 +340,267 @@ type BookkeeperClusterSpec struct {
 	//This is set to run the container as root user
 	RunAsPrivilegedUser *bool `json:"runAsPrivilegedUser,omitempty"`
 
+	// PodSecurityContext is the security context of the bookie pods.
+	// When set, it replaces the one derived from RunAsPrivilegedUser.
+	// +optional
+	PodSecurityContext *corev1.PodSecurityContext `json:"podSecurityContext,omitempty"`
+
+	// ContainerSecurityContext is the security context of the bookie
+	// containers. If it sets readOnlyRootFilesystem, the operator mounts
+	// writable emptyDir volumes for the bookie configuration, logs and tmp.
+	// +optional
+	ContainerSecurityContext *corev1.SecurityContext `json:"containerSecurityContext,omitempty"`
+
+	// PodSecurityLevel is the Pod Security Standard the bookie pods are
+	// expected to meet. The admission webhook warns about any setting that
+	// violates it.
+	// +kubebuilder:validation:Enum="privileged";"baseline";"restricted"
+	// +optional
+	PodSecurityLevel PodSecurityLevel `json:"podSecurityLevel,omitempty"`
+
 	// Tolerations for the bookie pods.
 	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
 
+	// NodeSelector for the bookie pods.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// PriorityClassName of the bookie pods.
+	// +optional
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+
+	// TopologySpreadConstraints for the bookie pods.
+	// +optional
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+
+	// TerminationGracePeriodSeconds of the bookie pods. Defaults to 60 for
+	// new clusters.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	TerminationGracePeriodSeconds *int64 `json:"terminationGracePeriodSeconds,omitempty"`
+
+	// GracefulShutdown configures the preStop hook of the bookies
+	// +optional
+	GracefulShutdown *GracefulShutdownSpec `json:"gracefulShutdown,omitempty"`
+
+	// DNSConfig of the bookie pods.
+	// +optional
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty"`
+
+	// HostNetwork runs the bookie pods in the host network namespace.
+	// The pod DNS policy is then set to ClusterFirstWithHostNet.
+	// +optional
+	HostNetwork bool `json:"hostNetwork,omitempty"`
+
+	// Sidecars are extra containers added to the bookie pods, e.g. log shippers.
+	// +optional
+	Sidecars []corev1.Container `json:"sidecars,omitempty"`
+
+	// RackAware spreads the bookies across zones and configures BookKeeper
+	// to place the ledger ensembles on bookies of different zones
+	// +optional
+	RackAware *RackAwareSpec `json:"rackAware,omitempty"`
+
+	// Env holds extra environment variables for the bookie container.
+	// They take precedence over the values from the bookie ConfigMaps.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+
 	// This is used to schedule the timeout value in minutes for rollback/upgrade
 	UpgradeTimeout int32 `json:"upgradeTimeout,omitempty"`
+
+	// ExternalAccess creates a Service for each bookie so that clients outside
+	// the namespace or the Kubernetes cluster can reach individual bookies
+	// +optional
+	ExternalAccess *ExternalAccess `json:"externalAccess,omitempty"`
+
+	// HTTPServer enables the bookie admin HTTP server
+	// +optional
+	HTTPServer *HTTPServerSpec `json:"httpServer,omitempty"`
+
+	// Metrics enables the bookie Prometheus endpoint
+	// +optional
+	Metrics *MetricsSpec `json:"metrics,omitempty"`
+}
+
+// GracefulShutdownSpec configures the preStop hook of the bookies. The hook
+// switches the bookie to read-only through the admin HTTP server, so it is
+// only added if spec.httpServer is enabled.
+type GracefulShutdownSpec struct {
+	// Enabled adds the preStop hook to the bookies. Defaults to true if
+	// spec.httpServer is enabled. The webhook refuses enabling it without
+	// the admin HTTP server.
+	// +optional
+	Enabled *bool `json:"enabled,omitempty"`
+
+	// DrainSeconds is how long the hook waits for in-flight requests to
+	// complete after switching the bookie to read-only. Defaults to 15.
+	// It must be lower than terminationGracePeriodSeconds.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	DrainSeconds int32 `json:"drainSeconds,omitempty"`
+}
+
+func (s *GracefulShutdownSpec) withDefaults() (changed bool) {
+	if s.DrainSeconds == 0 {
+		changed = true
+		s.DrainSeconds = DefaultDrainSeconds
+	}
+	return changed
+}
+
+// IsGracefulShutdownEnabled returns true if the bookies get the preStop hook
+func (bk *BookkeeperCluster) IsGracefulShutdownEnabled() bool {
+	gs := bk.Spec.GracefulShutdown
+	return bk.IsHTTPServerEnabled() && (gs == nil || gs.Enabled == nil || *gs.Enabled)
+}
+
+// RackAwareSpec configures the zone aware placement of the bookies
+type RackAwareSpec struct {
+	// Enabled spreads the bookies evenly across zones and uses the
+	// RackawareEnsemblePlacementPolicy with the zone of each bookie as its rack
+	Enabled bool `json:"enabled"`
+
+	// Zones are the zones the bookies are spread across. If given, the
+	// bookies are only scheduled in these zones and the webhook checks that
+	// the replicas divide evenly across them.
+	// +optional
+	Zones []string `json:"zones,omitempty"`
+}
+
+// IsRackAware returns true if the bookies are spread across zones
+func (bk *BookkeeperCluster) IsRackAware() bool {
+	return bk.Spec.RackAware != nil && bk.Spec.RackAware.Enabled
+}
+
+// HTTPServerSpec configures the bookie admin HTTP server
+type HTTPServerSpec struct {
+	// Enabled turns on the admin HTTP server of the bookies
+	Enabled bool `json:"enabled"`
+
+	// Port of the admin HTTP server. Defaults to 8080.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+}
+
+func (s *HTTPServerSpec) withDefaults() (changed bool) {
+	if s.Port == 0 {
+		changed = true
+		s.Port = DefaultHTTPServerPort
+	}
+	return changed
+}
+
+// MetricsSpec configures the bookie Prometheus endpoint and the
+// prometheus-operator resources scraping it
+type MetricsSpec struct {
+	// Enabled turns on the Prometheus stats provider of the bookies
+	Enabled bool `json:"enabled"`
+
+	// Port of the Prometheus endpoint. Defaults to 8000.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port int32 `json:"port,omitempty"`
+
+	// ServiceMonitor configures the prometheus-operator ServiceMonitor.
+	// It is only created if the ServiceMonitor CRD is installed.
+	// +optional
+	ServiceMonitor *ServiceMonitorSpec `json:"serviceMonitor,omitempty"`
+
+	// PrometheusRule configures the prometheus-operator PrometheusRule
+	// holding the BookKeeper alerts.
+	// It is only created if the PrometheusRule CRD is installed.
+	// +optional
+	PrometheusRule *PrometheusRuleSpec `json:"prometheusRule,omitempty"`
+}
+
+func (s *MetricsSpec) withDefaults() (changed bool) {
+	if s.Port == 0 {
+		changed = true
+		s.Port = DefaultMetricsPort
+	}
+	if s.ServiceMonitor == nil {
+		changed = true
+		s.ServiceMonitor = &ServiceMonitorSpec{Enabled: true}
+	}
+	return changed
+}
+
+// ServiceMonitorSpec configures the ServiceMonitor created for the bookies
+type ServiceMonitorSpec struct {
+	// Enabled turns on the creation of the ServiceMonitor
+	Enabled bool `json:"enabled"`
+
+	// Interval at which the bookies are scraped, e.g. "30s"
+	// +optional
+	Interval string `json:"interval,omitempty"`
+
+	// Labels to be added to the ServiceMonitor, so that it is selected by
+	// the Prometheus instance
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// PrometheusRuleSpec configures the PrometheusRule created for the bookies
+type PrometheusRuleSpec struct {
+	// Enabled turns on the creation of the PrometheusRule
+	Enabled bool `json:"enabled"`
+
+	// Labels to be added to the PrometheusRule, so that it is selected by
+	// the Prometheus instance
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// IsHTTPServerEnabled returns true if the bookie admin HTTP server is enabled
+func (bk *BookkeeperCluster) IsHTTPServerEnabled() bool {
+	return bk.Spec.HTTPServer != nil && bk.Spec.HTTPServer.Enabled
+}
+
+// IsMetricsEnabled returns true if the bookie Prometheus endpoint is enabled
+func (bk *BookkeeperCluster) IsMetricsEnabled() bool {
+	return bk.Spec.Metrics != nil && bk.Spec.Metrics.Enabled
+}
+
+// ExternalAccess configures the per-bookie Services
+type ExternalAccess struct {
+	// Enabled turns on the creation of a Service per bookie. Each bookie then
+	// advertises the address of its Service instead of the pod hostname.
+	// This changes the bookie IDs, so it can only be set at creation time.
+	Enabled bool `json:"enabled"`
+
+	// Type is the type of the per-bookie Services. Defaults to LoadBalancer.
+	// NodePort is refused for new clusters, as bookies advertise port 3181.
+	// +kubebuilder:validation:Enum="ClusterIP";"NodePort";"LoadBalancer"
+	// +optional
+	Type corev1.ServiceType `json:"type,omitempty"`
+
+	// Annotations to be added to the per-bookie Services. "$(POD_NAME)" in
+	// the values is replaced by the name of the bookie pod, e.g. to give each
+	// Service its own external-dns hostname.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// DomainName is the DNS domain the per-bookie Services are published
+	// under, e.g. by external-dns. Bookies advertise "<pod-name>.<domainName>".
+	// If empty, bookies advertise the in-cluster DNS name of their Service.
+	// +optional
+	DomainName string `json:"domainName,omitempty"`
+}
+
+func (s *ExternalAccess) withDefaults() (changed bool) {
+	if s.Type == "" {
+		changed = true
+		s.Type = corev1.ServiceTypeLoadBalancer
+	}
+	if s.Annotations == nil {
+		changed = true
+		s.Annotations = map[string]string{}
+	}
+	return changed
 }
 
 // BookkeeperImageSpec defines the fields needed for a BookKeeper Docker image
@@ -288,14 +624,40 @@ func (s *BookkeeperImageSpec) withDefaults() (changed bool) {
 	return changed
 }
 
+// ProbeMode selects how the bookie probes check the bookie
+type ProbeMode string
+
+const (
+	// ProbeModeExec runs the bookie shell sanity check for readiness and
+	// looks for the bookie port in the socket table for liveness
+	ProbeModeExec ProbeMode = "exec"
+
+	// ProbeModeHTTP queries the bookie admin HTTP server. It requires
+	// spec.httpServer to be enabled.
+	ProbeModeHTTP ProbeMode = "http"
+
+	// ProbeModeTCP opens a connection to the bookie port
+	ProbeModeTCP ProbeMode = "tcp"
+)
+
 type Probes struct {
+	// Mode selects how the probes check the bookie: exec, http or tcp.
+	// Defaults to exec.
+	// +kubebuilder:validation:Enum="exec";"http";"tcp"
+	// +optional
+	Mode ProbeMode `json:"mode,omitempty"`
 	// +optional
 	ReadinessProbe *Probe `json:"readinessProbe"`
 	// +optional
 	LivenessProbe *Probe `json:"livenessProbe"`
+	// StartupProbe holds off the liveness probe until the bookie has started,
+	// so that a slow journal replay does not get the bookie restarted.
+	// Only new clusters get a default startup probe.
+	// +optional
+	StartupProbe *Probe `json:"startupProbe"`
 }
 
-func (s *Probes) withDefaults() (changed bool) {
+func (s *Probes) withDefaults(isNew bool) (changed bool) {
 	if s.ReadinessProbe == nil {
 		changed = true
 		s.ReadinessProbe = &Probe{}
@@ -316,6 +678,20 @@ func (s *Probes) withDefaults() (changed bool) {
 		s.LivenessProbe.TimeoutSeconds = DefaultLivenessProbeTimeoutSeconds
 	}
 
+	if s.StartupProbe == nil && isNew {
+		changed = true
+		s.StartupProbe = &Probe{}
+		s.StartupProbe.PeriodSeconds = DefaultStartupProbePeriodSeconds
+		s.StartupProbe.FailureThreshold = DefaultStartupProbeFailureThreshold
+		s.StartupProbe.SuccessThreshold = DefaultStartupProbeSuccessThreshold
+		s.StartupProbe.TimeoutSeconds = DefaultStartupProbeTimeoutSeconds
+	}
+
+	if s.Mode == "" {
+		changed = true
+		s.Mode = ProbeModeExec
+	}
+
 	return changed
 }
 
@@ -391,6 +767,60 @@ type BookkeeperStorageSpec struct {
 	// stateful containers will use emptyDir as volume
 	// +optional
 	IndexVolumeClaimTemplate *corev1.PersistentVolumeClaimSpec `json:"indexVolumeClaimTemplate"`
+
+	// Mode selects how the ledger, journal and index volumes are provisioned.
+	// PersistentVolumeClaim (default) uses the volume claim templates above.
+	// Local makes the operator create a local PersistentVolume per bookie and
+	// volume, pinned to the node listed for the bookie ordinal.
+	// HostPath does the same with hostPath PersistentVolumes, and creates the
+	// directories on the node if they don't exist.
+	// The mode cannot be changed once the cluster is created.
+	// +kubebuilder:validation:Enum="PersistentVolumeClaim";"Local";"HostPath"
+	// +optional
+	Mode StorageMode `json:"mode,omitempty"`
+
+	// Local configures the node directories used when Mode is Local or HostPath
+	// +optional
+	Local *LocalStorageSpec `json:"local,omitempty"`
+}
+
+// StorageMode is the way bookie volumes are provisioned
+type StorageMode string
+
+const (
+	StorageModePersistentVolumeClaim StorageMode = "PersistentVolumeClaim"
+	StorageModeLocal                 StorageMode = "Local"
+	StorageModeHostPath              StorageMode = "HostPath"
+)
+
+// LocalStorageSpec describes the node local directories backing the bookie volumes
+type LocalStorageSpec struct {
+	// Nodes lists the nodes holding bookie data. Bookie ordinal i is pinned to
+	// Nodes[i].
+	Nodes []string `json:"nodes"`
+
+	// LedgerPath is the directory on the node backing the ledger volume
+	LedgerPath string `json:"ledgerPath"`
+
+	// JournalPath is the directory on the node backing the journal volume
+	JournalPath string `json:"journalPath"`
+
+	// IndexPath is the directory on the node backing the index volume
+	IndexPath string `json:"indexPath"`
+
+	// StorageClassName is set on the node local PersistentVolumes and on the volume
+	// claim templates so that each claim binds to its pre-created volume.
+	// Defaults to "bookkeeper-local".
+	// +optional
+	StorageClassName string `json:"storageClassName,omitempty"`
+}
+
+func (s *LocalStorageSpec) withDefaults() (changed bool) {
+	if s.StorageClassName == "" {
+		changed = true
+		s.StorageClassName = DefaultLocalStorageClassName
+	}
+	return changed
 }
 
 func (s *BookkeeperStorageSpec) withDefaults() (changed bool) {
@@ -429,9 +859,32 @@ func (s *BookkeeperStorageSpec) withDefaults() (changed bool) {
 			},
 		}
 	}
+
+	if s.Mode == "" {
+		changed = true
+		s.Mode = StorageModePersistentVolumeClaim
+	}
+
+	if s.Local != nil && s.Local.withDefaults() {
+		changed = true
+	}
 	return changed
 }
 
+// GetMode returns the storage mode, PersistentVolumeClaim if it is not set
+func (s *BookkeeperStorageSpec) GetMode() StorageMode {
+	if s == nil || s.Mode == "" {
+		return StorageModePersistentVolumeClaim
+	}
+	return s.Mode
+}
+
+// IsNodeLocal returns true if the bookie volumes live on the node's own disks
+func (s *BookkeeperStorageSpec) IsNodeLocal() bool {
+	mode := s.GetMode()
+	return mode == StorageModeLocal || mode == StorageModeHostPath
+}
+
 func (s *BookkeeperClusterSpec) withDefaults(bk *BookkeeperCluster) (changed bool) {
 	if s.ZookeeperUri == "" {
 		changed = true
@@ -474,7 +927,7 @@ func (s *BookkeeperClusterSpec) withDefaults(bk *BookkeeperCluster) (changed boo
 		changed = true
 		s.Probes = &Probes{}
 	}
-	if s.Probes.withDefaults() {
+	if s.Probes.withDefaults(bk.isNew()) {
 		changed = true
 	}
 
@@ -545,6 +998,32 @@ func (s *BookkeeperClusterSpec) withDefaults(bk *BookkeeperCluster) (changed boo
 		s.UpgradeTimeout = 10
 	}
 
+	if s.ExternalAccess != nil && s.ExternalAccess.withDefaults() {
+		changed = true
+	}
+
+	if s.HTTPServer != nil && s.HTTPServer.withDefaults() {
+		changed = true
+	}
+
+	if s.TerminationGracePeriodSeconds == nil && bk.isNew() {
+		changed = true
+		grace := int64(DefaultTerminationGracePeriodSeconds)
+		s.TerminationGracePeriodSeconds = &grace
+	}
+
+	if s.GracefulShutdown == nil {
+		changed = true
+		s.GracefulShutdown = &GracefulShutdownSpec{}
+	}
+	if s.GracefulShutdown.withDefaults() {
+		changed = true
+	}
+
+	if s.Metrics != nil && s.Metrics.withDefaults() {
+		changed = true
+	}
+
 	return changed
 }
 
@@ -605,6 +1084,21 @@ func (bk *BookkeeperCluster) HeadlessServiceNameForBookie() string {
 	return fmt.Sprintf("%s-%s", bk.Name, bk.Spec.HeadlessSvcNameSuffix)
 }
 
+// IsExternalAccessEnabled returns true if a Service is created for each bookie
+func (bk *BookkeeperCluster) IsExternalAccessEnabled() bool {
+	return bk.Spec.ExternalAccess != nil && bk.Spec.ExternalAccess.Enabled
+}
+
+// AdvertisedAddressForBookie returns the address each bookie advertises when
+// external access is enabled. POD_NAME and POD_NAMESPACE are expanded by
+// Kubernetes from the bookie container environment.
+func (bk *BookkeeperCluster) AdvertisedAddressForBookie() string {
+	if bk.Spec.ExternalAccess.DomainName != "" {
+		return fmt.Sprintf("$(POD_NAME).%s", bk.Spec.ExternalAccess.DomainName)
+	}
+	return "$(POD_NAME).$(POD_NAMESPACE).svc.cluster.local"
+}
+
 // Wait for pods in cluster to be terminated
 func (bk *BookkeeperCluster) WaitForClusterToTerminate(kubeClient client.Client) (err error) {
 	listOptions := &client.ListOptions{
@@ -633,62 +1127,6 @@ func (bk *BookkeeperCluster) WaitForClusterToTerminate(kubeClient client.Client)
 	return err
 }
 
-func (bk *BookkeeperCluster) NewEvent(name string, reason string, message string, eventType string) *corev1.Event {
-	now := metav1.Now()
-	operatorName, _ := GetOperatorName()
-	generateName := name + "-"
-	event := corev1.Event{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: generateName,
-			Namespace:    bk.Namespace,
-			Labels:       bk.LabelsForBookkeeperCluster(),
-		},
-		InvolvedObject: corev1.ObjectReference{
-			APIVersion:      "bookkeeper.pravega.io/v1alpha1",
-			Kind:            "BookkeeperCluster",
-			Name:            bk.GetName(),
-			Namespace:       bk.GetNamespace(),
-			ResourceVersion: bk.GetResourceVersion(),
-			UID:             bk.GetUID(),
-		},
-		Reason:              reason,
-		Message:             message,
-		FirstTimestamp:      now,
-		LastTimestamp:       now,
-		Type:                eventType,
-		ReportingController: operatorName,
-		ReportingInstance:   os.Getenv("POD_NAME"),
-	}
-	return &event
-}
-
-func (bk *BookkeeperCluster) NewApplicationEvent(name string, reason string, message string, eventType string) *corev1.Event {
-	now := metav1.Now()
-	operatorName, _ := GetOperatorName()
-	generateName := name + "-"
-	event := corev1.Event{
-		ObjectMeta: metav1.ObjectMeta{
-			GenerateName: generateName,
-			Namespace:    bk.Namespace,
-			Labels:       bk.LabelsForBookkeeperCluster(),
-		},
-		InvolvedObject: corev1.ObjectReference{
-			APIVersion: "app.k8s.io/v1beta1",
-			Kind:       "Application",
-			Name:       "bookkeeper-cluster",
-			Namespace:  bk.GetNamespace(),
-		},
-		Reason:              reason,
-		Message:             message,
-		FirstTimestamp:      now,
-		LastTimestamp:       now,
-		Type:                eventType,
-		ReportingController: operatorName,
-		ReportingInstance:   os.Getenv("POD_NAME"),
-	}
-	return &event
-}
-
 // GetOperatorName returns the operator name
 func GetOperatorName() (string, error) {
 	operatorName, found := os.LookupEnv(OperatorNameEnvVar)
@@ -62,43 +62,6 @@ var _ = Describe("BookkeeperCluster Types Spec", func() {
 		})
 	})
 
-	Context("NewEvent", func() {
-		var bk *v1alpha1.BookkeeperCluster
-		var event *corev1.Event
-		BeforeEach(func() {
-			bk = &v1alpha1.BookkeeperCluster{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "default",
-				},
-			}
-			bk.WithDefaults()
-			message := "upgrade failed"
-			event = bk.NewEvent("bookie", "UPGRADE_ERROR", message, "Error")
-		})
-
-		It("Event size should not be zero", func() {
-			Ω(event.Size()).ShouldNot(Equal(0))
-		})
-	})
-
-	Context("NewApplicationEvent", func() {
-		var bk *v1alpha1.BookkeeperCluster
-		var event *corev1.Event
-		BeforeEach(func() {
-			bk = &v1alpha1.BookkeeperCluster{
-				ObjectMeta: metav1.ObjectMeta{
-					Name: "default",
-				},
-			}
-			bk.WithDefaults()
-			message := "upgrade failed"
-			event = bk.NewApplicationEvent("bookie", "UPGRADE_ERROR", message, "Error")
-		})
-		It("Event size should not be zero", func() {
-			Ω(event.Size()).ShouldNot(Equal(0))
-		})
-	})
-
 	Context("WaitForClusterToTerminate", func() {
 		var bk *v1alpha1.BookkeeperCluster
 		var client client.Client
@@ -392,6 +355,253 @@ var _ = Describe("BookkeeperCluster Types Spec", func() {
 		})
 	})
 
+	Context("ValidateStorage", func() {
+		var err error
+		BeforeEach(func() {
+			bk.Spec.Storage = &v1alpha1.BookkeeperStorageSpec{
+				Mode: v1alpha1.StorageModeLocal,
+				Local: &v1alpha1.LocalStorageSpec{
+					Nodes:       []string{"node-0", "node-1", "node-2"},
+					LedgerPath:  "/mnt/ledgers",
+					JournalPath: "/mnt/journal",
+					IndexPath:   "/mnt/index",
+				},
+			}
+		})
+		It("should accept enough distinct nodes", func() {
+			err = bk.ValidateStorage()
+			Ω(err).Should(BeNil())
+		})
+		It("should refuse fewer nodes than replicas", func() {
+			bk.Spec.Replicas = 4
+			err = bk.ValidateStorage()
+			Ω(err.Error()).Should(ContainSubstring("requires at least 4 nodes"))
+		})
+		It("should refuse duplicate nodes", func() {
+			bk.Spec.Storage.Local.Nodes[2] = "node-0"
+			err = bk.ValidateStorage()
+			Ω(err.Error()).Should(ContainSubstring("listed more than once"))
+		})
+		It("should refuse relative paths", func() {
+			bk.Spec.Storage.Local.IndexPath = "mnt/index"
+			err = bk.ValidateStorage()
+			Ω(err.Error()).Should(ContainSubstring("indexPath"))
+		})
+		It("should refuse a missing node list", func() {
+			bk.Spec.Storage.Local = nil
+			err = bk.ValidateStorage()
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("ValidateProbes", func() {
+		BeforeEach(func() {
+			bk.Spec.Probes = &v1alpha1.Probes{Mode: v1alpha1.ProbeModeHTTP}
+		})
+		It("should refuse http probes without the http server", func() {
+			err := bk.ValidateProbes()
+			Ω(err.Error()).Should(ContainSubstring("requires spec.httpServer"))
+		})
+		It("should accept http probes with the http server", func() {
+			bk.Spec.HTTPServer = &v1alpha1.HTTPServerSpec{Enabled: true}
+			err := bk.ValidateProbes()
+			Ω(err).Should(BeNil())
+		})
+	})
+
+	Context("ValidatePorts", func() {
+		BeforeEach(func() {
+			bk.Spec.HTTPServer = &v1alpha1.HTTPServerSpec{Enabled: true}
+			bk.Spec.Metrics = &v1alpha1.MetricsSpec{Enabled: true}
+		})
+		It("should accept the default ports", func() {
+			err := bk.ValidatePorts()
+			Ω(err).Should(BeNil())
+		})
+		It("should refuse the bookie port", func() {
+			bk.Spec.Metrics.Port = 3181
+			err := bk.ValidatePorts()
+			Ω(err.Error()).Should(ContainSubstring("already used by the bookie port"))
+		})
+		It("should refuse duplicate ports", func() {
+			bk.Spec.Metrics.Port = 8080
+			err := bk.ValidatePorts()
+			Ω(err.Error()).Should(ContainSubstring("already used by the httpServer port"))
+		})
+		It("should ignore the ports of disabled endpoints", func() {
+			bk.Spec.HTTPServer.Enabled = false
+			bk.Spec.Metrics.Port = 8080
+			err := bk.ValidatePorts()
+			Ω(err).Should(BeNil())
+		})
+	})
+
+	Context("ValidateExternalAccess", func() {
+		BeforeEach(func() {
+			bk.Spec.ExternalAccess = &v1alpha1.ExternalAccess{Enabled: true, Type: corev1.ServiceTypeNodePort}
+		})
+		It("should refuse node ports", func() {
+			err := bk.ValidateCreate()
+			Ω(err.Error()).Should(ContainSubstring("not supported"))
+		})
+		It("should refuse a change to node ports", func() {
+			old := bk.DeepCopy()
+			old.Spec.ExternalAccess.Type = corev1.ServiceTypeLoadBalancer
+			err := bk.ValidateUpdate(old)
+			Ω(err.Error()).Should(ContainSubstring("not supported"))
+		})
+		It("should accept updates of clusters created with node ports", func() {
+			err := bk.ValidateUpdate(bk.DeepCopy())
+			Ω(err).Should(BeNil())
+		})
+	})
+
+	Context("PodSecurityWarnings", func() {
+		BeforeEach(func() {
+			bk.WithDefaults()
+		})
+		It("should not warn without a pod security level", func() {
+			Ω(bk.PodSecurityWarnings()).Should(BeEmpty())
+		})
+		It("should warn about hostPath volumes under the baseline level", func() {
+			bk.Spec.PodSecurityLevel = v1alpha1.PodSecurityLevelBaseline
+			bk.Spec.Options["hostPathVolumeMounts"] = "heap=/tmp/heap"
+			warnings := bk.PodSecurityWarnings()
+			Ω(warnings).Should(HaveLen(1))
+			Ω(warnings[0]).Should(ContainSubstring("hostPathVolumeMounts"))
+		})
+		It("should warn about the default pod under the restricted level", func() {
+			bk.Spec.PodSecurityLevel = v1alpha1.PodSecurityLevelRestricted
+			warnings := bk.PodSecurityWarnings()
+			Ω(warnings).Should(HaveLen(4))
+			Ω(warnings[0]).Should(ContainSubstring("runAsNonRoot"))
+		})
+		It("should accept a hardened pod under the restricted level", func() {
+			nonRoot := true
+			noEscalation := false
+			bk.Spec.PodSecurityLevel = v1alpha1.PodSecurityLevelRestricted
+			bk.Spec.PodSecurityContext = &corev1.PodSecurityContext{
+				RunAsNonRoot:   &nonRoot,
+				SeccompProfile: &corev1.SeccompProfile{Type: corev1.SeccompProfileTypeRuntimeDefault},
+			}
+			bk.Spec.ContainerSecurityContext = &corev1.SecurityContext{
+				AllowPrivilegeEscalation: &noEscalation,
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			}
+			Ω(bk.PodSecurityWarnings()).Should(BeEmpty())
+		})
+	})
+
+	Context("ValidateRackAware", func() {
+		BeforeEach(func() {
+			bk.Spec.RackAware = &v1alpha1.RackAwareSpec{
+				Enabled: true,
+				Zones:   []string{"zone-a", "zone-b", "zone-c"},
+			}
+		})
+		It("should accept replicas spread evenly across zones", func() {
+			bk.Spec.Replicas = 6
+			Ω(bk.ValidateRackAware()).Should(BeNil())
+		})
+		It("should refuse replicas not divisible by the zones", func() {
+			bk.Spec.Replicas = 4
+			err := bk.ValidateRackAware()
+			Ω(err.Error()).Should(ContainSubstring("can not be spread evenly"))
+		})
+		It("should refuse a single zone", func() {
+			bk.Spec.RackAware.Zones = []string{"zone-a"}
+			err := bk.ValidateRackAware()
+			Ω(err.Error()).Should(ContainSubstring("at least 2 zones"))
+		})
+	})
+
+	Context("ValidateGracefulShutdown", func() {
+		BeforeEach(func() {
+			bk.WithDefaults()
+		})
+		It("should accept the defaults", func() {
+			Ω(bk.ValidateGracefulShutdown()).Should(BeNil())
+		})
+		It("should refuse a drain longer than the grace period", func() {
+			bk.Spec.GracefulShutdown.DrainSeconds = 90
+			err := bk.ValidateGracefulShutdown()
+			Ω(err.Error()).Should(ContainSubstring("should be greater than"))
+		})
+		It("should refuse the hook without the http server", func() {
+			enabled := true
+			bk.Spec.GracefulShutdown.Enabled = &enabled
+			err := bk.ValidateCreate()
+			Ω(err.Error()).Should(ContainSubstring("requires spec.httpServer"))
+			Ω(bk.ValidateUpdate(bk.DeepCopy())).Should(BeNil())
+		})
+		It("should warn that the hook needs the http server", func() {
+			Ω(bk.Warnings()).Should(ContainElement(ContainSubstring("graceful shutdown")))
+			bk.Spec.HTTPServer = &v1alpha1.HTTPServerSpec{Enabled: true}
+			Ω(bk.Warnings()).Should(BeEmpty())
+		})
+	})
+
+	Context("ValidateOptions", func() {
+		It("should refuse credentials in options", func() {
+			bk.Spec.Options = map[string]string{"tlsKeyStorePassword": "changeit"}
+			err := bk.ValidateOptions(nil)
+			Ω(err.Error()).Should(ContainSubstring("should be set in spec.secretOptions"))
+		})
+		It("should accept credentials already in the options of an updated cluster", func() {
+			bk.Spec.Options = map[string]string{"tlsKeyStorePassword": "changeit"}
+			old := bk.DeepCopy()
+			bk.Spec.Replicas = 5
+			Ω(bk.ValidateOptions(old.Spec.Options)).Should(BeNil())
+			Ω(bk.ValidateUpdate(old)).Should(BeNil())
+		})
+		It("should refuse credentials added to the options of an updated cluster", func() {
+			old := bk.DeepCopy()
+			bk.Spec.Options = map[string]string{"tlsKeyStorePassword": "changeit"}
+			err := bk.ValidateUpdate(old)
+			Ω(err.Error()).Should(ContainSubstring("should be set in spec.secretOptions"))
+		})
+		It("should accept options which only look like credentials", func() {
+			bk.Spec.Options = map[string]string{"journalSyncData": "true", "secretsProviderEnabled": "false", "tokenBucketSize": "10"}
+			Ω(bk.ValidateOptions(nil)).Should(BeNil())
+		})
+		It("should accept the paths of credential files in options", func() {
+			bk.Spec.Options = map[string]string{"tlsKeyStorePasswordPath": "/etc/tls/password"}
+			Ω(bk.ValidateOptions(nil)).Should(BeNil())
+		})
+		It("should refuse an option set twice", func() {
+			bk.Spec.Options = map[string]string{"zkServers": "zk:2181"}
+			bk.Spec.SecretOptions = map[string]corev1.SecretKeySelector{
+				"zkServers": {LocalObjectReference: corev1.LocalObjectReference{Name: "zk"}, Key: "servers"},
+			}
+			err := bk.ValidateOptions(nil)
+			Ω(err.Error()).Should(ContainSubstring("both"))
+		})
+		It("should refuse secret options without a key", func() {
+			bk.Spec.SecretOptions = map[string]corev1.SecretKeySelector{
+				"tlsKeyStorePassword": {LocalObjectReference: corev1.LocalObjectReference{Name: "tls"}},
+			}
+			err := bk.ValidateOptions(nil)
+			Ω(err.Error()).Should(ContainSubstring("should name a secret and a key"))
+		})
+	})
+
+	Context("ValidateZookeeper", func() {
+		It("should accept connect strings with several hosts and a chroot", func() {
+			bk.Spec.ZookeeperUri = "zk-0.zk:2181,zk-1.zk:2181/bookkeeper"
+			Ω(bk.ValidateZookeeper()).Should(BeNil())
+		})
+		It("should refuse an invalid connect string", func() {
+			bk.Spec.ZookeeperUri = "zk:port"
+			err := bk.ValidateZookeeper()
+			Ω(err.Error()).Should(ContainSubstring("invalid port"))
+		})
+		It("should refuse an auth without secret", func() {
+			bk.Spec.Zookeeper = &v1alpha1.ZookeeperClientSpec{Auth: &v1alpha1.ZookeeperAuthSpec{}}
+			err := bk.ValidateZookeeper()
+			Ω(err.Error()).Should(ContainSubstring("spec.zookeeper.auth.secretName"))
+		})
+	})
+
 	Context("HeadlessServiceNameForBookie", func() {
 		var str1 string
 		BeforeEach(func() {
@@ -23,27 +23,76 @@ import (
 	"strings"
 
 	"github.com/pravega/bookkeeper-operator/pkg/util"
+	admissionv1 "k8s.io/api/admission/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
-	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
 )
 
-var Mgr manager.Manager
-
 // log is for logging in this package.
 var bookkeeperclusterlog = logf.Log.WithName("bookkeepercluster-resource")
 
 func (r *BookkeeperCluster) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	validator := &bookkeeperClusterValidator{client: mgr.GetClient()}
+
+	// The validating webhook is registered ahead of the builder, which then
+	// skips its path, so that Pod Security warnings can be added to the response
+	vwh := admission.WithCustomValidator(r, validator)
+	vwh.Handler = &podSecurityWarningHandler{Handler: vwh.Handler}
+	mgr.GetWebhookServer().Register(validatingWebhookPath, vwh)
+
 	return ctrl.NewWebhookManagedBy(mgr).
 		For(r).
+		WithValidator(validator).
 		Complete()
 }
 
+const validatingWebhookPath = "/validate-bookkeeper-pravega-io-v1alpha1-bookkeepercluster"
+
+// podSecurityWarningHandler adds the Pod Security violations of the bookie
+// pods and the other warnings of the cluster to the responses of the
+// wrapped validating handler
+type podSecurityWarningHandler struct {
+	admission.Handler
+	decoder *admission.Decoder
+}
+
+// InjectDecoder injects the decoder into the handler and the wrapped handler
+func (h *podSecurityWarningHandler) InjectDecoder(d *admission.Decoder) error {
+	h.decoder = d
+	_, err := admission.InjectDecoderInto(d, h.Handler)
+	return err
+}
+
+func (h *podSecurityWarningHandler) Handle(ctx context.Context, req admission.Request) admission.Response {
+	resp := h.Handler.Handle(ctx, req)
+	if !resp.Allowed || req.Operation == admissionv1.Delete || h.decoder == nil {
+		return resp
+	}
+	bk := &BookkeeperCluster{}
+	if err := h.decoder.Decode(req, bk); err != nil {
+		return resp
+	}
+	return resp.WithWarnings(bk.Warnings()...)
+}
+
+// Warnings lists the settings of the cluster the validating webhook accepts
+// but warns about
+func (bk *BookkeeperCluster) Warnings() []string {
+	warnings := bk.PodSecurityWarnings()
+	gs := bk.Spec.GracefulShutdown
+	if !bk.IsHTTPServerEnabled() && (gs == nil || gs.Enabled == nil) {
+		warnings = append(warnings, "bookies get no graceful shutdown preStop hook unless spec.httpServer is enabled")
+	}
+	return warnings
+}
+
 //+kubebuilder:webhook:path=/mutate-bookkeeper-pravega-io-v1alpha1-bookkeepercluster,mutating=true,failurePolicy=fail,sideEffects=None,groups=bookkeeper.pravega.io,resources=bookkeeperclusters,verbs=create;update,versions=v1alpha1,name=mbookkeepercluster.kb.io,admissionReviewVersions=v1
 
 var _ webhook.Defaulter = &BookkeeperCluster{}
@@ -60,6 +109,43 @@ func (bk *BookkeeperCluster) Default() {
 
 var _ webhook.Validator = &BookkeeperCluster{}
 
+// bookkeeperClusterValidator validates BookkeeperClusters with the checks of
+// webhook.Validator, plus the checks reading other objects through its client.
+// It holds no other state, so requests can be validated concurrently.
+type bookkeeperClusterValidator struct {
+	client client.Client
+}
+
+var _ admission.CustomValidator = &bookkeeperClusterValidator{}
+
+func (v *bookkeeperClusterValidator) ValidateCreate(ctx context.Context, obj runtime.Object) error {
+	bk, ok := obj.(*BookkeeperCluster)
+	if !ok {
+		return fmt.Errorf("expected a BookkeeperCluster but got a %T", obj)
+	}
+	return bk.ValidateCreate()
+}
+
+func (v *bookkeeperClusterValidator) ValidateUpdate(ctx context.Context, oldObj, newObj runtime.Object) error {
+	bk, ok := newObj.(*BookkeeperCluster)
+	if !ok {
+		return fmt.Errorf("expected a BookkeeperCluster but got a %T", newObj)
+	}
+	err := bk.ValidateUpdate(oldObj)
+	if err != nil {
+		return err
+	}
+	return bk.validateConfigMap(ctx, v.client)
+}
+
+func (v *bookkeeperClusterValidator) ValidateDelete(ctx context.Context, obj runtime.Object) error {
+	bk, ok := obj.(*BookkeeperCluster)
+	if !ok {
+		return fmt.Errorf("expected a BookkeeperCluster but got a %T", obj)
+	}
+	return bk.ValidateDelete()
+}
+
 // ValidateCreate implements webhook.Validator so a webhook will be registered for the type
 func (bk *BookkeeperCluster) ValidateCreate() error {
 	bookkeeperclusterlog.Info("validate create", "name", bk.Name)
@@ -72,6 +158,42 @@ func (bk *BookkeeperCluster) ValidateCreate() error {
 	if err != nil {
 		return err
 	}
+	err = bk.ValidateStorage()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateProbes()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidatePorts()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateRackAware()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateGracefulShutdown()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateOptions(nil)
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateZookeeper()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateRestore()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateExternalAccess()
+	if err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -83,10 +205,63 @@ func (bk *BookkeeperCluster) ValidateUpdate(old runtime.Object) error {
 	if err != nil {
 		return err
 	}
-	err = bk.validateConfigMap()
+	err = bk.ValidateStorage()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateProbes()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidatePorts()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateRackAware()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateGracefulShutdown()
+	if err != nil {
+		// clusters defaulted to the hook without the http server can still be updated
+		if oldBk, ok := old.(*BookkeeperCluster); !ok || oldBk.ValidateGracefulShutdown() == nil {
+			return err
+		}
+	}
+	var oldOptions map[string]string
+	if oldBk, ok := old.(*BookkeeperCluster); ok {
+		oldOptions = oldBk.Spec.Options
+	}
+	err = bk.ValidateOptions(oldOptions)
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateZookeeper()
+	if err != nil {
+		return err
+	}
+	err = bk.ValidateRestore()
 	if err != nil {
 		return err
 	}
+	if oldBk, ok := old.(*BookkeeperCluster); ok {
+		if oldBk.Spec.Storage.GetMode() != bk.Spec.Storage.GetMode() {
+			return fmt.Errorf("storage mode should not be changed")
+		}
+		if oldBk.IsExternalAccessEnabled() != bk.IsExternalAccessEnabled() {
+			return fmt.Errorf("value of externalAccess.enabled should not be changed")
+		}
+		if bk.IsExternalAccessEnabled() && oldBk.Spec.ExternalAccess.DomainName != bk.Spec.ExternalAccess.DomainName {
+			return fmt.Errorf("value of externalAccess.domainName should not be changed")
+		}
+		// clusters created with the NodePort type can still be updated
+		if bk.IsExternalAccessEnabled() && oldBk.Spec.ExternalAccess.Type != bk.Spec.ExternalAccess.Type {
+			err = bk.ValidateExternalAccess()
+			if err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
@@ -171,9 +346,180 @@ func (bk *BookkeeperCluster) ValidateAbsolutePath(dirs []string) error {
 	return nil
 }
 
-func (bk *BookkeeperCluster) validateConfigMap() error {
+// ValidateStorage checks that node local storage modes name enough distinct
+// nodes for all bookies, and absolute directories for every volume
+func (bk *BookkeeperCluster) ValidateStorage() error {
+	mode := bk.Spec.Storage.GetMode()
+	if mode != StorageModeLocal && mode != StorageModeHostPath {
+		return nil
+	}
+	local := bk.Spec.Storage.Local
+	if local == nil || len(local.Nodes) == 0 {
+		return fmt.Errorf("storage mode %s requires the nodes holding bookie data in spec.storage.local.nodes", mode)
+	}
+	replicas := bk.Spec.Replicas
+	if replicas == 0 {
+		replicas = DefaultBookkeeperReplicas
+	}
+	if int32(len(local.Nodes)) < replicas {
+		return fmt.Errorf("storage mode %s requires at least %d nodes, only %d given", mode, replicas, len(local.Nodes))
+	}
+	seen := map[string]bool{}
+	for _, node := range local.Nodes {
+		if seen[node] {
+			return fmt.Errorf("node %s is listed more than once in spec.storage.local.nodes", node)
+		}
+		seen[node] = true
+	}
+	paths := map[string]string{
+		"ledgerPath":  local.LedgerPath,
+		"journalPath": local.JournalPath,
+		"indexPath":   local.IndexPath,
+	}
+	for _, name := range []string{"ledgerPath", "journalPath", "indexPath"} {
+		if !strings.HasPrefix(paths[name], "/") {
+			return fmt.Errorf("path (%s) of %s should start with /", paths[name], name)
+		}
+	}
+	return nil
+}
+
+// ValidateExternalAccess checks that the per-bookie Services expose the port
+// the bookies advertise. Bookies advertise the port they listen on, 3181,
+// which clients can't reach through a node port.
+func (bk *BookkeeperCluster) ValidateExternalAccess() error {
+	if bk.IsExternalAccessEnabled() && bk.Spec.ExternalAccess.Type == corev1.ServiceTypeNodePort {
+		return fmt.Errorf("externalAccess.type %s is not supported, bookies advertise port 3181 and not the node port", corev1.ServiceTypeNodePort)
+	}
+	return nil
+}
+
+// ValidatePorts checks that the admin HTTP server and the Prometheus endpoint
+// listen on distinct ports, none of them the bookie port
+func (bk *BookkeeperCluster) ValidatePorts() error {
+	ports := map[int32]string{DefaultBookiePort: "bookie"}
+	check := func(name string, port int32) error {
+		if other, ok := ports[port]; ok {
+			return fmt.Errorf("%s port %d is already used by the %s port", name, port, other)
+		}
+		ports[port] = name
+		return nil
+	}
+	if bk.IsHTTPServerEnabled() {
+		port := bk.Spec.HTTPServer.Port
+		if port == 0 {
+			port = DefaultHTTPServerPort
+		}
+		if err := check("httpServer", port); err != nil {
+			return err
+		}
+	}
+	if bk.IsMetricsEnabled() {
+		port := bk.Spec.Metrics.Port
+		if port == 0 {
+			port = DefaultMetricsPort
+		}
+		if err := check("metrics", port); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ValidateProbes checks that the HTTP probe mode is only used together with
+// the bookie admin HTTP server
+func (bk *BookkeeperCluster) ValidateProbes() error {
+	if bk.Spec.Probes != nil && bk.Spec.Probes.Mode == ProbeModeHTTP && !bk.IsHTTPServerEnabled() {
+		return fmt.Errorf("probe mode %s requires spec.httpServer to be enabled", ProbeModeHTTP)
+	}
+	return nil
+}
+
+// ValidateRackAware checks that the bookies divide evenly across the zones
+// of a rack aware cluster, so that losing a zone loses the same share of
+// bookies wherever it happens
+func (bk *BookkeeperCluster) ValidateRackAware() error {
+	if !bk.IsRackAware() || len(bk.Spec.RackAware.Zones) == 0 {
+		return nil
+	}
+	zones := int32(len(bk.Spec.RackAware.Zones))
+	if zones < 2 {
+		return fmt.Errorf("rack aware placement requires at least 2 zones, only %d given", zones)
+	}
+	replicas := bk.Spec.Replicas
+	if replicas == 0 {
+		replicas = DefaultBookkeeperReplicas
+	}
+	if replicas%zones != 0 {
+		return fmt.Errorf("%d replicas can not be spread evenly across %d zones", replicas, zones)
+	}
+	return nil
+}
+
+// ValidateGracefulShutdown checks that the preStop hook can switch the bookie
+// to read-only and complete within the termination grace period
+func (bk *BookkeeperCluster) ValidateGracefulShutdown() error {
+	gs := bk.Spec.GracefulShutdown
+	if gs != nil && gs.Enabled != nil && *gs.Enabled && !bk.IsHTTPServerEnabled() {
+		return fmt.Errorf("gracefulShutdown requires spec.httpServer to be enabled")
+	}
+	grace := bk.Spec.TerminationGracePeriodSeconds
+	if gs == nil || grace == nil || (gs.Enabled != nil && !*gs.Enabled) {
+		return nil
+	}
+	drain := int64(gs.DrainSeconds)
+	if drain == 0 {
+		drain = DefaultDrainSeconds
+	}
+	if *grace <= drain {
+		return fmt.Errorf("terminationGracePeriodSeconds (%d) should be greater than gracefulShutdown.drainSeconds (%d)", *grace, drain)
+	}
+	return nil
+}
+
+// sensitiveOptions are the Bookkeeper options whose value is a credential.
+// The options naming the file holding a credential, e.g.
+// tlsKeyStorePasswordPath, are not sensitive.
+var sensitiveOptions = map[string]bool{
+	"tlsKeyStorePassword":          true,
+	"tlsTrustStorePassword":        true,
+	"httpServerKeystorePassword":   true,
+	"httpServerTrustStorePassword": true,
+	"zkDigestCredentials":          true,
+}
+
+// IsSensitiveOption returns whether the value of the given Bookkeeper option is
+// a credential
+func IsSensitiveOption(name string) bool {
+	return sensitiveOptions[name]
+}
+
+// ValidateOptions checks that credentials are set in secretOptions rather
+// than in options, which end up in the bookie ConfigMap. The credentials in
+// existing, i.e. the options of the cluster being updated, are still accepted
+// so that the clusters which already have some can be updated.
+func (bk *BookkeeperCluster) ValidateOptions(existing map[string]string) error {
+	for name := range bk.Spec.Options {
+		if _, ok := existing[name]; !ok && IsSensitiveOption(name) {
+			return fmt.Errorf("option %s holds a credential and should be set in spec.secretOptions", name)
+		}
+		if _, ok := bk.Spec.SecretOptions[name]; ok {
+			return fmt.Errorf("option %s is set in both spec.options and spec.secretOptions", name)
+		}
+	}
+	for name, selector := range bk.Spec.SecretOptions {
+		if selector.Name == "" || selector.Key == "" {
+			return fmt.Errorf("secret option %s should name a secret and a key", name)
+		}
+	}
+	return nil
+}
+
+// validateConfigMap checks that the options which can not be changed once the
+// bookies are formatted match the current bookie configmap
+func (bk *BookkeeperCluster) validateConfigMap(ctx context.Context, c client.Client) error {
 	configmap := &corev1.ConfigMap{}
-	err := Mgr.GetClient().Get(context.TODO(),
+	err := c.Get(ctx,
 		types.NamespacedName{Name: util.ConfigMapNameForBookie(bk.Name), Namespace: bk.Namespace}, configmap)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -0,0 +1,146 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package v1alpha1
+
+import (
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// PodSecurityLevel is a level of the Kubernetes Pod Security Standards
+type PodSecurityLevel string
+
+const (
+	PodSecurityLevelPrivileged PodSecurityLevel = "privileged"
+	PodSecurityLevelBaseline   PodSecurityLevel = "baseline"
+	PodSecurityLevelRestricted PodSecurityLevel = "restricted"
+)
+
+// capabilities that may be added under the baseline level
+var baselineCapabilities = map[corev1.Capability]bool{
+	"AUDIT_WRITE": true, "CHOWN": true, "DAC_OVERRIDE": true, "FOWNER": true,
+	"FSETID": true, "KILL": true, "MKNOD": true, "NET_BIND_SERVICE": true,
+	"SETFCAP": true, "SETGID": true, "SETPCAP": true, "SETUID": true, "SYS_CHROOT": true,
+}
+
+// PodSecurityContextForBookie returns the security context of the bookie pods
+func (bk *BookkeeperCluster) PodSecurityContextForBookie() *corev1.PodSecurityContext {
+	if bk.Spec.PodSecurityContext != nil {
+		return bk.Spec.PodSecurityContext.DeepCopy()
+	}
+	if bk.Spec.RunAsPrivilegedUser != nil && !*bk.Spec.RunAsPrivilegedUser {
+		id := int64(1000)
+		return &corev1.PodSecurityContext{
+			RunAsUser:  &id,
+			RunAsGroup: &id,
+			FSGroup:    &id,
+		}
+	}
+	return nil
+}
+
+// IsReadOnlyRootFilesystem returns true if the bookie containers run with a
+// read-only root filesystem
+func (bk *BookkeeperCluster) IsReadOnlyRootFilesystem() bool {
+	sc := bk.Spec.ContainerSecurityContext
+	return sc != nil && sc.ReadOnlyRootFilesystem != nil && *sc.ReadOnlyRootFilesystem
+}
+
+// PodSecurityWarnings lists the settings of the bookie pods that violate the
+// Pod Security level given in spec.podSecurityLevel
+func (bk *BookkeeperCluster) PodSecurityWarnings() []string {
+	level := bk.Spec.PodSecurityLevel
+	if level != PodSecurityLevelBaseline && level != PodSecurityLevelRestricted {
+		return nil
+	}
+	var warnings []string
+	warn := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf("pod security level %s: ", level)+fmt.Sprintf(format, args...))
+	}
+
+	// baseline
+	if bk.Spec.HostNetwork {
+		warn("pod uses the host network")
+	}
+	if _, ok := bk.Spec.Options["hostPathVolumeMounts"]; ok {
+		warn("option hostPathVolumeMounts uses hostPath volumes")
+	}
+	containers := []corev1.Container{{Name: "bookie", SecurityContext: bk.Spec.ContainerSecurityContext}}
+	containers = append(containers, bk.Spec.InitContainers...)
+	containers = append(containers, bk.Spec.Sidecars...)
+	for _, c := range containers {
+		name, sc := c.Name, c.SecurityContext
+		if sc == nil {
+			continue
+		}
+		if sc.Privileged != nil && *sc.Privileged {
+			warn("container %s is privileged", name)
+		}
+		if sc.Capabilities != nil {
+			for _, c := range sc.Capabilities.Add {
+				if !baselineCapabilities[c] && (level == PodSecurityLevelBaseline || c != "NET_BIND_SERVICE") {
+					warn("container %s adds capability %s", name, c)
+				}
+			}
+		}
+	}
+	if level == PodSecurityLevelBaseline {
+		return warnings
+	}
+
+	// restricted
+	psc := bk.PodSecurityContextForBookie()
+	podNonRoot := psc != nil && psc.RunAsNonRoot != nil && *psc.RunAsNonRoot
+	podSeccomp := psc != nil && isRestrictedSeccomp(psc.SeccompProfile)
+	if psc != nil && psc.RunAsUser != nil && *psc.RunAsUser == 0 {
+		warn("pod runs as user 0")
+	}
+	for _, c := range containers {
+		name, sc := c.Name, c.SecurityContext
+		if sc == nil {
+			sc = &corev1.SecurityContext{}
+		}
+		if !podNonRoot && (sc.RunAsNonRoot == nil || !*sc.RunAsNonRoot) {
+			warn("container %s must set runAsNonRoot=true", name)
+		}
+		if sc.RunAsUser != nil && *sc.RunAsUser == 0 {
+			warn("container %s runs as user 0", name)
+		}
+		if sc.AllowPrivilegeEscalation == nil || *sc.AllowPrivilegeEscalation {
+			warn("container %s must set allowPrivilegeEscalation=false", name)
+		}
+		if !dropsAllCapabilities(sc.Capabilities) {
+			warn("container %s must drop ALL capabilities", name)
+		}
+		if !podSeccomp && !isRestrictedSeccomp(sc.SeccompProfile) {
+			warn("container %s must use the RuntimeDefault or Localhost seccomp profile", name)
+		}
+	}
+	return warnings
+}
+
+func isRestrictedSeccomp(profile *corev1.SeccompProfile) bool {
+	return profile != nil && (profile.Type == corev1.SeccompProfileTypeRuntimeDefault || profile.Type == corev1.SeccompProfileTypeLocalhost)
+}
+
+func dropsAllCapabilities(capabilities *corev1.Capabilities) bool {
+	if capabilities == nil {
+		return false
+	}
+	for _, c := range capabilities.Drop {
+		if strings.EqualFold(string(c), "ALL") {
+			return true
+		}
+	}
+	return false
+}
@@ -20,10 +20,19 @@ import (
 type ClusterConditionType string
 
 const (
-	ClusterConditionPodsReady ClusterConditionType = "PodsReady"
-	ClusterConditionUpgrading                      = "Upgrading"
-	ClusterConditionRollback                       = "RollbackInProgress"
-	ClusterConditionError                          = "Error"
+	ClusterConditionPodsReady             ClusterConditionType = "PodsReady"
+	ClusterConditionUpgrading                                  = "Upgrading"
+	ClusterConditionRollback                                   = "RollbackInProgress"
+	ClusterConditionError                                      = "Error"
+	ClusterConditionStorageNodesAvailable                      = "StorageNodesAvailable"
+	ClusterConditionMetadataInitialized                        = "MetadataInitialized"
+	ClusterConditionZookeeperReachable                         = "ZookeeperReachable"
+	ClusterConditionZookeeperHealthy                           = "ZookeeperHealthy"
+	ClusterConditionPaused                                     = "Paused"
+
+	// Conditions of individual bookies
+	BookieConditionCookieMismatch ClusterConditionType = "CookieMismatch"
+	BookieConditionRepairFailed   ClusterConditionType = "RepairFailed"
 
 	// Reasons for cluster upgrading condition
 	UpdatingBookkeeperReason = "Updating Bookkeeper"
@@ -60,6 +69,99 @@ type BookkeeperClusterStatus struct {
 	// Members is the Bookkeeper members in the cluster
 	// +optional
 	Members MembersStatus `json:"members"`
+
+	// AvailableBookies is the number of bookies registered as available in
+	// read-write mode in ZooKeeper, when it was last reachable
+	// +optional
+	AvailableBookies int32 `json:"availableBookies"`
+
+	// ReadOnlyBookies is the number of bookies registered as available in
+	// read-only mode in ZooKeeper, when it was last reachable
+	// +optional
+	ReadOnlyBookies int32 `json:"readOnlyBookies"`
+
+	// InstanceID is the BookKeeper cluster instance ID found in, or written
+	// to, the cluster metadata by the metadata init job
+	// +optional
+	InstanceID string `json:"instanceID,omitempty"`
+
+	// RestoredSnapshot is the name of the snapshot the metadata of the
+	// cluster was restored from
+	// +optional
+	RestoredSnapshot string `json:"restoredSnapshot,omitempty"`
+
+	// RestartPending is set while the bookies still have to be restarted
+	// for a change of their pod template, e.g. when the restart was skipped
+	// in dry run mode or interrupted
+	// +optional
+	RestartPending bool `json:"restartPending,omitempty"`
+
+	// BookieConditions lists the conditions of individual bookies that
+	// need attention, such as a cookie mismatch
+	// +optional
+	BookieConditions []BookieCondition `json:"bookieConditions,omitempty"`
+
+	// BookieReplacement records the last bookie replacement requested with
+	// the replace-bookie annotation
+	// +optional
+	BookieReplacement *BookieReplacementStatus `json:"bookieReplacement,omitempty"`
+}
+
+type BookieReplacementPhase string
+
+const (
+	BookieReplacementStopping        BookieReplacementPhase = "Stopping"
+	BookieReplacementDecommissioning BookieReplacementPhase = "Decommissioning"
+	BookieReplacementReplacing       BookieReplacementPhase = "Replacing"
+	BookieReplacementCompleted       BookieReplacementPhase = "Completed"
+	BookieReplacementFailed          BookieReplacementPhase = "Failed"
+)
+
+// BookieReplacementStatus is the progress of a bookie replacement
+type BookieReplacementStatus struct {
+	// Bookie is the name of the replaced bookie pod
+	Bookie string `json:"bookie"`
+
+	// BookieID is the ID the replaced bookie was registered with
+	// +optional
+	BookieID string `json:"bookieID,omitempty"`
+
+	// Phase is one of Stopping, Decommissioning, Replacing, Completed or
+	// Failed
+	Phase BookieReplacementPhase `json:"phase"`
+
+	// Message gives details about the phase
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// PodUID is the UID of the last bookie pod deleted by the replacement
+	// +optional
+	PodUID string `json:"podUID,omitempty"`
+
+	// StartTime is when the replacement started
+	// +optional
+	StartTime string `json:"startTime,omitempty"`
+
+	// PhaseTime is when the current phase started
+	// +optional
+	PhaseTime string `json:"phaseTime,omitempty"`
+
+	// CompletionTime is when the replacement completed or failed
+	// +optional
+	CompletionTime string `json:"completionTime,omitempty"`
+}
+
+// IsInProgress returns true until the replacement has completed or failed
+func (r *BookieReplacementStatus) IsInProgress() bool {
+	return r != nil && r.Phase != BookieReplacementCompleted && r.Phase != BookieReplacementFailed
+}
+
+// BookieCondition shows the current condition of a single bookie
+type BookieCondition struct {
+	// Bookie is the name of the bookie pod
+	Bookie string `json:"bookie"`
+
+	ClusterCondition `json:",inline"`
 }
 
 // MembersStatus is the status of the members of the cluster with both
@@ -71,6 +173,10 @@ type MembersStatus struct {
 	// +optional
 	// +nullable
 	Unready []string `json:"unready"`
+	// Stranded lists the bookies whose node local data lives on a node
+	// that no longer exists
+	// +optional
+	Stranded []string `json:"stranded,omitempty"`
 }
 
 // ClusterCondition shows the current condition of a Bookkeeper cluster.
@@ -103,6 +209,7 @@ func (ps *BookkeeperClusterStatus) Init() {
 		ClusterConditionPodsReady,
 		ClusterConditionUpgrading,
 		ClusterConditionError,
+		ClusterConditionPaused,
 	}
 	for _, conditionType := range conditionTypes {
 		if _, condition := ps.GetClusterCondition(conditionType); condition == nil {
@@ -157,6 +264,103 @@ func (ps *BookkeeperClusterStatus) SetRollbackConditionFalse() {
 	ps.setClusterCondition(*c)
 }
 
+func (ps *BookkeeperClusterStatus) SetStorageNodesAvailableConditionTrue() {
+	c := newClusterCondition(ClusterConditionStorageNodesAvailable, corev1.ConditionTrue, "", "")
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetStorageNodesAvailableConditionFalse(reason, message string) {
+	c := newClusterCondition(ClusterConditionStorageNodesAvailable, corev1.ConditionFalse, reason, message)
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetMetadataInitializedConditionTrue() {
+	c := newClusterCondition(ClusterConditionMetadataInitialized, corev1.ConditionTrue, "", "")
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetMetadataInitializedConditionFalse(reason, message string) {
+	c := newClusterCondition(ClusterConditionMetadataInitialized, corev1.ConditionFalse, reason, message)
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetZookeeperReachableConditionTrue() {
+	c := newClusterCondition(ClusterConditionZookeeperReachable, corev1.ConditionTrue, "", "")
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetZookeeperReachableConditionFalse(reason, message string) {
+	c := newClusterCondition(ClusterConditionZookeeperReachable, corev1.ConditionFalse, reason, message)
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetZookeeperHealthyConditionTrue() {
+	c := newClusterCondition(ClusterConditionZookeeperHealthy, corev1.ConditionTrue, "", "")
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetZookeeperHealthyConditionFalse(reason, message string) {
+	c := newClusterCondition(ClusterConditionZookeeperHealthy, corev1.ConditionFalse, reason, message)
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetPausedConditionTrue(reason, message string) {
+	c := newClusterCondition(ClusterConditionPaused, corev1.ConditionTrue, reason, message)
+	ps.setClusterCondition(*c)
+}
+
+func (ps *BookkeeperClusterStatus) SetPausedConditionFalse() {
+	c := newClusterCondition(ClusterConditionPaused, corev1.ConditionFalse, "", "")
+	ps.setClusterCondition(*c)
+}
+
+// IsPaused returns true if the Paused condition is set
+func (ps *BookkeeperClusterStatus) IsPaused() bool {
+	_, c := ps.GetClusterCondition(ClusterConditionPaused)
+	return c != nil && c.Status == corev1.ConditionTrue
+}
+
+// SetBookieConditionTrue sets condition t of the given bookie to True
+func (ps *BookkeeperClusterStatus) SetBookieConditionTrue(bookie string, t ClusterConditionType, reason, message string) {
+	now := time.Now().Format(time.RFC3339)
+	for i := range ps.BookieConditions {
+		c := &ps.BookieConditions[i]
+		if c.Bookie == bookie && c.Type == t {
+			if c.Reason != reason || c.Message != message {
+				c.Reason = reason
+				c.Message = message
+				c.LastUpdateTime = now
+			}
+			return
+		}
+	}
+	ps.BookieConditions = append(ps.BookieConditions, BookieCondition{
+		Bookie:           bookie,
+		ClusterCondition: *newClusterCondition(t, corev1.ConditionTrue, reason, message),
+	})
+}
+
+// ClearBookieCondition removes condition t of the given bookie
+func (ps *BookkeeperClusterStatus) ClearBookieCondition(bookie string, t ClusterConditionType) {
+	conditions := ps.BookieConditions[:0]
+	for _, c := range ps.BookieConditions {
+		if c.Bookie != bookie || c.Type != t {
+			conditions = append(conditions, c)
+		}
+	}
+	ps.BookieConditions = conditions
+}
+
+// GetBookieCondition returns condition t of the given bookie, if it is set
+func (ps *BookkeeperClusterStatus) GetBookieCondition(bookie string, t ClusterConditionType) *BookieCondition {
+	for _, c := range ps.BookieConditions {
+		if c.Bookie == bookie && c.Type == t {
+			return &c
+		}
+	}
+	return nil
+}
+
 func newClusterCondition(condType ClusterConditionType, status corev1.ConditionStatus, reason, message string) *ClusterCondition {
 	return &ClusterCondition{
 		Type:               condType,
@@ -301,3 +505,17 @@ func (ps *BookkeeperClusterStatus) GetLastCondition() (lastCondition *ClusterCon
 	// nothing to do if we are neither upgrading nor rolling back,
 	return nil
 }
+
+// ResetProgressTime restarts the progress deadline of an ongoing upgrade or
+// rollback, so the time spent paused does not count towards the upgrade timeout
+func (ps *BookkeeperClusterStatus) ResetProgressTime() {
+	var conditionType ClusterConditionType = ClusterConditionUpgrading
+	if !ps.IsClusterInUpgradingState() {
+		if !ps.IsClusterInRollbackState() {
+			return
+		}
+		conditionType = ClusterConditionRollback
+	}
+	i, _ := ps.GetClusterCondition(conditionType)
+	ps.Conditions[i].LastUpdateTime = time.Now().Format(time.RFC3339)
+}
@@ -49,6 +49,13 @@ var _ = Describe("BookkeeperCluster Status", func() {
 			_, condition := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionError)
 			Ω(condition.Status).To(Equal(corev1.ConditionFalse))
 		})
+		It("should not be paused", func() {
+			_, condition := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionPaused)
+			Ω(condition.Status).To(Equal(corev1.ConditionFalse))
+			Ω(bk.Status.IsPaused()).To(BeFalse())
+			bk.Status.SetPausedConditionTrue("PausedBySpec", "")
+			Ω(bk.Status.IsPaused()).To(BeTrue())
+		})
 	})
 	Context("checking for version history", func() {
 		BeforeEach(func() {
@@ -176,6 +183,14 @@ var _ = Describe("BookkeeperCluster Status", func() {
 			It("Checking ClusterInRollbackFailedState should return false", func() {
 				Ω(bk.Status.IsClusterInRollbackFailedState()).To(Equal(false))
 			})
+			It("should reset the progress time of the upgrade condition", func() {
+				i, _ := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionUpgrading)
+				bk.Status.Conditions[i].LastUpdateTime = "2006-01-02T15:04:05Z"
+				bk.Status.ResetProgressTime()
+				condition := bk.Status.GetLastCondition()
+				Ω(condition.LastUpdateTime).NotTo(Equal("2006-01-02T15:04:05Z"))
+				Ω(condition.Reason).To(Equal("UpdatingBookkeeperReason"))
+			})
 		})
 		Context("set pod upgrade condition to be false", func() {
 			BeforeEach(func() {
@@ -308,4 +323,21 @@ var _ = Describe("BookkeeperCluster Status", func() {
 			})
 		})
 	})
+	Context("bookie conditions", func() {
+		BeforeEach(func() {
+			bk.Status.SetBookieConditionTrue("default-bookie-0", v1alpha1.BookieConditionCookieMismatch, "InvalidCookie", "cookie is not matching")
+			bk.Status.SetBookieConditionTrue("default-bookie-1", v1alpha1.BookieConditionCookieMismatch, "InvalidCookie", "cookie is not matching")
+		})
+		It("should set the condition of each bookie", func() {
+			condition := bk.Status.GetBookieCondition("default-bookie-0", v1alpha1.BookieConditionCookieMismatch)
+			Ω(condition.Status).To(Equal(corev1.ConditionTrue))
+			Ω(condition.Reason).To(Equal("InvalidCookie"))
+			Ω(bk.Status.BookieConditions).To(HaveLen(2))
+		})
+		It("should clear the condition of a single bookie", func() {
+			bk.Status.ClearBookieCondition("default-bookie-0", v1alpha1.BookieConditionCookieMismatch)
+			Ω(bk.Status.GetBookieCondition("default-bookie-0", v1alpha1.BookieConditionCookieMismatch)).To(BeNil())
+			Ω(bk.Status.GetBookieCondition("default-bookie-1", v1alpha1.BookieConditionCookieMismatch)).NotTo(BeNil())
+		})
+	})
 })
@@ -0,0 +1,108 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+)
+
+// ZookeeperAuthScheme is the way the operator and the bookies authenticate
+// to ZooKeeper
+type ZookeeperAuthScheme string
+
+const (
+	// ZookeeperAuthDigest authenticates the operator with the username and
+	// password of the Secret using the digest scheme, and the bookies with
+	// the same credentials through SASL with the DigestLoginModule
+	ZookeeperAuthDigest ZookeeperAuthScheme = "digest"
+
+	// ZookeeperAuthJAAS authenticates the bookies through SASL with the JAAS
+	// configuration held by the jaas.conf key of the Secret. The operator,
+	// whose ZooKeeper client has no SASL support, authenticates with the
+	// username and password of the Secret using the digest scheme, if given.
+	ZookeeperAuthJAAS ZookeeperAuthScheme = "jaas"
+)
+
+// ZookeeperClientSpec configures the connections of the operator and the
+// bookies to ZooKeeper
+type ZookeeperClientSpec struct {
+	// Auth configures the authentication to ZooKeeper
+	// +optional
+	Auth *ZookeeperAuthSpec `json:"auth,omitempty"`
+
+	// TLS configures the encryption of the connections to ZooKeeper
+	// +optional
+	TLS *ZookeeperTLSSpec `json:"tls,omitempty"`
+}
+
+// ZookeeperAuthSpec configures the authentication to ZooKeeper
+type ZookeeperAuthSpec struct {
+	// Scheme is either "digest" or "jaas". Defaults to "digest".
+	// +kubebuilder:validation:Enum=digest;jaas
+	// +optional
+	Scheme ZookeeperAuthScheme `json:"scheme,omitempty"`
+
+	// SecretName is the name of the Secret holding the credentials: the
+	// username and password keys, and with the jaas scheme the jaas.conf key
+	SecretName string `json:"secretName"`
+}
+
+// ZookeeperTLSSpec configures the encryption of the connections to ZooKeeper
+type ZookeeperTLSSpec struct {
+	// SecretName is the name of the Secret holding, in its ca.crt key, the
+	// PEM encoded certificate authority of the ZooKeeper servers
+	SecretName string `json:"secretName"`
+}
+
+// GetScheme returns the authentication scheme, digest if it is not set
+func (s *ZookeeperAuthSpec) GetScheme() ZookeeperAuthScheme {
+	if s.Scheme == "" {
+		return ZookeeperAuthDigest
+	}
+	return s.Scheme
+}
+
+// ZookeeperAuth returns the authentication configuration, or nil if the
+// connections to ZooKeeper are anonymous
+func (bk *BookkeeperCluster) ZookeeperAuth() *ZookeeperAuthSpec {
+	if bk.Spec.Zookeeper == nil {
+		return nil
+	}
+	return bk.Spec.Zookeeper.Auth
+}
+
+// ZookeeperTLS returns the encryption configuration, or nil if the
+// connections to ZooKeeper are in plaintext
+func (bk *BookkeeperCluster) ZookeeperTLS() *ZookeeperTLSSpec {
+	if bk.Spec.Zookeeper == nil {
+		return nil
+	}
+	return bk.Spec.Zookeeper.TLS
+}
+
+// ValidateZookeeper checks the ZooKeeper connect string and the Secrets of
+// the ZooKeeper client configuration
+func (bk *BookkeeperCluster) ValidateZookeeper() error {
+	if bk.Spec.ZookeeperUri != "" {
+		if _, _, err := util.ParseZookeeperConnectString(bk.Spec.ZookeeperUri); err != nil {
+			return err
+		}
+	}
+	if auth := bk.ZookeeperAuth(); auth != nil && auth.SecretName == "" {
+		return fmt.Errorf("spec.zookeeper.auth.secretName should be set")
+	}
+	if tls := bk.ZookeeperTLS(); tls != nil && tls.SecretName == "" {
+		return fmt.Errorf("spec.zookeeper.tls.secretName should be set")
+	}
+	return nil
+}
@@ -10,6 +10,164 @@ import (
 	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BackupStorageSpec) DeepCopyInto(out *BackupStorageSpec) {
+	*out = *in
+	if in.PersistentVolumeClaim != nil {
+		in, out := &in.PersistentVolumeClaim, &out.PersistentVolumeClaim
+		*out = new(PVCBackupStorage)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3BackupStorage)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BackupStorageSpec.
+func (in *BackupStorageSpec) DeepCopy() *BackupStorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BackupStorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BookieCondition) DeepCopyInto(out *BookieCondition) {
+	*out = *in
+	out.ClusterCondition = in.ClusterCondition
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookieCondition.
+func (in *BookieCondition) DeepCopy() *BookieCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(BookieCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BookieReplacementStatus) DeepCopyInto(out *BookieReplacementStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookieReplacementStatus.
+func (in *BookieReplacementStatus) DeepCopy() *BookieReplacementStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BookieReplacementStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BookkeeperBackup) DeepCopyInto(out *BookkeeperBackup) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperBackup.
+func (in *BookkeeperBackup) DeepCopy() *BookkeeperBackup {
+	if in == nil {
+		return nil
+	}
+	out := new(BookkeeperBackup)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BookkeeperBackup) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BookkeeperBackupList) DeepCopyInto(out *BookkeeperBackupList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]BookkeeperBackup, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperBackupList.
+func (in *BookkeeperBackupList) DeepCopy() *BookkeeperBackupList {
+	if in == nil {
+		return nil
+	}
+	out := new(BookkeeperBackupList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *BookkeeperBackupList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BookkeeperBackupSpec) DeepCopyInto(out *BookkeeperBackupSpec) {
+	*out = *in
+	in.Storage.DeepCopyInto(&out.Storage)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperBackupSpec.
+func (in *BookkeeperBackupSpec) DeepCopy() *BookkeeperBackupSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(BookkeeperBackupSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BookkeeperBackupStatus) DeepCopyInto(out *BookkeeperBackupStatus) {
+	*out = *in
+	if in.LastScheduleTime != nil {
+		in, out := &in.LastScheduleTime, &out.LastScheduleTime
+		*out = (*in).DeepCopy()
+	}
+	if in.LastSuccessfulTime != nil {
+		in, out := &in.LastSuccessfulTime, &out.LastSuccessfulTime
+		*out = (*in).DeepCopy()
+	}
+	if in.CompletionTime != nil {
+		in, out := &in.CompletionTime, &out.CompletionTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperBackupStatus.
+func (in *BookkeeperBackupStatus) DeepCopy() *BookkeeperBackupStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(BookkeeperBackupStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BookkeeperCluster) DeepCopyInto(out *BookkeeperCluster) {
 	*out = *in
@@ -72,6 +230,16 @@ func (in *BookkeeperClusterList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *BookkeeperClusterSpec) DeepCopyInto(out *BookkeeperClusterSpec) {
 	*out = *in
+	if in.Zookeeper != nil {
+		in, out := &in.Zookeeper, &out.Zookeeper
+		*out = new(ZookeeperClientSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Restore != nil {
+		in, out := &in.Restore, &out.Restore
+		*out = new(RestoreSpec)
+		**out = **in
+	}
 	if in.Image != nil {
 		in, out := &in.Image, &out.Image
 		*out = new(BookkeeperImageSpec)
@@ -104,6 +272,13 @@ func (in *BookkeeperClusterSpec) DeepCopyInto(out *BookkeeperClusterSpec) {
 			(*out)[key] = val
 		}
 	}
+	if in.SecretOptions != nil {
+		in, out := &in.SecretOptions, &out.SecretOptions
+		*out = make(map[string]v1.SecretKeySelector, len(*in))
+		for key, val := range *in {
+			(*out)[key] = *val.DeepCopy()
+		}
+	}
 	if in.JVMOptions != nil {
 		in, out := &in.JVMOptions, &out.JVMOptions
 		*out = new(JVMOptions)
@@ -145,6 +320,16 @@ func (in *BookkeeperClusterSpec) DeepCopyInto(out *BookkeeperClusterSpec) {
 		*out = new(bool)
 		**out = **in
 	}
+	if in.PodSecurityContext != nil {
+		in, out := &in.PodSecurityContext, &out.PodSecurityContext
+		*out = new(v1.PodSecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ContainerSecurityContext != nil {
+		in, out := &in.ContainerSecurityContext, &out.ContainerSecurityContext
+		*out = new(v1.SecurityContext)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.Tolerations != nil {
 		in, out := &in.Tolerations, &out.Tolerations
 		*out = make([]v1.Toleration, len(*in))
@@ -152,6 +337,69 @@ func (in *BookkeeperClusterSpec) DeepCopyInto(out *BookkeeperClusterSpec) {
 			(*in)[i].DeepCopyInto(&(*out)[i])
 		}
 	}
+	if in.NodeSelector != nil {
+		in, out := &in.NodeSelector, &out.NodeSelector
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.TopologySpreadConstraints != nil {
+		in, out := &in.TopologySpreadConstraints, &out.TopologySpreadConstraints
+		*out = make([]v1.TopologySpreadConstraint, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.TerminationGracePeriodSeconds != nil {
+		in, out := &in.TerminationGracePeriodSeconds, &out.TerminationGracePeriodSeconds
+		*out = new(int64)
+		**out = **in
+	}
+	if in.GracefulShutdown != nil {
+		in, out := &in.GracefulShutdown, &out.GracefulShutdown
+		*out = new(GracefulShutdownSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.DNSConfig != nil {
+		in, out := &in.DNSConfig, &out.DNSConfig
+		*out = new(v1.PodDNSConfig)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Sidecars != nil {
+		in, out := &in.Sidecars, &out.Sidecars
+		*out = make([]v1.Container, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RackAware != nil {
+		in, out := &in.RackAware, &out.RackAware
+		*out = new(RackAwareSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.Env != nil {
+		in, out := &in.Env, &out.Env
+		*out = make([]v1.EnvVar, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.ExternalAccess != nil {
+		in, out := &in.ExternalAccess, &out.ExternalAccess
+		*out = new(ExternalAccess)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.HTTPServer != nil {
+		in, out := &in.HTTPServer, &out.HTTPServer
+		*out = new(HTTPServerSpec)
+		**out = **in
+	}
+	if in.Metrics != nil {
+		in, out := &in.Metrics, &out.Metrics
+		*out = new(MetricsSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperClusterSpec.
@@ -178,6 +426,16 @@ func (in *BookkeeperClusterStatus) DeepCopyInto(out *BookkeeperClusterStatus) {
 		copy(*out, *in)
 	}
 	in.Members.DeepCopyInto(&out.Members)
+	if in.BookieConditions != nil {
+		in, out := &in.BookieConditions, &out.BookieConditions
+		*out = make([]BookieCondition, len(*in))
+		copy(*out, *in)
+	}
+	if in.BookieReplacement != nil {
+		in, out := &in.BookieReplacement, &out.BookieReplacement
+		*out = new(BookieReplacementStatus)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperClusterStatus.
@@ -224,6 +482,11 @@ func (in *BookkeeperStorageSpec) DeepCopyInto(out *BookkeeperStorageSpec) {
 		*out = new(v1.PersistentVolumeClaimSpec)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.Local != nil {
+		in, out := &in.Local, &out.Local
+		*out = new(LocalStorageSpec)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BookkeeperStorageSpec.
@@ -251,6 +514,63 @@ func (in *ClusterCondition) DeepCopy() *ClusterCondition {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ExternalAccess) DeepCopyInto(out *ExternalAccess) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ExternalAccess.
+func (in *ExternalAccess) DeepCopy() *ExternalAccess {
+	if in == nil {
+		return nil
+	}
+	out := new(ExternalAccess)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *GracefulShutdownSpec) DeepCopyInto(out *GracefulShutdownSpec) {
+	*out = *in
+	if in.Enabled != nil {
+		in, out := &in.Enabled, &out.Enabled
+		*out = new(bool)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new GracefulShutdownSpec.
+func (in *GracefulShutdownSpec) DeepCopy() *GracefulShutdownSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(GracefulShutdownSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPServerSpec) DeepCopyInto(out *HTTPServerSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPServerSpec.
+func (in *HTTPServerSpec) DeepCopy() *HTTPServerSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPServerSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *ImageSpec) DeepCopyInto(out *ImageSpec) {
 	*out = *in
@@ -301,6 +621,26 @@ func (in *JVMOptions) DeepCopy() *JVMOptions {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocalStorageSpec) DeepCopyInto(out *LocalStorageSpec) {
+	*out = *in
+	if in.Nodes != nil {
+		in, out := &in.Nodes, &out.Nodes
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalStorageSpec.
+func (in *LocalStorageSpec) DeepCopy() *LocalStorageSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(LocalStorageSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *MembersStatus) DeepCopyInto(out *MembersStatus) {
 	*out = *in
@@ -314,6 +654,11 @@ func (in *MembersStatus) DeepCopyInto(out *MembersStatus) {
 		*out = make([]string, len(*in))
 		copy(*out, *in)
 	}
+	if in.Stranded != nil {
+		in, out := &in.Stranded, &out.Stranded
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MembersStatus.
@@ -326,6 +671,46 @@ func (in *MembersStatus) DeepCopy() *MembersStatus {
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *MetricsSpec) DeepCopyInto(out *MetricsSpec) {
+	*out = *in
+	if in.ServiceMonitor != nil {
+		in, out := &in.ServiceMonitor, &out.ServiceMonitor
+		*out = new(ServiceMonitorSpec)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.PrometheusRule != nil {
+		in, out := &in.PrometheusRule, &out.PrometheusRule
+		*out = new(PrometheusRuleSpec)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new MetricsSpec.
+func (in *MetricsSpec) DeepCopy() *MetricsSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(MetricsSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PVCBackupStorage) DeepCopyInto(out *PVCBackupStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PVCBackupStorage.
+func (in *PVCBackupStorage) DeepCopy() *PVCBackupStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(PVCBackupStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *Probe) DeepCopyInto(out *Probe) {
 	*out = *in
@@ -354,6 +739,11 @@ func (in *Probes) DeepCopyInto(out *Probes) {
 		*out = new(Probe)
 		**out = **in
 	}
+	if in.StartupProbe != nil {
+		in, out := &in.StartupProbe, &out.StartupProbe
+		*out = new(Probe)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Probes.
@@ -365,3 +755,152 @@ func (in *Probes) DeepCopy() *Probes {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PrometheusRuleSpec) DeepCopyInto(out *PrometheusRuleSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PrometheusRuleSpec.
+func (in *PrometheusRuleSpec) DeepCopy() *PrometheusRuleSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(PrometheusRuleSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RackAwareSpec) DeepCopyInto(out *RackAwareSpec) {
+	*out = *in
+	if in.Zones != nil {
+		in, out := &in.Zones, &out.Zones
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RackAwareSpec.
+func (in *RackAwareSpec) DeepCopy() *RackAwareSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RackAwareSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *RestoreSpec) DeepCopyInto(out *RestoreSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new RestoreSpec.
+func (in *RestoreSpec) DeepCopy() *RestoreSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(RestoreSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3BackupStorage) DeepCopyInto(out *S3BackupStorage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3BackupStorage.
+func (in *S3BackupStorage) DeepCopy() *S3BackupStorage {
+	if in == nil {
+		return nil
+	}
+	out := new(S3BackupStorage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ServiceMonitorSpec) DeepCopyInto(out *ServiceMonitorSpec) {
+	*out = *in
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ServiceMonitorSpec.
+func (in *ServiceMonitorSpec) DeepCopy() *ServiceMonitorSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ServiceMonitorSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZookeeperAuthSpec) DeepCopyInto(out *ZookeeperAuthSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZookeeperAuthSpec.
+func (in *ZookeeperAuthSpec) DeepCopy() *ZookeeperAuthSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZookeeperAuthSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZookeeperClientSpec) DeepCopyInto(out *ZookeeperClientSpec) {
+	*out = *in
+	if in.Auth != nil {
+		in, out := &in.Auth, &out.Auth
+		*out = new(ZookeeperAuthSpec)
+		**out = **in
+	}
+	if in.TLS != nil {
+		in, out := &in.TLS, &out.TLS
+		*out = new(ZookeeperTLSSpec)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZookeeperClientSpec.
+func (in *ZookeeperClientSpec) DeepCopy() *ZookeeperClientSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZookeeperClientSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ZookeeperTLSSpec) DeepCopyInto(out *ZookeeperTLSSpec) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ZookeeperTLSSpec.
+func (in *ZookeeperTLSSpec) DeepCopy() *ZookeeperTLSSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ZookeeperTLSSpec)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,168 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/structured-merge-diff/v4/fieldpath"
+)
+
+// FieldManager is the field manager of the objects applied by the operator
+const FieldManager = "bookkeeper-operator"
+
+// legacyFieldManagers are the field managers of the update requests of the
+// operator, named after its binary
+var legacyFieldManagers = map[string]bool{
+	FieldManager: true,
+	"manager":    true,
+}
+
+// apply creates or updates obj with a server-side apply patch. The operator
+// only owns the fields set in obj, so fields set by other controllers or by
+// admission plugins are left alone. On success obj holds the applied object.
+func (r *BookkeeperClusterReconciler) apply(ctx context.Context, obj client.Object) error {
+	return applyObject(ctx, r.Client, r.Scheme, obj)
+}
+
+// applyObject is apply for the reconcilers of the other kinds
+func applyObject(ctx context.Context, c client.Client, scheme *runtime.Scheme, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, scheme)
+	if err != nil {
+		return err
+	}
+	u := &unstructured.Unstructured{}
+	if uobj, ok := obj.(*unstructured.Unstructured); ok {
+		u.Object = runtime.DeepCopyJSON(uobj.Object)
+	} else {
+		u.Object, err = runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return err
+		}
+	}
+	u.SetGroupVersionKind(gvk)
+	u.SetResourceVersion("")
+	u.SetManagedFields(nil)
+	// zero values of the typed objects would claim these fields
+	unstructured.RemoveNestedField(u.Object, "metadata", "creationTimestamp")
+	unstructured.RemoveNestedField(u.Object, "status")
+
+	applied := u.DeepCopy()
+	err = c.Patch(ctx, u, client.Apply, client.ForceOwnership, client.FieldOwner(FieldManager))
+	if err != nil {
+		return err
+	}
+	migrated, err := migrateManagedFields(ctx, c, u)
+	if err != nil {
+		return err
+	}
+	if migrated {
+		// apply again, so that the fields the operator no longer sets are
+		// removed now that it owns them
+		u = applied
+		err = c.Patch(ctx, u, client.Apply, client.ForceOwnership, client.FieldOwner(FieldManager))
+		if err != nil {
+			return err
+		}
+	}
+	if uobj, ok := obj.(*unstructured.Unstructured); ok {
+		uobj.Object = u.Object
+		return nil
+	}
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, obj)
+}
+
+// migrateManagedFields hands the fields the operator set with update
+// requests, e.g. before it used server-side apply, over to its apply field
+// manager. Apply only removes the fields its field manager owns, so without
+// this a disabled port or a removed annotation would be kept forever.
+func migrateManagedFields(ctx context.Context, c client.Client, u *unstructured.Unstructured) (bool, error) {
+	entries, migrated, err := upgradeManagedFields(u.GetManagedFields())
+	if err != nil || !migrated {
+		return false, err
+	}
+	patch, err := json.Marshal([]map[string]interface{}{
+		{"op": "test", "path": "/metadata/resourceVersion", "value": u.GetResourceVersion()},
+		{"op": "replace", "path": "/metadata/managedFields", "value": entries},
+	})
+	if err != nil {
+		return false, err
+	}
+	err = c.Patch(ctx, u, client.RawPatch(types.JSONPatchType, patch))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// upgradeManagedFields merges the fields of the update entries of the legacy
+// field managers into the apply entry of FieldManager
+func upgradeManagedFields(entries []metav1.ManagedFieldsEntry) ([]metav1.ManagedFieldsEntry, bool, error) {
+	owned := &fieldpath.Set{}
+	apiVersion := ""
+	var result []metav1.ManagedFieldsEntry
+	for _, entry := range entries {
+		if entry.Operation != metav1.ManagedFieldsOperationUpdate || entry.Subresource != "" ||
+			!legacyFieldManagers[entry.Manager] || entry.FieldsV1 == nil {
+			result = append(result, entry)
+			continue
+		}
+		set := &fieldpath.Set{}
+		err := set.FromJSON(bytes.NewReader(entry.FieldsV1.Raw))
+		if err != nil {
+			return nil, false, err
+		}
+		owned = owned.Union(set)
+		apiVersion = entry.APIVersion
+	}
+	if apiVersion == "" {
+		return entries, false, nil
+	}
+
+	index := -1
+	for i, entry := range result {
+		if entry.Operation == metav1.ManagedFieldsOperationApply && entry.Subresource == "" && entry.Manager == FieldManager {
+			index = i
+		}
+	}
+	if index == -1 {
+		result = append(result, metav1.ManagedFieldsEntry{
+			Manager:    FieldManager,
+			Operation:  metav1.ManagedFieldsOperationApply,
+			APIVersion: apiVersion,
+			FieldsType: "FieldsV1",
+		})
+		index = len(result) - 1
+	} else if result[index].FieldsV1 != nil {
+		set := &fieldpath.Set{}
+		err := set.FromJSON(bytes.NewReader(result[index].FieldsV1.Raw))
+		if err != nil {
+			return nil, false, err
+		}
+		owned = owned.Union(set)
+	}
+	raw, err := owned.ToJSON()
+	if err != nil {
+		return nil, false, err
+	}
+	now := metav1.Now()
+	result[index].Time = &now
+	result[index].FieldsV1 = &metav1.FieldsV1{Raw: raw}
+	return result, true, nil
+}
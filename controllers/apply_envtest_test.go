@@ -0,0 +1,76 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+// these tests need a real API server for server-side apply, and are skipped
+// unless KUBEBUILDER_ASSETS points to the envtest binaries
+var _ = Describe("Server-side apply with an API server", func() {
+	var (
+		testEnv   *envtest.Environment
+		k8sClient client.Client
+	)
+
+	BeforeEach(func() {
+		if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+			Skip("KUBEBUILDER_ASSETS is not set")
+		}
+		testEnv = &envtest.Environment{}
+		cfg, err := testEnv.Start()
+		Ω(err).Should(BeNil())
+		k8sClient, err = client.New(cfg, client.Options{Scheme: scheme.Scheme})
+		Ω(err).Should(BeNil())
+	})
+
+	AfterEach(func() {
+		if testEnv != nil {
+			Ω(testEnv.Stop()).Should(Succeed())
+		}
+	})
+
+	It("should remove the fields set with update requests once they are no longer applied", func() {
+		cm := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        "example-configmap",
+				Namespace:   "default",
+				Annotations: map[string]string{"removed": "value"},
+			},
+			Data: map[string]string{"kept": "value", "removed": "value"},
+		}
+		Ω(k8sClient.Create(context.TODO(), cm, client.FieldOwner(FieldManager))).Should(Succeed())
+
+		applied := &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: cm.Name, Namespace: cm.Namespace},
+			Data:       map[string]string{"kept": "value"},
+		}
+		Ω(applyObject(context.TODO(), k8sClient, scheme.Scheme, applied)).Should(Succeed())
+
+		found := &corev1.ConfigMap{}
+		Ω(k8sClient.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}, found)).Should(Succeed())
+		Ω(found.Data).Should(Equal(map[string]string{"kept": "value"}))
+		Ω(found.Annotations).ShouldNot(HaveKey("removed"))
+		for _, entry := range found.ManagedFields {
+			Ω(entry.Operation).Should(Equal(metav1.ManagedFieldsOperationApply))
+		}
+	})
+})
@@ -0,0 +1,166 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// applyClient emulates server-side apply on top of the fake client, which
+// does not support apply patches: missing objects are created, and the
+// applied fields are merged into existing ones
+type applyClient struct {
+	client.Client
+}
+
+func (c applyClient) Patch(ctx context.Context, obj client.Object, patch client.Patch, opts ...client.PatchOption) error {
+	if patch.Type() != types.ApplyPatchType {
+		return c.Client.Patch(ctx, obj, patch, opts...)
+	}
+	data, err := patch.Data(obj)
+	if err != nil {
+		return err
+	}
+	current := &unstructured.Unstructured{}
+	current.SetGroupVersionKind(obj.GetObjectKind().GroupVersionKind())
+	err = c.Client.Get(ctx, client.ObjectKeyFromObject(obj), current)
+	if errors.IsNotFound(err) {
+		return c.Client.Create(ctx, obj)
+	}
+	if err != nil {
+		return err
+	}
+	return c.Client.Patch(ctx, obj, client.RawPatch(types.MergePatchType, data))
+}
+
+var _ = Describe("Server-side apply", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+	)
+
+	var (
+		s      = scheme.Scheme
+		r      *BookkeeperClusterReconciler
+		b      *v1alpha1.BookkeeperCluster
+		client client.Client
+		err    error
+	)
+
+	BeforeEach(func() {
+		b = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      Name,
+				Namespace: Namespace,
+			},
+		}
+		b.WithDefaults()
+		b.Status.CurrentVersion = b.Spec.Version
+		s.AddKnownTypes(v1alpha1.GroupVersion, b, &v1alpha1.BookkeeperClusterList{})
+		client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b).Build()
+		r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+	})
+
+	Context("apply", func() {
+		It("should create missing objects and return the applied object", func() {
+			cm := MakeBookieConfigMap(b)
+			err = r.apply(context.TODO(), cm)
+			Ω(err).Should(BeNil())
+			Ω(cm.ResourceVersion).ShouldNot(BeEmpty())
+			Ω(cm.Data).Should(HaveKey("BK_useHostNameAsBookieID"))
+		})
+		It("should keep the fields set by others", func() {
+			cm := MakeBookieConfigMap(b)
+			Ω(r.apply(context.TODO(), cm)).Should(Succeed())
+			cm.Annotations = map[string]string{"other": "value"}
+			Ω(client.Update(context.TODO(), cm)).Should(Succeed())
+
+			err = r.apply(context.TODO(), MakeBookieConfigMap(b))
+			Ω(err).Should(BeNil())
+			found := &corev1.ConfigMap{}
+			client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: Namespace}, found)
+			Ω(found.Annotations).Should(HaveKeyWithValue("other", "value"))
+		})
+		It("should take over the fields set with update requests", func() {
+			cm := MakeBookieConfigMap(b)
+			cm.ManagedFields = []metav1.ManagedFieldsEntry{
+				{
+					Manager:    "manager",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					APIVersion: "v1",
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:removed":{}}}`)},
+				},
+				{
+					Manager:    "kubectl",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					APIVersion: "v1",
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:labels":{"f:other":{}}}}`)},
+				},
+			}
+			Ω(client.Create(context.TODO(), cm)).Should(Succeed())
+
+			err = r.apply(context.TODO(), MakeBookieConfigMap(b))
+			Ω(err).Should(BeNil())
+			found := &corev1.ConfigMap{}
+			client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: Namespace}, found)
+			Ω(found.ManagedFields).Should(HaveLen(2))
+			Ω(found.ManagedFields[0].Manager).Should(Equal("kubectl"))
+			Ω(found.ManagedFields[1].Manager).Should(Equal(FieldManager))
+			Ω(found.ManagedFields[1].Operation).Should(Equal(metav1.ManagedFieldsOperationApply))
+			Ω(string(found.ManagedFields[1].FieldsV1.Raw)).Should(ContainSubstring(`"f:removed"`))
+		})
+	})
+
+	Context("deployBookie", func() {
+		var pod *corev1.Pod
+
+		BeforeEach(func() {
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			pod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "example-bookie-0",
+					Namespace: Namespace,
+					Labels:    b.LabelsForBookie(),
+				},
+			}
+			Ω(client.Create(context.TODO(), pod)).Should(Succeed())
+		})
+		It("should not restart the bookies when the template is unchanged", func() {
+			err = r.deployBookie(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: Namespace}, &corev1.Pod{})
+			Ω(err).Should(BeNil())
+		})
+		It("should leave the size of the bookies to syncBookieSize", func() {
+			b.Spec.Replicas = 5
+			err = r.deployBookie(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			sts := &appsv1.StatefulSet{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie", Namespace: Namespace}, sts)
+			Ω(*sts.Spec.Replicas).Should(Equal(int32(3)))
+		})
+	})
+})
@@ -0,0 +1,216 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/backup"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SnapshotsMountPath is where the backup PVC is mounted in the backup and
+// restore Jobs
+const SnapshotsMountPath = "/snapshots"
+
+// validateBackupStorage checks that exactly one storage is given
+func validateBackupStorage(storage *v1alpha1.BackupStorageSpec) error {
+	pvc, s3 := storage.PersistentVolumeClaim, storage.S3
+	if (pvc == nil) == (s3 == nil) {
+		return fmt.Errorf("exactly one of storage.persistentVolumeClaim and storage.s3 should be set")
+	}
+	if pvc != nil && pvc.ClaimName == "" {
+		return fmt.Errorf("storage.persistentVolumeClaim.claimName should be set")
+	}
+	if s3 != nil && s3.Bucket == "" {
+		return fmt.Errorf("storage.s3.bucket should be set")
+	}
+	return nil
+}
+
+// MakeBackupJob returns the Job writing the given snapshot of the metadata of
+// the cluster to the storage of the backup
+func MakeBackupJob(b *v1alpha1.BookkeeperBackup, bk *v1alpha1.BookkeeperCluster, image string, ledgersRoot string, snapshot string) *batchv1.Job {
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.BackupJobName(b.Name),
+			Namespace: b.Namespace,
+			Labels:    labelsForBookieJob(bk, "bookie-backup"),
+		},
+		Spec: makeMetadataJobSpec(bk, &b.Spec.Storage, image, backup.CommandBackup, ledgersRoot, snapshot),
+	}
+}
+
+// MakeBackupCronJob returns the CronJob writing snapshots of the metadata of
+// the cluster to the storage of the backup on its schedule. The snapshots are
+// named after the cluster and the time they were taken.
+func MakeBackupCronJob(b *v1alpha1.BookkeeperBackup, bk *v1alpha1.BookkeeperCluster, image string, ledgersRoot string) *batchv1.CronJob {
+	history := int32(3)
+	return &batchv1.CronJob{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "CronJob",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.BackupJobName(b.Name),
+			Namespace: b.Namespace,
+			Labels:    labelsForBookieJob(bk, "bookie-backup"),
+		},
+		Spec: batchv1.CronJobSpec{
+			Schedule:                   b.Spec.Schedule,
+			ConcurrencyPolicy:          batchv1.ForbidConcurrent,
+			SuccessfulJobsHistoryLimit: &history,
+			FailedJobsHistoryLimit:     &history,
+			JobTemplate: batchv1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labelsForBookieJob(bk, "bookie-backup"),
+				},
+				Spec: makeMetadataJobSpec(bk, &b.Spec.Storage, image, backup.CommandBackup, ledgersRoot, ""),
+			},
+		},
+	}
+}
+
+// MakeBookieRestoreJob returns the Job writing the metadata of the snapshot
+// given in spec.restore from the storage of the backup to ZooKeeper, without
+// the cookies of the bookies if skipCookies is set
+func MakeBookieRestoreJob(bk *v1alpha1.BookkeeperCluster, b *v1alpha1.BookkeeperBackup, image string, ledgersRoot string, skipCookies bool) *batchv1.Job {
+	spec := makeMetadataJobSpec(bk, &b.Spec.Storage, image, backup.CommandRestore, ledgersRoot, bk.Spec.Restore.Snapshot)
+	if skipCookies {
+		container := &spec.Template.Spec.Containers[0]
+		container.Args = append(container.Args, "--skip-cookies")
+	}
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.RestoreJobNameForBookie(bk.Name),
+			Namespace: bk.Namespace,
+			Labels:    labelsForBookieJob(bk, "bookie-restore"),
+		},
+		Spec: spec,
+	}
+}
+
+// makeMetadataJobSpec returns the spec of a Job running the given backup or
+// restore command of the operator image, with the ZooKeeper client
+// configuration of the cluster
+func makeMetadataJobSpec(bk *v1alpha1.BookkeeperCluster, storage *v1alpha1.BackupStorageSpec, image string, command string, ledgersRoot string, snapshot string) batchv1.JobSpec {
+	backoffLimit := int32(2)
+	args := []string{
+		command,
+		"--cluster", bk.Name,
+		"--zookeeper-uri", bk.Spec.ZookeeperUri,
+		"--ledgers-root", ledgersRoot,
+	}
+	if snapshot != "" {
+		args = append(args, "--snapshot", snapshot)
+	}
+
+	container := corev1.Container{
+		Name:            command,
+		Image:           image,
+		ImagePullPolicy: corev1.PullIfNotPresent,
+		Command:         []string{"bookkeeper-operator"},
+	}
+	if auth := bk.ZookeeperAuth(); auth != nil {
+		optional := true
+		for _, env := range []struct{ name, key string }{{"ZK_USERNAME", "username"}, {"ZK_PASSWORD", "password"}} {
+			container.Env = append(container.Env, corev1.EnvVar{
+				Name: env.name,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: auth.SecretName},
+						Key:                  env.key,
+						Optional:             &optional,
+					},
+				},
+			})
+		}
+	}
+
+	podSpec := corev1.PodSpec{
+		RestartPolicy:     corev1.RestartPolicyNever,
+		NodeSelector:      bk.Spec.NodeSelector,
+		Tolerations:       bk.Spec.Tolerations,
+		PriorityClassName: bk.Spec.PriorityClassName,
+		DNSConfig:         bk.Spec.DNSConfig,
+	}
+	if pvc := storage.PersistentVolumeClaim; pvc != nil {
+		args = append(args, "--location", "file://"+filepath.Join(SnapshotsMountPath, pvc.Path))
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: "snapshots",
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.ClaimName},
+			},
+		})
+		container.VolumeMounts = append(container.VolumeMounts, corev1.VolumeMount{
+			Name:      "snapshots",
+			MountPath: SnapshotsMountPath,
+		})
+	}
+	if s3 := storage.S3; s3 != nil {
+		args = append(args, "--location", "s3://"+s3.Bucket+"/"+strings.Trim(s3.Prefix, "/"))
+		container.Env = append(container.Env,
+			corev1.EnvVar{Name: "S3_ENDPOINT", Value: s3.Endpoint},
+			corev1.EnvVar{Name: "S3_REGION", Value: s3.Region},
+		)
+		if s3.SecretName != "" {
+			for _, env := range []struct {
+				name, key string
+				optional  bool
+			}{{"AWS_ACCESS_KEY_ID", "accessKeyID", false}, {"AWS_SECRET_ACCESS_KEY", "secretAccessKey", false}, {"AWS_SESSION_TOKEN", "sessionToken", true}} {
+				optional := env.optional
+				container.Env = append(container.Env, corev1.EnvVar{
+					Name: env.name,
+					ValueFrom: &corev1.EnvVarSource{
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: s3.SecretName},
+							Key:                  env.key,
+							Optional:             &optional,
+						},
+					},
+				})
+			}
+		}
+		podSpec.ServiceAccountName = s3.ServiceAccountName
+	}
+	if bk.ZookeeperTLS() != nil {
+		args = append(args, "--zookeeper-ca-file", filepath.Join(ZookeeperTLSMountPath, "ca.crt"))
+	}
+	container.Args = args
+	podSpec.Containers = []corev1.Container{container}
+	if zkTLS := bk.ZookeeperTLS(); zkTLS != nil {
+		addSecretVolume(&podSpec, zookeeperTLSVolume, zkTLS.SecretName, ZookeeperTLSMountPath)
+	}
+
+	return batchv1.JobSpec{
+		BackoffLimit: &backoffLimit,
+		Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{
+				Labels: labelsForBookieJob(bk, "bookie-"+command),
+			},
+			Spec: podSpec,
+		},
+	}
+}
@@ -0,0 +1,325 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Metadata backup", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+		Image     = "pravega/bookkeeper-operator:latest"
+	)
+
+	var (
+		s      = scheme.Scheme
+		bk     *v1alpha1.BookkeeperCluster
+		b      *v1alpha1.BookkeeperBackup
+		client client.Client
+	)
+
+	BeforeEach(func() {
+		bk = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      Name,
+				Namespace: Namespace,
+			},
+			Spec: v1alpha1.BookkeeperClusterSpec{
+				ZookeeperUri: "zk-client:2181",
+				Options:      map[string]string{"zkLedgersRootPath": "/ledgers"},
+			},
+		}
+		bk.WithDefaults()
+		bk.Status.CurrentVersion = bk.Spec.Version
+		b = &v1alpha1.BookkeeperBackup{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "nightly",
+				Namespace: Namespace,
+			},
+			Spec: v1alpha1.BookkeeperBackupSpec{
+				ClusterName: Name,
+				Storage: v1alpha1.BackupStorageSpec{
+					PersistentVolumeClaim: &v1alpha1.PVCBackupStorage{ClaimName: "backups", Path: "snapshots"},
+				},
+			},
+		}
+		s.AddKnownTypes(v1alpha1.GroupVersion, bk, b, &v1alpha1.BookkeeperClusterList{}, &v1alpha1.BookkeeperBackupList{})
+	})
+
+	Context("Jobs", func() {
+		It("should back up to the pvc", func() {
+			job := MakeBackupJob(b, bk, Image, "/ledgers", "example-1")
+			Ω(job.Name).Should(Equal("nightly-backup"))
+			podSpec := job.Spec.Template.Spec
+			Ω(podSpec.Containers[0].Image).Should(Equal(Image))
+			Ω(strings.Join(podSpec.Containers[0].Args, " ")).Should(Equal("backup --cluster example --zookeeper-uri zk-client:2181 --ledgers-root /ledgers --snapshot example-1 --location file:///snapshots/snapshots"))
+			Ω(podSpec.Volumes[0].PersistentVolumeClaim.ClaimName).Should(Equal("backups"))
+			Ω(podSpec.Containers[0].VolumeMounts[0].MountPath).Should(Equal(SnapshotsMountPath))
+		})
+		It("should back up to s3 with the credentials of the secret", func() {
+			b.Spec.Storage = v1alpha1.BackupStorageSpec{
+				S3: &v1alpha1.S3BackupStorage{Endpoint: "http://minio:9000", Bucket: "backups", Prefix: "/prod/", SecretName: "s3-creds"},
+			}
+			job := MakeBackupJob(b, bk, Image, "/ledgers", "example-1")
+			container := job.Spec.Template.Spec.Containers[0]
+			Ω(container.Args).Should(ContainElement("s3://backups/prod"))
+			env := map[string]corev1.EnvVar{}
+			for _, e := range container.Env {
+				env[e.Name] = e
+			}
+			Ω(env["S3_ENDPOINT"].Value).Should(Equal("http://minio:9000"))
+			Ω(env["AWS_ACCESS_KEY_ID"].ValueFrom.SecretKeyRef.Name).Should(Equal("s3-creds"))
+			Ω(env["AWS_SECRET_ACCESS_KEY"].ValueFrom.SecretKeyRef.Key).Should(Equal("secretAccessKey"))
+			Ω(*env["AWS_SESSION_TOKEN"].ValueFrom.SecretKeyRef.Optional).Should(BeTrue())
+			Ω(job.Spec.Template.Spec.Volumes).Should(BeEmpty())
+		})
+		It("should back up to s3 with the role of the service account", func() {
+			b.Spec.Storage = v1alpha1.BackupStorageSpec{
+				S3: &v1alpha1.S3BackupStorage{Bucket: "backups", ServiceAccountName: "backup"},
+			}
+			Ω(validateBackupStorage(&b.Spec.Storage)).Should(Succeed())
+			podSpec := MakeBackupJob(b, bk, Image, "/ledgers", "example-1").Spec.Template.Spec
+			Ω(podSpec.ServiceAccountName).Should(Equal("backup"))
+			for _, e := range podSpec.Containers[0].Env {
+				Ω(e.Name).ShouldNot(HavePrefix("AWS_"))
+			}
+		})
+		It("should use the zookeeper client configuration of the cluster", func() {
+			bk.Spec.Zookeeper = &v1alpha1.ZookeeperClientSpec{
+				Auth: &v1alpha1.ZookeeperAuthSpec{SecretName: "zk-auth"},
+				TLS:  &v1alpha1.ZookeeperTLSSpec{SecretName: "zk-tls"},
+			}
+			job := MakeBackupJob(b, bk, Image, "/ledgers", "example-1")
+			container := job.Spec.Template.Spec.Containers[0]
+			Ω(container.Args).Should(ContainElement(ZookeeperTLSMountPath + "/ca.crt"))
+			Ω(container.Env[0].Name).Should(Equal("ZK_USERNAME"))
+			Ω(container.Env[0].ValueFrom.SecretKeyRef.Name).Should(Equal("zk-auth"))
+			Ω(job.Spec.Template.Spec.Volumes).Should(HaveLen(2))
+		})
+		It("should schedule the backups without a snapshot name", func() {
+			b.Spec.Schedule = "0 2 * * *"
+			cronJob := MakeBackupCronJob(b, bk, Image, "/ledgers")
+			Ω(cronJob.Spec.Schedule).Should(Equal("0 2 * * *"))
+			Ω(cronJob.Spec.ConcurrencyPolicy).Should(Equal(batchv1.ForbidConcurrent))
+			Ω(cronJob.Spec.JobTemplate.Spec.Template.Spec.Containers[0].Args).ShouldNot(ContainElement("--snapshot"))
+		})
+		It("should restore the snapshot of spec.restore", func() {
+			bk.Spec.Restore = &v1alpha1.RestoreSpec{BackupName: "nightly", Snapshot: "old-1"}
+			job := MakeBookieRestoreJob(bk, b, Image, "/ledgers", false)
+			Ω(job.Name).Should(Equal("example-bookie-restore"))
+			Ω(strings.Join(job.Spec.Template.Spec.Containers[0].Args, " ")).Should(HavePrefix("restore --cluster example --zookeeper-uri zk-client:2181 --ledgers-root /ledgers --snapshot old-1"))
+			Ω(job.Spec.Template.Spec.Containers[0].Args).ShouldNot(ContainElement("--skip-cookies"))
+			job = MakeBookieRestoreJob(bk, b, Image, "/ledgers", true)
+			Ω(job.Spec.Template.Spec.Containers[0].Args).Should(ContainElement("--skip-cookies"))
+		})
+		It("should require exactly one storage", func() {
+			Ω(validateBackupStorage(&b.Spec.Storage)).Should(BeNil())
+			b.Spec.Storage.S3 = &v1alpha1.S3BackupStorage{Bucket: "backups", SecretName: "s3-creds"}
+			Ω(validateBackupStorage(&b.Spec.Storage)).ShouldNot(BeNil())
+			Ω(validateBackupStorage(&v1alpha1.BackupStorageSpec{})).ShouldNot(BeNil())
+			Ω(validateBackupStorage(&v1alpha1.BackupStorageSpec{S3: &v1alpha1.S3BackupStorage{SecretName: "s3-creds"}})).ShouldNot(BeNil())
+		})
+	})
+
+	Context("BookkeeperBackup reconciliation", func() {
+		var (
+			r   *BookkeeperBackupReconciler
+			req ctrl.Request
+		)
+		BeforeEach(func() {
+			req = ctrl.Request{NamespacedName: types.NamespacedName{Name: b.Name, Namespace: Namespace}}
+		})
+		JustBeforeEach(func() {
+			client = fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(bk, b).Build()
+			r = &BookkeeperBackupReconciler{Client: applyClient{client}, Scheme: s, Recorder: record.NewFakeRecorder(100), OperatorImage: Image}
+		})
+
+		It("should take a single snapshot", func() {
+			_, err := r.Reconcile(context.TODO(), req)
+			Ω(err).Should(BeNil())
+			found := &v1alpha1.BookkeeperBackup{}
+			client.Get(context.TODO(), req.NamespacedName, found)
+			Ω(found.Status.Phase).Should(Equal(v1alpha1.BookkeeperBackupRunning))
+			Ω(found.Status.Snapshot).Should(HavePrefix("example-"))
+			job := &batchv1.Job{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "nightly-backup", Namespace: Namespace}, job)
+			Ω(err).Should(BeNil())
+			Ω(job.Spec.Template.Spec.Containers[0].Args).Should(ContainElement(found.Status.Snapshot))
+			Ω(job.OwnerReferences[0].Name).Should(Equal(b.Name))
+
+			job.Status.Succeeded = 1
+			client.Update(context.TODO(), job)
+			_, err = r.Reconcile(context.TODO(), req)
+			Ω(err).Should(BeNil())
+			client.Get(context.TODO(), req.NamespacedName, found)
+			Ω(found.Status.Phase).Should(Equal(v1alpha1.BookkeeperBackupCompleted))
+			Ω(found.Status.CompletionTime).ShouldNot(BeNil())
+		})
+		It("should report a failed backup job", func() {
+			r.Reconcile(context.TODO(), req)
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "nightly-backup", Namespace: Namespace}, job)
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+			client.Update(context.TODO(), job)
+			_, err := r.Reconcile(context.TODO(), req)
+			Ω(err).Should(BeNil())
+			found := &v1alpha1.BookkeeperBackup{}
+			client.Get(context.TODO(), req.NamespacedName, found)
+			Ω(found.Status.Phase).Should(Equal(v1alpha1.BookkeeperBackupFailed))
+			Ω(found.Status.Message).Should(ContainSubstring("BackoffLimitExceeded"))
+		})
+
+		Context("With a schedule", func() {
+			BeforeEach(func() {
+				b.Spec.Schedule = "0 2 * * *"
+			})
+			It("should create the cronjob", func() {
+				_, err := r.Reconcile(context.TODO(), req)
+				Ω(err).Should(BeNil())
+				cronJob := &batchv1.CronJob{}
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "nightly-backup", Namespace: Namespace}, cronJob)
+				Ω(err).Should(BeNil())
+				found := &v1alpha1.BookkeeperBackup{}
+				client.Get(context.TODO(), req.NamespacedName, found)
+				Ω(found.Status.Phase).Should(Equal(v1alpha1.BookkeeperBackupScheduled))
+			})
+			It("should delete the cronjob when the schedule is removed", func() {
+				r.Reconcile(context.TODO(), req)
+				found := &v1alpha1.BookkeeperBackup{}
+				client.Get(context.TODO(), req.NamespacedName, found)
+				found.Spec.Schedule = ""
+				client.Update(context.TODO(), found)
+				_, err := r.Reconcile(context.TODO(), req)
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "nightly-backup", Namespace: Namespace}, &batchv1.CronJob{})
+				Ω(err).ShouldNot(BeNil())
+			})
+		})
+
+		Context("With invalid storage", func() {
+			BeforeEach(func() {
+				b.Spec.Storage = v1alpha1.BackupStorageSpec{}
+			})
+			It("should fail without creating a job", func() {
+				_, err := r.Reconcile(context.TODO(), req)
+				Ω(err).Should(BeNil())
+				found := &v1alpha1.BookkeeperBackup{}
+				client.Get(context.TODO(), req.NamespacedName, found)
+				Ω(found.Status.Phase).Should(Equal(v1alpha1.BookkeeperBackupFailed))
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "nightly-backup", Namespace: Namespace}, &batchv1.Job{})
+				Ω(err).ShouldNot(BeNil())
+			})
+		})
+	})
+
+	Context("Restore", func() {
+		var (
+			r           *BookkeeperClusterReconciler
+			initialized bool
+			err         error
+		)
+		BeforeEach(func() {
+			bk.Status.InstanceID = ""
+			bk.Spec.Restore = &v1alpha1.RestoreSpec{BackupName: "nightly", Snapshot: "old-1"}
+		})
+		JustBeforeEach(func() {
+			client = fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(bk, b).Build()
+			r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100), OperatorImage: Image}
+			initialized, err = r.reconcileMetadata(context.TODO(), bk)
+		})
+
+		It("should run the restore job before the metadata init job", func() {
+			Ω(err).Should(BeNil())
+			Ω(initialized).Should(BeFalse())
+			job := &batchv1.Job{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, job)
+			Ω(err).Should(BeNil())
+			// the cluster has no volumes yet, whose cookies would match
+			Ω(job.Spec.Template.Spec.Containers[0].Args).Should(ContainElement("--skip-cookies"))
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: Namespace}, &batchv1.Job{})
+			Ω(err).ShouldNot(BeNil())
+		})
+		It("should record the restored snapshot and initialize the metadata", func() {
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, job)
+			job.Status.Succeeded = 1
+			client.Update(context.TODO(), job)
+			initialized, err = r.reconcileMetadata(context.TODO(), bk)
+			Ω(err).Should(BeNil())
+			Ω(initialized).Should(BeFalse())
+			Ω(bk.Status.RestoredSnapshot).Should(Equal("old-1"))
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, &batchv1.Job{})
+			Ω(err).ShouldNot(BeNil())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: Namespace}, &batchv1.Job{})
+			Ω(err).Should(BeNil())
+		})
+		It("should stop on a failed restore and run it again", func() {
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, job)
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+			client.Update(context.TODO(), job)
+			initialized, err = r.reconcileMetadata(context.TODO(), bk)
+			Ω(err).ShouldNot(BeNil())
+			Ω(initialized).Should(BeFalse())
+			_, condition := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionMetadataInitialized)
+			Ω(condition.Status).Should(Equal(corev1.ConditionFalse))
+			Ω(condition.Reason).Should(Equal(EventReasonMetadataRestoreFailed))
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, &batchv1.Job{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+
+			initialized, err = r.reconcileMetadata(context.TODO(), bk)
+			Ω(err).Should(BeNil())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, &batchv1.Job{})
+			Ω(err).Should(BeNil())
+		})
+
+		Context("With a missing backup", func() {
+			BeforeEach(func() {
+				bk.Spec.Restore.BackupName = "missing"
+			})
+			It("should not initialize the metadata", func() {
+				Ω(err).ShouldNot(BeNil())
+				Ω(initialized).Should(BeFalse())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: Namespace}, &batchv1.Job{})
+				Ω(err).ShouldNot(BeNil())
+			})
+		})
+
+		Context("Once restored", func() {
+			BeforeEach(func() {
+				bk.Status.RestoredSnapshot = "old-1"
+			})
+			It("should go on with the metadata init job", func() {
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, &batchv1.Job{})
+				Ω(err).ShouldNot(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: Namespace}, &batchv1.Job{})
+				Ω(err).Should(BeNil())
+			})
+		})
+	})
+})
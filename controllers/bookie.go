@@ -12,15 +12,21 @@ package controllers
 
 import (
 	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
 	"github.com/pravega/bookkeeper-operator/pkg/util"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
 	policyv1 "k8s.io/api/policy/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/util/intstr"
 )
 
@@ -30,6 +36,40 @@ const (
 	IndexDiskName   = "index"
 )
 
+// InstanceIDAnnotation records on each bookie PVC the instance ID of the
+// cluster whose data it holds
+const InstanceIDAnnotation = "bookkeeper.pravega.io/instance-id"
+
+// RepairPodUIDAnnotation records on a repair Job the UID of the bookie pod
+// that failed with a cookie mismatch
+const RepairPodUIDAnnotation = "bookkeeper.pravega.io/repair-pod-uid"
+
+const (
+	// ZoneLabel is the node label holding the zone of a node
+	ZoneLabel = "topology.kubernetes.io/zone"
+
+	// RackScriptPath is where the rack resolution script is mounted in bookies
+	RackScriptPath = "/opt/bookkeeper/racks/topology.sh"
+
+	// DefaultRack is the rack of bookies whose zone is not known yet
+	DefaultRack = "/default-rack"
+
+	// rackScript prints the rack of each bookie address given as argument,
+	// looking it up in the racks file maintained by the operator
+	rackScript = `#!/bin/sh
+for addr in "$@"; do
+  host="${addr%%:*}"
+  rack=$(awk -v h="$host" '$1 == h || $2 == h { print $3; exit }' /opt/bookkeeper/racks/racks)
+  echo "${rack:-` + DefaultRack + `}"
+done
+`
+)
+
+var (
+	ServiceMonitorGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "ServiceMonitor"}
+	PrometheusRuleGVK = schema.GroupVersionKind{Group: "monitoring.coreos.com", Version: "v1", Kind: "PrometheusRule"}
+)
+
 func MakeBookieHeadlessService(bk *v1alpha1.BookkeeperCluster) *corev1.Service {
 	return &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -42,14 +82,78 @@ func MakeBookieHeadlessService(bk *v1alpha1.BookkeeperCluster) *corev1.Service {
 			Labels:    bk.LabelsForBookie(),
 		},
 		Spec: corev1.ServiceSpec{
+			Ports:     makeBookieServicePorts(bk),
+			Selector:  bk.LabelsForBookie(),
+			ClusterIP: corev1.ClusterIPNone,
+		},
+	}
+}
+
+func makeBookieServicePorts(bk *v1alpha1.BookkeeperCluster) []corev1.ServicePort {
+	ports := []corev1.ServicePort{
+		{
+			Name: "bookie",
+			Port: 3181,
+		},
+	}
+	if bk.IsHTTPServerEnabled() {
+		ports = append(ports, corev1.ServicePort{
+			Name: "http",
+			Port: bk.Spec.HTTPServer.Port,
+		})
+	}
+	if bk.IsMetricsEnabled() {
+		ports = append(ports, corev1.ServicePort{
+			Name: "metrics",
+			Port: bk.Spec.Metrics.Port,
+		})
+	}
+	return ports
+}
+
+func makeBookieContainerPorts(bk *v1alpha1.BookkeeperCluster) []corev1.ContainerPort {
+	var ports []corev1.ContainerPort
+	for _, port := range makeBookieServicePorts(bk) {
+		ports = append(ports, corev1.ContainerPort{
+			Name:          port.Name,
+			ContainerPort: port.Port,
+		})
+	}
+	return ports
+}
+
+// MakeBookieExternalService returns the Service exposing the bookie with the
+// given ordinal. The Service is named after the bookie pod so that
+// syncStatefulSetExternalServices can find the ones left over after a scale down.
+func MakeBookieExternalService(bk *v1alpha1.BookkeeperCluster, ordinal int32) *corev1.Service {
+	podName := fmt.Sprintf("%s-%d", util.StatefulSetNameForBookie(bk.Name), ordinal)
+	annotations := map[string]string{}
+	for k, v := range bk.Spec.ExternalAccess.Annotations {
+		annotations[k] = strings.ReplaceAll(v, "$(POD_NAME)", podName)
+	}
+	return &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Service",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        podName,
+			Namespace:   bk.Namespace,
+			Labels:      bk.LabelsForBookie(),
+			Annotations: annotations,
+		},
+		Spec: corev1.ServiceSpec{
+			Type: bk.Spec.ExternalAccess.Type,
 			Ports: []corev1.ServicePort{
 				{
 					Name: "bookie",
 					Port: 3181,
 				},
 			},
-			Selector:  bk.LabelsForBookie(),
-			ClusterIP: corev1.ClusterIPNone,
+			Selector: map[string]string{
+				appsv1.StatefulSetPodNameLabel: podName,
+			},
+			PublishNotReadyAddresses: true,
 		},
 	}
 }
@@ -92,7 +196,8 @@ func MakeBookiePodTemplate(bk *v1alpha1.BookkeeperCluster) corev1.PodTemplateSpe
 	}
 }
 
-func makeBookiePodSpec(bk *v1alpha1.BookkeeperCluster) *corev1.PodSpec {
+// makeBookieEnvFrom returns the ConfigMaps the bookie configuration is read from
+func makeBookieEnvFrom(bk *v1alpha1.BookkeeperCluster) []corev1.EnvFromSource {
 	environment := []corev1.EnvFromSource{
 		{
 			ConfigMapRef: &corev1.ConfigMapEnvSource{
@@ -113,6 +218,11 @@ func makeBookiePodSpec(bk *v1alpha1.BookkeeperCluster) *corev1.PodSpec {
 			},
 		})
 	}
+	return environment
+}
+
+func makeBookiePodSpec(bk *v1alpha1.BookkeeperCluster) *corev1.PodSpec {
+	environment := makeBookieEnvFrom(bk)
 
 	var ledgerDirs, journalDirs, indexDirs []string
 	var ledgerSubPath, journalSubPath, indexSubPath string
@@ -231,76 +341,304 @@ func makeBookiePodSpec(bk *v1alpha1.BookkeeperCluster) *corev1.PodSpec {
 		volumeMounts = append(volumeMounts, cmVolumeMounts...)
 	}
 
+	readinessHandler, livenessHandler := makeBookieProbeHandlers(bk)
+
 	podSpec := &corev1.PodSpec{
 		Containers: []corev1.Container{
 			{
 				Name:            "bookie",
 				Image:           bk.BookkeeperImage(),
 				ImagePullPolicy: bk.Spec.Image.PullPolicy,
-				Ports: []corev1.ContainerPort{
-					{
-						Name:          "bookie",
-						ContainerPort: 3181,
-					},
-				},
-				EnvFrom:      environment,
-				VolumeMounts: volumeMounts,
-				Resources:    *bk.Spec.Resources,
-				ReadinessProbe: &corev1.Probe{
-					ProbeHandler: corev1.ProbeHandler{
-						Exec: &corev1.ExecAction{
-							Command: []string{"/bin/sh", "-c", "/opt/bookkeeper/bin/bookkeeper shell bookiesanity"},
-						},
-					},
-					// Bookie pods should start fast. We give it up to 1.5 minute to become ready.
-					InitialDelaySeconds: bk.Spec.Probes.ReadinessProbe.InitialDelaySeconds,
-					PeriodSeconds:       bk.Spec.Probes.ReadinessProbe.PeriodSeconds,
-					FailureThreshold:    bk.Spec.Probes.ReadinessProbe.FailureThreshold,
-					SuccessThreshold:    bk.Spec.Probes.ReadinessProbe.SuccessThreshold,
-					TimeoutSeconds:      bk.Spec.Probes.ReadinessProbe.TimeoutSeconds,
-				},
-				LivenessProbe: &corev1.Probe{
-					ProbeHandler: corev1.ProbeHandler{
-						Exec: &corev1.ExecAction{
-							Command: util.HealthcheckCommand(3181),
-						},
-					},
-					// We start the liveness probe from the maximum time the pod can take
-					// before becoming ready.
-					// If the pod fails the health check during 1 minute, Kubernetes
-					// will restart it.
-					InitialDelaySeconds: bk.Spec.Probes.LivenessProbe.InitialDelaySeconds,
-					PeriodSeconds:       bk.Spec.Probes.LivenessProbe.PeriodSeconds,
-					FailureThreshold:    bk.Spec.Probes.LivenessProbe.FailureThreshold,
-					SuccessThreshold:    bk.Spec.Probes.LivenessProbe.SuccessThreshold,
-					TimeoutSeconds:      bk.Spec.Probes.LivenessProbe.TimeoutSeconds,
-				},
+				Ports:           makeBookieContainerPorts(bk),
+				Env:             makeBookieEnv(bk),
+				EnvFrom:         environment,
+				VolumeMounts:    volumeMounts,
+				Resources:       *bk.Spec.Resources,
+				ReadinessProbe:  makeBookieProbe(bk.Spec.Probes.ReadinessProbe, readinessHandler),
+				// The liveness probe only starts once the startup probe succeeded,
+				// so a bookie replaying a large journal is not restarted.
+				LivenessProbe: makeBookieProbe(bk.Spec.Probes.LivenessProbe, livenessHandler),
+				StartupProbe:  makeBookieProbe(bk.Spec.Probes.StartupProbe, livenessHandler),
+				// the last log lines of a failed bookie are kept in its status,
+				// where the operator looks for cookie mismatches
+				TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
 			},
 		},
-		Affinity:    bk.Spec.Affinity,
-		Volumes:     volumes,
-		Tolerations: bk.Spec.Tolerations,
+		Affinity:                      bk.Spec.Affinity,
+		Volumes:                       volumes,
+		Tolerations:                   bk.Spec.Tolerations,
+		NodeSelector:                  bk.Spec.NodeSelector,
+		PriorityClassName:             bk.Spec.PriorityClassName,
+		TopologySpreadConstraints:     bk.Spec.TopologySpreadConstraints,
+		TerminationGracePeriodSeconds: bk.Spec.TerminationGracePeriodSeconds,
+		DNSConfig:                     bk.Spec.DNSConfig,
+		HostNetwork:                   bk.Spec.HostNetwork,
+	}
+
+	if bk.IsRackAware() {
+		addBookieRackAwareness(bk, podSpec)
 	}
 
+	if bk.IsGracefulShutdownEnabled() {
+		podSpec.Containers[0].Lifecycle = &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{
+					Command: util.PreStopCommand(bk.Spec.HTTPServer.Port, bk.Spec.GracefulShutdown.DrainSeconds),
+				},
+			},
+		}
+	}
+
+	if bk.Spec.HostNetwork {
+		// keep resolving cluster services from the host network
+		podSpec.DNSPolicy = corev1.DNSClusterFirstWithHostNet
+	}
+
+	// the bookie container stays first, the rest of the operator relies on it
+	podSpec.Containers = append(podSpec.Containers, bk.Spec.Sidecars...)
+
 	if bk.Spec.ServiceAccountName != "" {
 		podSpec.ServiceAccountName = bk.Spec.ServiceAccountName
 	}
 
+	addZookeeperClientVolumes(bk, podSpec)
+
+	if bk.IsReadOnlyRootFilesystem() {
+		addBookieWritableVolumes(bk, podSpec)
+	}
 	if bk.Spec.InitContainers != nil {
-		podSpec.InitContainers = bk.Spec.InitContainers
-	}
-	if *bk.Spec.RunAsPrivilegedUser == false {
-		id := int64(1000)
-		podSpec.SecurityContext = &corev1.PodSecurityContext{
-			RunAsUser:  &id,
-			RunAsGroup: &id,
-			FSGroup:    &id,
-		}
+		podSpec.InitContainers = append(podSpec.InitContainers, bk.Spec.InitContainers...)
 	}
+	podSpec.SecurityContext = bk.PodSecurityContextForBookie()
+	podSpec.Containers[0].SecurityContext = bk.Spec.ContainerSecurityContext
 
 	return podSpec
 }
 
+// addBookieRackAwareness spreads the bookies evenly across zones and mounts
+// the racks ConfigMap used by the rack resolution script
+func addBookieRackAwareness(bk *v1alpha1.BookkeeperCluster, podSpec *corev1.PodSpec) {
+	selector := bk.LabelsForBookkeeperCluster()
+	selector["component"] = "bookie"
+	podSpec.TopologySpreadConstraints = append(podSpec.TopologySpreadConstraints, corev1.TopologySpreadConstraint{
+		MaxSkew:           1,
+		TopologyKey:       ZoneLabel,
+		WhenUnsatisfiable: corev1.DoNotSchedule,
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: selector,
+		},
+	})
+	if len(bk.Spec.RackAware.Zones) > 0 {
+		podSpec.Affinity = requireNodeAffinity(podSpec.Affinity, corev1.NodeSelectorRequirement{
+			Key:      ZoneLabel,
+			Operator: corev1.NodeSelectorOpIn,
+			Values:   bk.Spec.RackAware.Zones,
+		})
+	}
+
+	mode := int32(0755)
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: "racks",
+		VolumeSource: corev1.VolumeSource{
+			ConfigMap: &corev1.ConfigMapVolumeSource{
+				LocalObjectReference: corev1.LocalObjectReference{
+					Name: util.RackConfigMapNameForBookie(bk.Name),
+				},
+				DefaultMode: &mode,
+			},
+		},
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      "racks",
+		MountPath: filepath.Dir(RackScriptPath),
+	})
+}
+
+// requireNodeAffinity returns a copy of the given affinity that only
+// schedules on nodes matching req, besides its own required node affinity.
+// Since node selector terms are ORed, req is added to each of them.
+func requireNodeAffinity(affinity *corev1.Affinity, req corev1.NodeSelectorRequirement) *corev1.Affinity {
+	if affinity == nil {
+		affinity = &corev1.Affinity{}
+	} else {
+		affinity = affinity.DeepCopy()
+	}
+	if affinity.NodeAffinity == nil {
+		affinity.NodeAffinity = &corev1.NodeAffinity{}
+	}
+	required := affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution
+	if required == nil || len(required.NodeSelectorTerms) == 0 {
+		required = &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{}}}
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution = required
+	}
+	for i := range required.NodeSelectorTerms {
+		term := &required.NodeSelectorTerms[i]
+		term.MatchExpressions = append(term.MatchExpressions, req)
+	}
+	return affinity
+}
+
+// MakeBookieRackConfigMap returns the ConfigMap mapping each scheduled bookie
+// to the zone of its node. Each line of the racks file holds the host of the
+// bookie ID, which is its advertised address with external access, the pod
+// IP and the rack.
+func MakeBookieRackConfigMap(bk *v1alpha1.BookkeeperCluster, pods []corev1.Pod, zones map[string]string) *corev1.ConfigMap {
+	var lines []string
+	for i, pod := range pods {
+		zone, ok := zones[pod.Spec.NodeName]
+		if !ok || zone == "" {
+			continue
+		}
+		hostname, _, _ := net.SplitHostPort(bookieIDForPod(bk, pod.Name, &pods[i]))
+		ip := pod.Status.PodIP
+		if ip == "" {
+			ip = "-"
+		}
+		lines = append(lines, fmt.Sprintf("%s %s /%s", hostname, ip, zone))
+	}
+	sort.Strings(lines)
+
+	return &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.RackConfigMapNameForBookie(bk.Name),
+			Namespace: bk.Namespace,
+			Labels:    bk.LabelsForBookkeeperCluster(),
+		},
+		Data: map[string]string{
+			"topology.sh": rackScript,
+			"racks":       strings.Join(lines, "\n"),
+		},
+	}
+}
+
+// addBookieWritableVolumes mounts emptyDir volumes over the directories the
+// bookie writes to outside of its data directories. The configuration
+// shipped with the image is copied into its volume by an init container,
+// since the bookie entrypoint rewrites it from the BK_ environment.
+func addBookieWritableVolumes(bk *v1alpha1.BookkeeperCluster, podSpec *corev1.PodSpec) {
+	writableDirs := []struct{ name, path string }{
+		{"conf", "/opt/bookkeeper/conf"},
+		{"logs", "/opt/bookkeeper/logs"},
+		{"tmp", "/tmp"},
+	}
+	for _, dir := range writableDirs {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: dir.name,
+			VolumeSource: corev1.VolumeSource{
+				EmptyDir: &corev1.EmptyDirVolumeSource{},
+			},
+		})
+		podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      dir.name,
+			MountPath: dir.path,
+		})
+	}
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:            "copy-conf",
+		Image:           bk.BookkeeperImage(),
+		ImagePullPolicy: bk.Spec.Image.PullPolicy,
+		Command:         []string{"/bin/sh", "-c", "cp -a /opt/bookkeeper/conf/. /conf/"},
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      "conf",
+				MountPath: "/conf",
+			},
+		},
+		SecurityContext: bk.Spec.ContainerSecurityContext,
+	})
+}
+
+// makeBookieProbeHandlers returns the readiness and liveness checks for the
+// probe mode of the cluster. The liveness check is also used at startup.
+func makeBookieProbeHandlers(bk *v1alpha1.BookkeeperCluster) (readiness corev1.ProbeHandler, liveness corev1.ProbeHandler) {
+	mode := bk.Spec.Probes.Mode
+	if mode == v1alpha1.ProbeModeHTTP && !bk.IsHTTPServerEnabled() {
+		// the webhook refuses this, fall back to the default
+		mode = v1alpha1.ProbeModeExec
+	}
+	switch mode {
+	case v1alpha1.ProbeModeHTTP:
+		port := intstr.FromInt(int(bk.Spec.HTTPServer.Port))
+		readiness = corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/api/v1/bookie/is_ready", Port: port},
+		}
+		liveness = corev1.ProbeHandler{
+			HTTPGet: &corev1.HTTPGetAction{Path: "/heartbeat", Port: port},
+		}
+	case v1alpha1.ProbeModeTCP:
+		readiness = corev1.ProbeHandler{
+			TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(3181)},
+		}
+		liveness = readiness
+	default:
+		readiness = corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: []string{"/bin/sh", "-c", "/opt/bookkeeper/bin/bookkeeper shell bookiesanity"},
+			},
+		}
+		liveness = corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{
+				Command: util.HealthcheckCommand(3181),
+			},
+		}
+	}
+	return readiness, liveness
+}
+
+func makeBookieProbe(probe *v1alpha1.Probe, handler corev1.ProbeHandler) *corev1.Probe {
+	if probe == nil {
+		return nil
+	}
+	return &corev1.Probe{
+		ProbeHandler:        handler,
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		FailureThreshold:    probe.FailureThreshold,
+		SuccessThreshold:    probe.SuccessThreshold,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+	}
+}
+
+// makeBookieEnv returns the per-pod environment of the bookie container.
+// Settings that are the same for every bookie go to the ConfigMap instead.
+func makeBookieEnv(bk *v1alpha1.BookkeeperCluster) []corev1.EnvVar {
+	var env []corev1.EnvVar
+	if bk.IsExternalAccessEnabled() {
+		env = append(env, util.DownwardAPIEnv()...)
+		env = append(env, corev1.EnvVar{
+			Name:  "BK_advertisedAddress",
+			Value: bk.AdvertisedAddressForBookie(),
+		})
+	}
+	env = append(env, makeBookieSecretOptionsEnv(bk)...)
+	return append(env, bk.Spec.Env...)
+}
+
+// makeBookieSecretOptionsEnv returns the environment variables reading the
+// secret options of the bookies from their Secrets
+func makeBookieSecretOptionsEnv(bk *v1alpha1.BookkeeperCluster) []corev1.EnvVar {
+	keys := make([]string, 0, len(bk.Spec.SecretOptions))
+	for k := range bk.Spec.SecretOptions {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var env []corev1.EnvVar
+	for _, k := range keys {
+		selector := bk.Spec.SecretOptions[k]
+		env = append(env, corev1.EnvVar{
+			Name: fmt.Sprintf("BK_%s", k),
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &selector,
+			},
+		})
+	}
+	return env
+}
+
 func createVolumeMount(ledgerDirs []string, journalDirs []string, indexDirs []string, ledgerSubPath string, journalSubPath string, indexSubPath string, hostPathVolumeMounts []string, emptyDirVolumeMounts []string) []corev1.VolumeMount {
 	var volumeMounts []corev1.VolumeMount
 	if len(ledgerDirs) > 1 {
@@ -378,7 +716,7 @@ func createVolumeMount(ledgerDirs []string, journalDirs []string, indexDirs []st
 }
 
 func makeBookieVolumeClaimTemplates(bk *v1alpha1.BookkeeperCluster) []corev1.PersistentVolumeClaim {
-	return []corev1.PersistentVolumeClaim{
+	pvcs := []corev1.PersistentVolumeClaim{
 		{
 			ObjectMeta: metav1.ObjectMeta{
 				Name:      JournalDiskName,
@@ -401,6 +739,87 @@ func makeBookieVolumeClaimTemplates(bk *v1alpha1.BookkeeperCluster) []corev1.Per
 			Spec: *bk.Spec.Storage.IndexVolumeClaimTemplate,
 		},
 	}
+	if bk.Spec.Storage.IsNodeLocal() {
+		// claims bind to the node local volumes created by the operator
+		for i := range pvcs {
+			pvcs[i].Spec = *pvcs[i].Spec.DeepCopy()
+			pvcs[i].Spec.StorageClassName = &bk.Spec.Storage.Local.StorageClassName
+		}
+	}
+	if bk.Status.InstanceID != "" {
+		// the templates of an existing stateful set can't be changed, so only
+		// the claims of stateful sets created after the metadata was
+		// initialized are annotated
+		for i := range pvcs {
+			pvcs[i].Annotations = map[string]string{InstanceIDAnnotation: bk.Status.InstanceID}
+		}
+	}
+	return pvcs
+}
+
+// MakeBookieLocalPersistentVolumes returns the node local volumes backing the
+// journal, ledger and index claims of every bookie, local volumes in Local
+// mode and hostPath volumes in HostPath mode. Each volume is pre-bound to its
+// claim and carries a node affinity to Nodes[ordinal], which pins the bookie
+// to the node holding its data.
+func MakeBookieLocalPersistentVolumes(bk *v1alpha1.BookkeeperCluster) []*corev1.PersistentVolume {
+	local := bk.Spec.Storage.Local
+	paths := map[string]string{
+		JournalDiskName: local.JournalPath,
+		LedgerDiskName:  local.LedgerPath,
+		IndexDiskName:   local.IndexPath,
+	}
+	stsName := util.StatefulSetNameForBookie(bk.Name)
+	volumeMode := corev1.PersistentVolumeFilesystem
+	hostPathType := corev1.HostPathDirectoryOrCreate
+
+	var pvs []*corev1.PersistentVolume
+	for _, pvc := range makeBookieVolumeClaimTemplates(bk) {
+		for ordinal := 0; ordinal < int(bk.Spec.Replicas) && ordinal < len(local.Nodes); ordinal++ {
+			source := corev1.PersistentVolumeSource{
+				Local: &corev1.LocalVolumeSource{
+					Path: paths[pvc.Name],
+				},
+			}
+			if bk.Spec.Storage.Mode == v1alpha1.StorageModeHostPath {
+				source = corev1.PersistentVolumeSource{
+					HostPath: &corev1.HostPathVolumeSource{
+						Path: paths[pvc.Name],
+						Type: &hostPathType,
+					},
+				}
+			}
+			pvs = append(pvs, &corev1.PersistentVolume{
+				TypeMeta: metav1.TypeMeta{
+					Kind:       "PersistentVolume",
+					APIVersion: "v1",
+				},
+				ObjectMeta: metav1.ObjectMeta{
+					Name:   util.LocalPersistentVolumeNameForBookie(bk.Namespace, bk.Name, pvc.Name, ordinal),
+					Labels: bk.LabelsForBookkeeperCluster(),
+				},
+				Spec: corev1.PersistentVolumeSpec{
+					Capacity: corev1.ResourceList{
+						corev1.ResourceStorage: pvc.Spec.Resources.Requests[corev1.ResourceStorage],
+					},
+					AccessModes:                   []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+					PersistentVolumeReclaimPolicy: corev1.PersistentVolumeReclaimRetain,
+					StorageClassName:              local.StorageClassName,
+					VolumeMode:                    &volumeMode,
+					PersistentVolumeSource:        source,
+					ClaimRef: &corev1.ObjectReference{
+						Kind:      "PersistentVolumeClaim",
+						Namespace: bk.Namespace,
+						Name:      fmt.Sprintf("%s-%s-%d", pvc.Name, stsName, ordinal),
+					},
+					NodeAffinity: &corev1.VolumeNodeAffinity{
+						Required: util.NodeAffinityForHostnames([]string{local.Nodes[ordinal]}).RequiredDuringSchedulingIgnoredDuringExecution,
+					},
+				},
+			})
+		}
+	}
+	return pvs
 }
 
 func MakeBookieConfigMap(bk *v1alpha1.BookkeeperCluster) *corev1.ConfigMap {
@@ -421,8 +840,9 @@ func MakeBookieConfigMap(bk *v1alpha1.BookkeeperCluster) *corev1.ConfigMap {
 
 	extraOpts := []string{}
 	if bk.Spec.JVMOptions.ExtraOpts != nil {
-		extraOpts = bk.Spec.JVMOptions.ExtraOpts
+		extraOpts = append(extraOpts, bk.Spec.JVMOptions.ExtraOpts...)
 	}
+	extraOpts = append(extraOpts, makeZookeeperClientJVMOpts(bk)...)
 
 	configData := map[string]string{
 		"BOOKIE_MEM_OPTS":          strings.Join(memoryOpts, " "),
@@ -448,6 +868,23 @@ func MakeBookieConfigMap(bk *v1alpha1.BookkeeperCluster) *corev1.ConfigMap {
 		configData["BK_autoRecoveryDaemonEnabled"] = "false"
 	}
 
+	if bk.IsHTTPServerEnabled() {
+		configData["BK_httpServerEnabled"] = "true"
+		configData["BK_httpServerPort"] = fmt.Sprint(bk.Spec.HTTPServer.Port)
+	}
+
+	if bk.IsRackAware() {
+		configData["BK_ensemblePlacementPolicy"] = "org.apache.bookkeeper.client.RackawareEnsemblePlacementPolicy"
+		configData["BK_reppDnsResolverClass"] = "org.apache.bookkeeper.net.ScriptBasedMapping"
+		configData["BK_networkTopologyScriptFileName"] = RackScriptPath
+	}
+
+	if bk.IsMetricsEnabled() {
+		configData["BK_enableStatistics"] = "true"
+		configData["BK_statsProviderClass"] = "org.apache.bookkeeper.stats.prometheus.PrometheusMetricsProvider"
+		configData["BK_prometheusStatsHttpPort"] = fmt.Sprint(bk.Spec.Metrics.Port)
+	}
+
 	for k, v := range bk.Spec.Options {
 		prefixKey := fmt.Sprintf("BK_%s", k)
 		configData[prefixKey] = v
@@ -485,3 +922,265 @@ func MakeBookiePodDisruptionBudget(bk *v1alpha1.BookkeeperCluster) *policyv1.Pod
 		},
 	}
 }
+
+// MakeBookieMetadataInitJob returns the Job initializing the cluster metadata
+// in ZooKeeper, if needed, and reporting the cluster instance ID in the
+// termination message of its container
+func MakeBookieMetadataInitJob(bk *v1alpha1.BookkeeperCluster) *batchv1.Job {
+	return makeBookieShellJob(bk, util.MetadataInitJobNameForBookie(bk.Name), "metadata-init", util.MetadataInitCommand())
+}
+
+// MakeBookieDecommissionJob returns the Job decommissioning the bookie with the
+// given ID, which re-replicates its ledgers and deletes its cookie
+func MakeBookieDecommissionJob(bk *v1alpha1.BookkeeperCluster, podName string, bookieID string) *batchv1.Job {
+	return makeBookieShellJob(bk, util.DecommissionJobNameForBookie(podName), "bookie-decommission", util.DecommissionBookieCommand(bookieID))
+}
+
+// makeBookieShellJob returns a Job running the given command with the bookie
+// image and configuration
+func makeBookieShellJob(bk *v1alpha1.BookkeeperCluster, name string, component string, args []string) *batchv1.Job {
+	backoffLimit := int32(4)
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: bk.Namespace,
+			Labels:    labelsForBookieJob(bk, component),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labelsForBookieJob(bk, component),
+				},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{
+						{
+							Name:            component,
+							Image:           bk.BookkeeperImage(),
+							ImagePullPolicy: bk.Spec.Image.PullPolicy,
+							// the image entrypoint applies the BK_ settings before
+							// running the given command
+							Args:            args,
+							Env:             append(makeBookieSecretOptionsEnv(bk), bk.Spec.Env...),
+							EnvFrom:         makeBookieEnvFrom(bk),
+							SecurityContext: bk.Spec.ContainerSecurityContext,
+						},
+					},
+					ServiceAccountName: bk.Spec.ServiceAccountName,
+					SecurityContext:    bk.PodSecurityContextForBookie(),
+					NodeSelector:       bk.Spec.NodeSelector,
+					Tolerations:        bk.Spec.Tolerations,
+					PriorityClassName:  bk.Spec.PriorityClassName,
+					DNSConfig:          bk.Spec.DNSConfig,
+				},
+			},
+		},
+	}
+	addZookeeperClientVolumes(bk, &job.Spec.Template.Spec)
+	return job
+}
+
+// MakeBookieRepairJob returns the Job formatting the journal, ledger and index
+// directories of the given bookie pod. It runs on the node of the bookie, with
+// the volumes and configuration of the bookie, so that it can mount the same
+// volumes while the bookie is crash looping.
+func MakeBookieRepairJob(bk *v1alpha1.BookkeeperCluster, pod *corev1.Pod) *batchv1.Job {
+	backoffLimit := int32(2)
+	podSpec := pod.Spec.DeepCopy()
+	makeBookieFormatPodSpec(podSpec)
+
+	suspend := true
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        util.RepairJobNameForBookie(pod.Name),
+			Namespace:   bk.Namespace,
+			Labels:      labelsForBookieJob(bk, "bookie-repair"),
+			Annotations: map[string]string{RepairPodUIDAnnotation: string(pod.UID)},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			// resumed once the failed pod is gone
+			Suspend: &suspend,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labelsForBookieJob(bk, "bookie-repair"),
+				},
+				Spec: *podSpec,
+			},
+		},
+	}
+}
+
+// MakeBookieWipeJob returns the Job formatting the node local volumes of the
+// given replaced bookie. The volumes are mounted through the wipe claims, and
+// their node affinity runs the Job on the node holding the old data.
+func MakeBookieWipeJob(bk *v1alpha1.BookkeeperCluster, podName string) *batchv1.Job {
+	backoffLimit := int32(2)
+	podSpec := makeBookiePodSpec(bk)
+	for _, pvc := range makeBookieVolumeClaimTemplates(bk) {
+		podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+			Name: pvc.Name,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{
+					ClaimName: util.WipeClaimNameForBookie(pvc.Name, podName),
+				},
+			},
+		})
+	}
+	makeBookieFormatPodSpec(podSpec)
+
+	return &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			Kind:       "Job",
+			APIVersion: "batch/v1",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      util.WipeJobNameForBookie(podName),
+			Namespace: bk.Namespace,
+			Labels:    labelsForBookieJob(bk, "bookie-wipe"),
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit: &backoffLimit,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labelsForBookieJob(bk, "bookie-wipe"),
+				},
+				Spec: *podSpec,
+			},
+		},
+	}
+}
+
+// makeBookieFormatPodSpec turns the given bookie pod spec into the spec of a
+// pod formatting the journal, ledger and index directories of the bookie
+func makeBookieFormatPodSpec(podSpec *corev1.PodSpec) {
+	bookie := podSpec.Containers[0]
+	bookie.Name = "bookie-format"
+	bookie.Command = nil
+	bookie.Args = util.FormatBookieCommand()
+	bookie.Ports = nil
+	bookie.ReadinessProbe = nil
+	bookie.LivenessProbe = nil
+	bookie.StartupProbe = nil
+	bookie.Lifecycle = nil
+	podSpec.Containers = []corev1.Container{bookie}
+	podSpec.RestartPolicy = corev1.RestartPolicyNever
+	// the pod hostname and subdomain are set by the StatefulSet controller
+	podSpec.Hostname = ""
+	podSpec.Subdomain = ""
+	podSpec.Affinity = nil
+	podSpec.TopologySpreadConstraints = nil
+}
+
+// labelsForBookieJob returns the labels of the Jobs run by the operator. They
+// leave out the app label, so that Job pods are not taken for bookies.
+func labelsForBookieJob(bk *v1alpha1.BookkeeperCluster, component string) map[string]string {
+	return map[string]string{
+		"bookkeeper_cluster": bk.Name,
+		"component":          component,
+	}
+}
+
+// MakeBookieServiceMonitor returns the prometheus-operator ServiceMonitor
+// scraping the metrics port of the bookie headless service
+func MakeBookieServiceMonitor(bk *v1alpha1.BookkeeperCluster) *unstructured.Unstructured {
+	selector := bk.LabelsForBookkeeperCluster()
+	selector["component"] = "bookie"
+	endpoint := map[string]interface{}{
+		"port": "metrics",
+		"path": "/metrics",
+	}
+	if bk.Spec.Metrics.ServiceMonitor.Interval != "" {
+		endpoint["interval"] = bk.Spec.Metrics.ServiceMonitor.Interval
+	}
+
+	sm := &unstructured.Unstructured{}
+	sm.SetGroupVersionKind(ServiceMonitorGVK)
+	sm.SetName(util.StatefulSetNameForBookie(bk.Name))
+	sm.SetNamespace(bk.Namespace)
+	sm.SetLabels(monitoringLabels(bk, bk.Spec.Metrics.ServiceMonitor.Labels))
+	sm.Object["spec"] = map[string]interface{}{
+		"selector": map[string]interface{}{
+			"matchLabels": toInterfaceMap(selector),
+		},
+		"namespaceSelector": map[string]interface{}{
+			"matchNames": []interface{}{bk.Namespace},
+		},
+		"endpoints": []interface{}{endpoint},
+	}
+	return sm
+}
+
+// MakeBookiePrometheusRule returns the prometheus-operator PrometheusRule
+// holding the alerts for the bookies of the cluster
+func MakeBookiePrometheusRule(bk *v1alpha1.BookkeeperCluster) *unstructured.Unstructured {
+	target := fmt.Sprintf(`namespace="%s",service="%s"`, bk.Namespace, bk.HeadlessServiceNameForBookie())
+	rules := []interface{}{
+		bookieAlert("BookieDown",
+			fmt.Sprintf("up{%s} == 0", target), "5m", "critical",
+			"Bookie {{ $labels.pod }} is down"),
+		bookieAlert("BookieReadOnly",
+			fmt.Sprintf("bookie_SERVER_STATUS{%s} == 0", target), "5m", "warning",
+			"Bookie {{ $labels.pod }} is in read-only mode"),
+		bookieAlert("BookieNoWritableLedgerDirs",
+			fmt.Sprintf("bookie_ledger_writable_dirs{%s} == 0", target), "5m", "critical",
+			"Bookie {{ $labels.pod }} has no writable ledger directory left"),
+		bookieAlert("BookieJournalSyncLatencyHigh",
+			fmt.Sprintf(`bookie_journal_JOURNAL_SYNC{%s,success="true",quantile="0.99"} > 100`, target), "10m", "warning",
+			"Bookie {{ $labels.pod }} p99 journal sync latency is above 100ms"),
+	}
+
+	pr := &unstructured.Unstructured{}
+	pr.SetGroupVersionKind(PrometheusRuleGVK)
+	pr.SetName(util.StatefulSetNameForBookie(bk.Name))
+	pr.SetNamespace(bk.Namespace)
+	pr.SetLabels(monitoringLabels(bk, bk.Spec.Metrics.PrometheusRule.Labels))
+	pr.Object["spec"] = map[string]interface{}{
+		"groups": []interface{}{
+			map[string]interface{}{
+				"name":  fmt.Sprintf("%s.bookkeeper.rules", bk.Name),
+				"rules": rules,
+			},
+		},
+	}
+	return pr
+}
+
+func bookieAlert(name, expr, duration, severity, summary string) map[string]interface{} {
+	return map[string]interface{}{
+		"alert": name,
+		"expr":  expr,
+		"for":   duration,
+		"labels": map[string]interface{}{
+			"severity": severity,
+		},
+		"annotations": map[string]interface{}{
+			"summary": summary,
+		},
+	}
+}
+
+func monitoringLabels(bk *v1alpha1.BookkeeperCluster, extra map[string]string) map[string]string {
+	labels := bk.LabelsForBookkeeperCluster()
+	for k, v := range extra {
+		labels[k] = v
+	}
+	return labels
+}
+
+func toInterfaceMap(m map[string]string) map[string]interface{} {
+	out := make(map[string]interface{}, len(m))
+	for k, v := range m {
+		out[k] = v
+	}
+	return out
+}
@@ -21,6 +21,7 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -320,7 +321,473 @@ var _ = Describe("Bookie", func() {
 					Ω(lp_f).Should(Equal(int32(4)))
 					Ω(lp_s).Should(Equal(int32(1)))
 					Ω(lp_t).Should(Equal(int32(5)))
+					Ω(bk.Spec.Probes.StartupProbe.PeriodSeconds).Should(Equal(int32(10)))
+					Ω(bk.Spec.Probes.StartupProbe.FailureThreshold).Should(Equal(int32(60)))
 				})
+				It("should use exec probes by default", func() {
+					podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+					container := podTemplate.Spec.Containers[0]
+					Ω(container.ReadinessProbe.Exec).ShouldNot(BeNil())
+					Ω(container.LivenessProbe.Exec.Command).Should(Equal(util.HealthcheckCommand(3181)))
+					Ω(container.StartupProbe.Exec.Command).Should(Equal(util.HealthcheckCommand(3181)))
+				})
+				It("should not change the pod template of existing clusters", func() {
+					existing := &v1alpha1.BookkeeperCluster{}
+					existing.Status.CurrentVersion = "0.11.0"
+					existing.WithDefaults()
+					Ω(existing.Spec.Probes.StartupProbe).Should(BeNil())
+					Ω(existing.Spec.TerminationGracePeriodSeconds).Should(BeNil())
+				})
+			})
+		})
+
+		Context("User is hardening the pod security", func() {
+			var podTemplate corev1.PodTemplateSpec
+			BeforeEach(func() {
+				nonRoot := true
+				readOnly := true
+				noEscalation := false
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					PodSecurityContext: &corev1.PodSecurityContext{
+						RunAsNonRoot: &nonRoot,
+						SeccompProfile: &corev1.SeccompProfile{
+							Type: corev1.SeccompProfileTypeRuntimeDefault,
+						},
+					},
+					ContainerSecurityContext: &corev1.SecurityContext{
+						ReadOnlyRootFilesystem:   &readOnly,
+						AllowPrivilegeEscalation: &noEscalation,
+						Capabilities: &corev1.Capabilities{
+							Drop: []corev1.Capability{"ALL"},
+						},
+					},
+				}
+				bk.WithDefaults()
+				podTemplate = bookkeepercluster.MakeBookiePodTemplate(bk)
+			})
+			It("should use the given security contexts", func() {
+				Ω(*podTemplate.Spec.SecurityContext.RunAsNonRoot).Should(BeTrue())
+				Ω(podTemplate.Spec.SecurityContext.RunAsUser).Should(BeNil())
+				Ω(podTemplate.Spec.SecurityContext.SeccompProfile.Type).Should(Equal(corev1.SeccompProfileTypeRuntimeDefault))
+				Ω(*podTemplate.Spec.Containers[0].SecurityContext.ReadOnlyRootFilesystem).Should(BeTrue())
+			})
+			It("should mount writable directories for a read-only root filesystem", func() {
+				mounts := map[string]string{}
+				for _, m := range podTemplate.Spec.Containers[0].VolumeMounts {
+					mounts[m.Name] = m.MountPath
+				}
+				Ω(mounts).Should(HaveKeyWithValue("conf", "/opt/bookkeeper/conf"))
+				Ω(mounts).Should(HaveKeyWithValue("logs", "/opt/bookkeeper/logs"))
+				Ω(mounts).Should(HaveKeyWithValue("tmp", "/tmp"))
+			})
+			It("should copy the image configuration before starting the bookie", func() {
+				Ω(podTemplate.Spec.InitContainers).Should(HaveLen(1))
+				Ω(podTemplate.Spec.InitContainers[0].Name).Should(Equal("copy-conf"))
+				Ω(*podTemplate.Spec.InitContainers[0].SecurityContext.ReadOnlyRootFilesystem).Should(BeTrue())
+			})
+		})
+
+		Context("User is overriding the pod template", func() {
+			var podTemplate corev1.PodTemplateSpec
+			BeforeEach(func() {
+				grace := int64(300)
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					NodeSelector:      map[string]string{"disktype": "nvme"},
+					PriorityClassName: "bookkeeper-critical",
+					TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+						{
+							MaxSkew:           1,
+							TopologyKey:       "topology.kubernetes.io/zone",
+							WhenUnsatisfiable: corev1.DoNotSchedule,
+						},
+					},
+					TerminationGracePeriodSeconds: &grace,
+					DNSConfig:                     &corev1.PodDNSConfig{Searches: []string{"example.com"}},
+					HostNetwork:                   true,
+					Sidecars: []corev1.Container{
+						{Name: "fluent-bit", Image: "fluent/fluent-bit"},
+					},
+					Env: []corev1.EnvVar{
+						{Name: "BK_numAddWorkerThreads", Value: "4"},
+					},
+				}
+				bk.WithDefaults()
+				podTemplate = bookkeepercluster.MakeBookiePodTemplate(bk)
+			})
+			It("should set the scheduling fields", func() {
+				Ω(podTemplate.Spec.NodeSelector).Should(HaveKeyWithValue("disktype", "nvme"))
+				Ω(podTemplate.Spec.PriorityClassName).Should(Equal("bookkeeper-critical"))
+				Ω(podTemplate.Spec.TopologySpreadConstraints).Should(HaveLen(1))
+				Ω(*podTemplate.Spec.TerminationGracePeriodSeconds).Should(Equal(int64(300)))
+			})
+			It("should use the host network with cluster dns", func() {
+				Ω(podTemplate.Spec.HostNetwork).Should(BeTrue())
+				Ω(podTemplate.Spec.DNSPolicy).Should(Equal(corev1.DNSClusterFirstWithHostNet))
+				Ω(podTemplate.Spec.DNSConfig.Searches).Should(Equal([]string{"example.com"}))
+			})
+			It("should add the sidecars after the bookie container", func() {
+				Ω(podTemplate.Spec.Containers).Should(HaveLen(2))
+				Ω(podTemplate.Spec.Containers[0].Name).Should(Equal("bookie"))
+				Ω(podTemplate.Spec.Containers[1].Name).Should(Equal("fluent-bit"))
+			})
+			It("should add the extra env to the bookie container", func() {
+				Ω(podTemplate.Spec.Containers[0].Env).Should(Equal(bk.Spec.Env))
+			})
+		})
+
+		Context("User is setting secret options", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					SecretOptions: map[string]corev1.SecretKeySelector{
+						"tlsKeyStorePassword": {LocalObjectReference: corev1.LocalObjectReference{Name: "tls"}, Key: "password"},
+					},
+					Env: []corev1.EnvVar{
+						{Name: "BK_numAddWorkerThreads", Value: "4"},
+					},
+				}
+				bk.WithDefaults()
+			})
+			It("should read the secret options from their secrets", func() {
+				env := bookkeepercluster.MakeBookiePodTemplate(bk).Spec.Containers[0].Env
+				Ω(env).Should(HaveLen(2))
+				Ω(env[0].Name).Should(Equal("BK_tlsKeyStorePassword"))
+				Ω(env[0].ValueFrom.SecretKeyRef.Name).Should(Equal("tls"))
+				Ω(env[0].ValueFrom.SecretKeyRef.Key).Should(Equal("password"))
+				Ω(env[1].Name).Should(Equal("BK_numAddWorkerThreads"))
+			})
+			It("should not store the secret options in the configmap", func() {
+				cm := bookkeepercluster.MakeBookieConfigMap(bk)
+				Ω(cm.Data).ShouldNot(HaveKey("BK_tlsKeyStorePassword"))
+			})
+			It("should pass the secret options to the metadata init job", func() {
+				job := bookkeepercluster.MakeBookieMetadataInitJob(bk)
+				Ω(job.Spec.Template.Spec.Containers[0].Env[0].Name).Should(Equal("BK_tlsKeyStorePassword"))
+			})
+		})
+
+		Context("User is enabling rack awareness", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					RackAware: &v1alpha1.RackAwareSpec{Enabled: true},
+				}
+				bk.WithDefaults()
+			})
+			It("should use the rack aware placement policy", func() {
+				cm := bookkeepercluster.MakeBookieConfigMap(bk)
+				Ω(cm.Data["BK_ensemblePlacementPolicy"]).Should(Equal("org.apache.bookkeeper.client.RackawareEnsemblePlacementPolicy"))
+				Ω(cm.Data["BK_networkTopologyScriptFileName"]).Should(Equal(bookkeepercluster.RackScriptPath))
+			})
+			It("should spread the bookies across zones", func() {
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				constraints := podTemplate.Spec.TopologySpreadConstraints
+				Ω(constraints).Should(HaveLen(1))
+				Ω(constraints[0].TopologyKey).Should(Equal(bookkeepercluster.ZoneLabel))
+				Ω(constraints[0].WhenUnsatisfiable).Should(Equal(corev1.DoNotSchedule))
+			})
+			It("should map scheduled bookies to the zone of their node", func() {
+				pods := []corev1.Pod{
+					{
+						ObjectMeta: metav1.ObjectMeta{Name: "default-bookie-0"},
+						Spec:       corev1.PodSpec{NodeName: "node-a"},
+						Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+					},
+					{
+						ObjectMeta: metav1.ObjectMeta{Name: "default-bookie-1"},
+					},
+				}
+				cm := bookkeepercluster.MakeBookieRackConfigMap(bk, pods, map[string]string{"node-a": "us-east-1a"})
+				Ω(cm.Data["racks"]).Should(Equal("default-bookie-0.default-bookie-headless..svc.cluster.local 10.0.0.1 /us-east-1a"))
+				Ω(cm.Data["topology.sh"]).Should(ContainSubstring("/default-rack"))
+			})
+			It("should map bookies by their advertised address", func() {
+				bk.Spec.ExternalAccess = &v1alpha1.ExternalAccess{Enabled: true, DomainName: "bookies.example.com"}
+				pods := []corev1.Pod{
+					{
+						ObjectMeta: metav1.ObjectMeta{Name: "default-bookie-0"},
+						Spec:       corev1.PodSpec{NodeName: "node-a"},
+						Status:     corev1.PodStatus{PodIP: "10.0.0.1"},
+					},
+				}
+				cm := bookkeepercluster.MakeBookieRackConfigMap(bk, pods, map[string]string{"node-a": "us-east-1a"})
+				Ω(cm.Data["racks"]).Should(Equal("default-bookie-0.bookies.example.com 10.0.0.1 /us-east-1a"))
+			})
+			It("should only schedule the bookies in the given zones", func() {
+				bk.Spec.RackAware.Zones = []string{"us-east-1a", "us-east-1b"}
+				bk.Spec.Affinity = &corev1.Affinity{
+					NodeAffinity: &corev1.NodeAffinity{
+						RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+							NodeSelectorTerms: []corev1.NodeSelectorTerm{
+								{MatchExpressions: []corev1.NodeSelectorRequirement{{Key: "disktype", Operator: corev1.NodeSelectorOpIn, Values: []string{"nvme"}}}},
+							},
+						},
+					},
+				}
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				terms := podTemplate.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms
+				Ω(terms).Should(HaveLen(1))
+				Ω(terms[0].MatchExpressions).Should(HaveLen(2))
+				Ω(terms[0].MatchExpressions[1].Key).Should(Equal(bookkeepercluster.ZoneLabel))
+				Ω(terms[0].MatchExpressions[1].Values).Should(Equal([]string{"us-east-1a", "us-east-1b"}))
+				Ω(bk.Spec.Affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms[0].MatchExpressions).Should(HaveLen(1))
+			})
+		})
+
+		Context("User is configuring graceful shutdown", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					HTTPServer: &v1alpha1.HTTPServerSpec{Enabled: true},
+				}
+			})
+			It("should switch the bookie to read-only before stopping", func() {
+				bk.WithDefaults()
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				preStop := podTemplate.Spec.Containers[0].Lifecycle.PreStop
+				Ω(preStop.Exec.Command).Should(Equal(util.PreStopCommand(8080, 15)))
+				Ω(*podTemplate.Spec.TerminationGracePeriodSeconds).Should(Equal(int64(60)))
+			})
+			It("should not add the hook once disabled", func() {
+				disabled := false
+				bk.Spec.GracefulShutdown = &v1alpha1.GracefulShutdownSpec{Enabled: &disabled}
+				bk.WithDefaults()
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				Ω(podTemplate.Spec.Containers[0].Lifecycle).Should(BeNil())
+			})
+			It("should not add the hook without the http server", func() {
+				bk.Spec.HTTPServer = nil
+				bk.WithDefaults()
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				Ω(podTemplate.Spec.Containers[0].Lifecycle).Should(BeNil())
+			})
+		})
+
+		Context("User is selecting the probe mode", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					Probes: &v1alpha1.Probes{},
+				}
+			})
+			It("should query the admin endpoint in http mode", func() {
+				bk.Spec.Probes.Mode = v1alpha1.ProbeModeHTTP
+				bk.Spec.HTTPServer = &v1alpha1.HTTPServerSpec{Enabled: true, Port: 8081}
+				bk.WithDefaults()
+				container := bookkeepercluster.MakeBookiePodTemplate(bk).Spec.Containers[0]
+				Ω(container.ReadinessProbe.HTTPGet.Path).Should(Equal("/api/v1/bookie/is_ready"))
+				Ω(container.ReadinessProbe.HTTPGet.Port.IntValue()).Should(Equal(8081))
+				Ω(container.LivenessProbe.HTTPGet.Path).Should(Equal("/heartbeat"))
+				Ω(container.StartupProbe.HTTPGet.Path).Should(Equal("/heartbeat"))
+			})
+			It("should fall back to exec probes without the http server", func() {
+				bk.Spec.Probes.Mode = v1alpha1.ProbeModeHTTP
+				bk.WithDefaults()
+				container := bookkeepercluster.MakeBookiePodTemplate(bk).Spec.Containers[0]
+				Ω(container.ReadinessProbe.Exec).ShouldNot(BeNil())
+			})
+			It("should connect to the bookie port in tcp mode", func() {
+				bk.Spec.Probes.Mode = v1alpha1.ProbeModeTCP
+				bk.WithDefaults()
+				container := bookkeepercluster.MakeBookiePodTemplate(bk).Spec.Containers[0]
+				Ω(container.ReadinessProbe.TCPSocket.Port.IntValue()).Should(Equal(3181))
+				Ω(container.LivenessProbe.TCPSocket.Port.IntValue()).Should(Equal(3181))
+			})
+		})
+
+		Context("User is specifying node local storage", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					Replicas: 2,
+					Storage: &v1alpha1.BookkeeperStorageSpec{
+						Local: &v1alpha1.LocalStorageSpec{
+							Nodes:       []string{"node-a", "node-b", "node-c"},
+							LedgerPath:  "/mnt/nvme0/ledgers",
+							JournalPath: "/mnt/nvme1/journal",
+							IndexPath:   "/mnt/nvme0/index",
+						},
+					},
+				}
+			})
+			Context("Local mode", func() {
+				BeforeEach(func() {
+					bk.Spec.Storage.Mode = v1alpha1.StorageModeLocal
+					bk.WithDefaults()
+				})
+				It("should set the local storage class on the volume claim templates", func() {
+					sts := bookkeepercluster.MakeBookieStatefulSet(bk)
+					Ω(sts.Spec.VolumeClaimTemplates).Should(HaveLen(3))
+					for _, pvc := range sts.Spec.VolumeClaimTemplates {
+						Ω(*pvc.Spec.StorageClassName).Should(Equal(v1alpha1.DefaultLocalStorageClassName))
+					}
+				})
+				It("should create a local volume per bookie and disk", func() {
+					pvs := bookkeepercluster.MakeBookieLocalPersistentVolumes(bk)
+					Ω(pvs).Should(HaveLen(6))
+				})
+				It("should pin each volume to the node of its bookie ordinal", func() {
+					pvs := bookkeepercluster.MakeBookieLocalPersistentVolumes(bk)
+					for _, pv := range pvs {
+						if pv.Spec.ClaimRef.Name == "ledger-default-bookie-1" {
+							Ω(pv.Spec.Local.Path).Should(Equal("/mnt/nvme0/ledgers"))
+							terms := pv.Spec.NodeAffinity.Required.NodeSelectorTerms
+							Ω(terms[0].MatchExpressions[0].Values).Should(Equal([]string{"node-b"}))
+							return
+						}
+					}
+					Fail("no volume bound to ledger-default-bookie-1")
+				})
+			})
+			Context("HostPath mode", func() {
+				BeforeEach(func() {
+					bk.Spec.Storage.Mode = v1alpha1.StorageModeHostPath
+					bk.WithDefaults()
+				})
+				It("should set the local storage class on the volume claim templates", func() {
+					sts := bookkeepercluster.MakeBookieStatefulSet(bk)
+					Ω(sts.Spec.VolumeClaimTemplates).Should(HaveLen(3))
+					for _, pvc := range sts.Spec.VolumeClaimTemplates {
+						Ω(*pvc.Spec.StorageClassName).Should(Equal(v1alpha1.DefaultLocalStorageClassName))
+					}
+				})
+				It("should not mount hostPath volumes in the pods", func() {
+					podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+					for _, v := range podTemplate.Spec.Volumes {
+						Ω(v.HostPath).Should(BeNil())
+					}
+				})
+				It("should pin each node directory to the node of its bookie ordinal", func() {
+					pvs := bookkeepercluster.MakeBookieLocalPersistentVolumes(bk)
+					Ω(pvs).Should(HaveLen(6))
+					for _, pv := range pvs {
+						if pv.Spec.ClaimRef.Name == "journal-default-bookie-1" {
+							Ω(pv.Spec.Local).Should(BeNil())
+							Ω(pv.Spec.HostPath.Path).Should(Equal("/mnt/nvme1/journal"))
+							terms := pv.Spec.NodeAffinity.Required.NodeSelectorTerms
+							Ω(terms[0].MatchExpressions[0].Values).Should(Equal([]string{"node-b"}))
+							return
+						}
+					}
+					Fail("no volume bound to journal-default-bookie-1")
+				})
+			})
+		})
+
+		Context("User is enabling external access", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					ExternalAccess: &v1alpha1.ExternalAccess{
+						Enabled: true,
+						Type:    corev1.ServiceTypeClusterIP,
+						Annotations: map[string]string{
+							"external-dns.alpha.kubernetes.io/hostname": "$(POD_NAME).bookies.example.com",
+						},
+					},
+				}
+				bk.WithDefaults()
+			})
+			It("should create a service per bookie", func() {
+				svc := bookkeepercluster.MakeBookieExternalService(bk, 1)
+				Ω(svc.Name).Should(Equal("default-bookie-1"))
+				Ω(svc.Spec.Type).Should(Equal(corev1.ServiceTypeClusterIP))
+				Ω(svc.Spec.Selector).Should(Equal(map[string]string{"statefulset.kubernetes.io/pod-name": "default-bookie-1"}))
+				Ω(svc.Annotations).Should(HaveKeyWithValue("external-dns.alpha.kubernetes.io/hostname", "default-bookie-1.bookies.example.com"))
+			})
+			It("should advertise the in-cluster service address", func() {
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				env := podTemplate.Spec.Containers[0].Env
+				Ω(env).Should(HaveLen(3))
+				Ω(env[2].Name).Should(Equal("BK_advertisedAddress"))
+				Ω(env[2].Value).Should(Equal("$(POD_NAME).$(POD_NAMESPACE).svc.cluster.local"))
+			})
+			It("should advertise the external domain name", func() {
+				bk.Spec.ExternalAccess.DomainName = "bookies.example.com"
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				env := podTemplate.Spec.Containers[0].Env
+				Ω(env[2].Value).Should(Equal("$(POD_NAME).bookies.example.com"))
+			})
+		})
+
+		Context("User is enabling the http server and metrics", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					HTTPServer: &v1alpha1.HTTPServerSpec{Enabled: true},
+					Metrics: &v1alpha1.MetricsSpec{
+						Enabled:        true,
+						PrometheusRule: &v1alpha1.PrometheusRuleSpec{Enabled: true},
+					},
+				}
+				bk.WithDefaults()
+			})
+			It("should enable them in the config-map", func() {
+				cm := bookkeepercluster.MakeBookieConfigMap(bk)
+				Ω(cm.Data["BK_httpServerEnabled"]).Should(Equal("true"))
+				Ω(cm.Data["BK_httpServerPort"]).Should(Equal("8080"))
+				Ω(cm.Data["BK_enableStatistics"]).Should(Equal("true"))
+				Ω(cm.Data["BK_prometheusStatsHttpPort"]).Should(Equal("8000"))
+			})
+			It("should expose the ports on the container and the headless service", func() {
+				podTemplate := bookkeepercluster.MakeBookiePodTemplate(bk)
+				Ω(podTemplate.Spec.Containers[0].Ports).Should(HaveLen(3))
+				svc := bookkeepercluster.MakeBookieHeadlessService(bk)
+				Ω(svc.Spec.Ports).Should(HaveLen(3))
+				Ω(svc.Spec.Ports[2].Name).Should(Equal("metrics"))
+				Ω(svc.Spec.Ports[2].Port).Should(Equal(int32(8000)))
+			})
+			It("should create a service monitor scraping the metrics port", func() {
+				sm := bookkeepercluster.MakeBookieServiceMonitor(bk)
+				Ω(sm.GetKind()).Should(Equal("ServiceMonitor"))
+				Ω(sm.GetName()).Should(Equal("default-bookie"))
+				endpoints, _, _ := unstructured.NestedSlice(sm.Object, "spec", "endpoints")
+				Ω(endpoints[0].(map[string]interface{})["port"]).Should(Equal("metrics"))
+			})
+			It("should create a prometheus rule with bookie alerts", func() {
+				pr := bookkeepercluster.MakeBookiePrometheusRule(bk)
+				groups, _, _ := unstructured.NestedSlice(pr.Object, "spec", "groups")
+				Ω(groups[0].(map[string]interface{})["rules"]).Should(HaveLen(4))
+			})
+		})
+		Context("Metadata init job", func() {
+			BeforeEach(func() {
+				bk.Spec = v1alpha1.BookkeeperClusterSpec{
+					ServiceAccountName: "bk-sa",
+				}
+				bk.WithDefaults()
+			})
+			It("should run the bookie image once with the bookie configuration", func() {
+				job := bookkeepercluster.MakeBookieMetadataInitJob(bk)
+				Ω(job.Name).Should(Equal("default-bookie-metadata-init"))
+				podSpec := job.Spec.Template.Spec
+				Ω(podSpec.RestartPolicy).Should(Equal(corev1.RestartPolicyNever))
+				Ω(podSpec.ServiceAccountName).Should(Equal("bk-sa"))
+				Ω(podSpec.Containers[0].Image).Should(Equal(bk.BookkeeperImage()))
+				Ω(podSpec.Containers[0].EnvFrom[0].ConfigMapRef.Name).Should(Equal(util.ConfigMapNameForBookie(bk.Name)))
+				Ω(strings.Join(podSpec.Containers[0].Args, " ")).Should(ContainSubstring("whatisinstanceid"))
+			})
+		})
+		Context("Bookie repair job", func() {
+			var pod *corev1.Pod
+			BeforeEach(func() {
+				bk.WithDefaults()
+				template := bookkeepercluster.MakeBookiePodTemplate(bk)
+				pod = &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: "default-bookie-0", Namespace: bk.Namespace},
+					Spec:       template.Spec,
+				}
+				pod.Spec.NodeName = "node-1"
+				pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+					Name: "journal",
+					VolumeSource: corev1.VolumeSource{
+						PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "journal-default-bookie-0"},
+					},
+				})
+			})
+			It("should format the bookie volumes on the bookie node", func() {
+				job := bookkeepercluster.MakeBookieRepairJob(bk, pod)
+				Ω(job.Name).Should(Equal("default-bookie-0-repair"))
+				podSpec := job.Spec.Template.Spec
+				Ω(podSpec.NodeName).Should(Equal("node-1"))
+				Ω(podSpec.Containers).Should(HaveLen(1))
+				Ω(podSpec.Containers[0].ReadinessProbe).Should(BeNil())
+				Ω(strings.Join(podSpec.Containers[0].Args, " ")).Should(ContainSubstring("bookieformat"))
+				Ω(podSpec.Volumes).Should(ContainElement(pod.Spec.Volumes[len(pod.Spec.Volumes)-1]))
+				Ω(job.Spec.Template.Labels).ShouldNot(HaveKey("app"))
 			})
 		})
 	})
@@ -0,0 +1,231 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/backup"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+)
+
+var _ reconcile.Reconciler = &BookkeeperBackupReconciler{}
+
+// BookkeeperBackupReconciler reconciles a BookkeeperBackup object
+type BookkeeperBackupReconciler struct {
+	Client   client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// OperatorImage is the image of the operator, run by the backup Jobs
+	OperatorImage string
+}
+
+//+kubebuilder:rbac:groups=bookkeeper.pravega.io,resources=bookkeeperbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=bookkeeper.pravega.io,resources=bookkeeperbackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=cronjobs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile takes a single snapshot of the metadata of the cluster with a Job,
+// or schedules the snapshots with a CronJob
+func (r *BookkeeperBackupReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
+	ctx = log.IntoContext(ctx, log.FromContext(ctx, "reconcileID", uuid.NewUUID()))
+	log.FromContext(ctx).Info("Reconciling BookkeeperBackup")
+
+	b := &bookkeeperv1alpha1.BookkeeperBackup{}
+	err := r.Client.Get(ctx, request.NamespacedName, b)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// the Jobs and the CronJob are garbage collected with the backup
+			return reconcile.Result{}, nil
+		}
+		return reconcile.Result{}, err
+	}
+
+	err = r.run(ctx, b)
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to reconcile bookkeeper backup")
+		return reconcile.Result{}, err
+	}
+	return reconcile.Result{}, nil
+}
+
+func (r *BookkeeperBackupReconciler) run(ctx context.Context, b *bookkeeperv1alpha1.BookkeeperBackup) error {
+	if b.Spec.Schedule == "" && (b.Status.Phase == bookkeeperv1alpha1.BookkeeperBackupCompleted ||
+		b.Status.Phase == bookkeeperv1alpha1.BookkeeperBackupFailed) {
+		return nil
+	}
+	if err := validateBackupStorage(&b.Spec.Storage); err != nil {
+		return r.setBackupFailed(ctx, b, err.Error())
+	}
+	if r.OperatorImage == "" {
+		return r.setBackupFailed(ctx, b, "the operator image is not known, set the --operator-image flag of the operator")
+	}
+
+	bk := &bookkeeperv1alpha1.BookkeeperCluster{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: b.Spec.ClusterName, Namespace: b.Namespace}, bk)
+	if err != nil {
+		return fmt.Errorf("failed to get bookkeeper cluster (%s): %v", b.Spec.ClusterName, err)
+	}
+	bk.WithDefaults()
+	ledgersRoot, err := ledgersRootPath(ctx, r.Client, bk)
+	if err != nil {
+		return err
+	}
+
+	if b.Spec.Schedule != "" {
+		return r.reconcileSchedule(ctx, b, bk, ledgersRoot)
+	}
+	err = r.deleteCronJob(ctx, b)
+	if err != nil {
+		return err
+	}
+	return r.reconcileSingleBackup(ctx, b, bk, ledgersRoot)
+}
+
+// reconcileSchedule applies the CronJob of the backup and reports its last
+// runs in the status
+func (r *BookkeeperBackupReconciler) reconcileSchedule(ctx context.Context, b *bookkeeperv1alpha1.BookkeeperBackup, bk *bookkeeperv1alpha1.BookkeeperCluster, ledgersRoot string) error {
+	cronJob := MakeBackupCronJob(b, bk, r.OperatorImage, ledgersRoot)
+	controllerutil.SetControllerReference(b, cronJob, r.Scheme)
+	err := applyObject(ctx, r.Client, r.Scheme, cronJob)
+	if err != nil {
+		return fmt.Errorf("failed to apply cronjob (%s): %v", cronJob.Name, err)
+	}
+
+	status := b.Status.DeepCopy()
+	status.Phase = bookkeeperv1alpha1.BookkeeperBackupScheduled
+	status.Message = ""
+	status.LastScheduleTime = cronJob.Status.LastScheduleTime
+	status.LastSuccessfulTime = cronJob.Status.LastSuccessfulTime
+	if status.LastSuccessfulTime != nil && !status.LastSuccessfulTime.Equal(b.Status.LastSuccessfulTime) {
+		r.Recorder.Eventf(b, corev1.EventTypeNormal, EventReasonBackupCompleted, "Scheduled backup of cluster %s completed", bk.Name)
+	}
+	if equalBackupStatus(status, &b.Status) {
+		return nil
+	}
+	b.Status = *status
+	return r.Client.Status().Update(ctx, b)
+}
+
+// reconcileSingleBackup names the snapshot, runs the backup Job and reports
+// its result
+func (r *BookkeeperBackupReconciler) reconcileSingleBackup(ctx context.Context, b *bookkeeperv1alpha1.BookkeeperBackup, bk *bookkeeperv1alpha1.BookkeeperCluster, ledgersRoot string) error {
+	if b.Status.Snapshot == "" {
+		// the snapshot name is recorded before the Job starts, so that it
+		// is not changed by a retry
+		b.Status.Snapshot = fmt.Sprintf("%s-%s", bk.Name, backup.SnapshotTimestamp(time.Now()))
+		b.Status.Phase = bookkeeperv1alpha1.BookkeeperBackupRunning
+		b.Status.Message = ""
+		err := r.Client.Status().Update(ctx, b)
+		if err != nil {
+			return fmt.Errorf("failed to record snapshot name: %v", err)
+		}
+		r.Recorder.Eventf(b, corev1.EventTypeNormal, EventReasonBackupStarted, "Backup of cluster %s to snapshot %s started", bk.Name, b.Status.Snapshot)
+	}
+
+	name := util.BackupJobName(b.Name)
+	job := &batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: b.Namespace}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get job (%s): %v", name, err)
+		}
+		job = MakeBackupJob(b, bk, r.OperatorImage, ledgersRoot, b.Status.Snapshot)
+		controllerutil.SetControllerReference(b, job, r.Scheme)
+		log.FromContext(ctx).Info("creating backup job", "job", name, "snapshot", b.Status.Snapshot)
+		err = r.Client.Create(ctx, job)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create job (%s): %v", name, err)
+		}
+		return nil
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return r.setBackupFailed(ctx, b, fmt.Sprintf("backup job (%s) failed: %s", name, c.Message))
+		}
+	}
+	if job.Status.Succeeded == 0 {
+		return nil
+	}
+	log.FromContext(ctx).Info("backup completed", "snapshot", b.Status.Snapshot)
+	r.Recorder.Eventf(b, corev1.EventTypeNormal, EventReasonBackupCompleted, "Backup of cluster %s to snapshot %s completed", bk.Name, b.Status.Snapshot)
+	now := metav1.Now()
+	b.Status.Phase = bookkeeperv1alpha1.BookkeeperBackupCompleted
+	b.Status.CompletionTime = &now
+	return r.Client.Status().Update(ctx, b)
+}
+
+// setBackupFailed records the failure of the backup. A failed single backup
+// is not retried, a new BookkeeperBackup has to be created.
+func (r *BookkeeperBackupReconciler) setBackupFailed(ctx context.Context, b *bookkeeperv1alpha1.BookkeeperBackup, message string) error {
+	if b.Status.Phase == bookkeeperv1alpha1.BookkeeperBackupFailed && b.Status.Message == message {
+		return nil
+	}
+	log.FromContext(ctx).Info("backup failed", "reason", message)
+	r.Recorder.Event(b, corev1.EventTypeWarning, EventReasonBackupFailed, message)
+	now := metav1.Now()
+	b.Status.Phase = bookkeeperv1alpha1.BookkeeperBackupFailed
+	b.Status.Message = message
+	b.Status.CompletionTime = &now
+	return r.Client.Status().Update(ctx, b)
+}
+
+// deleteCronJob deletes the CronJob left by a schedule that was removed
+func (r *BookkeeperBackupReconciler) deleteCronJob(ctx context.Context, b *bookkeeperv1alpha1.BookkeeperBackup) error {
+	cronJob := &batchv1.CronJob{}
+	name := util.BackupJobName(b.Name)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: b.Namespace}, cronJob)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get cronjob (%s): %v", name, err)
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = r.Client.Delete(ctx, cronJob, &client.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete cronjob (%s): %v", name, err)
+	}
+	return nil
+}
+
+func equalBackupStatus(a, b *bookkeeperv1alpha1.BookkeeperBackupStatus) bool {
+	return a.Phase == b.Phase && a.Message == b.Message &&
+		a.LastScheduleTime.Equal(b.LastScheduleTime) && a.LastSuccessfulTime.Equal(b.LastSuccessfulTime)
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *BookkeeperBackupReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&bookkeeperv1alpha1.BookkeeperBackup{}).
+		Owns(&batchv1.Job{}).
+		Owns(&batchv1.CronJob{}).
+		Complete(r)
+}
@@ -25,24 +25,32 @@ import (
 	"time"
 
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
-	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 
-	//	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
 	"github.com/pravega/bookkeeper-operator/pkg/controller/config"
 	"github.com/pravega/bookkeeper-operator/pkg/util"
-	log "github.com/sirupsen/logrus"
 )
 
 var _ reconcile.Reconciler = &BookkeeperClusterReconciler{}
@@ -52,8 +60,22 @@ const ReconcileTime = 30 * time.Second
 
 // BookkeeperClusterReconciler reconciles a BookkeeperCluster object
 type BookkeeperClusterReconciler struct {
-	Client client.Client
-	Scheme *runtime.Scheme
+	Client   client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+
+	// OperatorImage is the image of the operator, run by the metadata
+	// restore Jobs
+	OperatorImage string
+
+	// MaxConcurrentReconciles is the number of BookkeeperClusters reconciled
+	// concurrently, 1 when unset. The same cluster is never reconciled twice
+	// at the same time.
+	MaxConcurrentReconciles int
+
+	// Zookeeper runs the ZooKeeper operations, connecting to ZooKeeper when
+	// unset
+	Zookeeper Zookeeper
 }
 
 //+kubebuilder:rbac:groups=bookkeeper.pravega.io,resources=bookkeeperclusters,verbs=get;list;watch;create;update;patch;delete
@@ -71,106 +93,181 @@ type BookkeeperClusterReconciler struct {
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.11.0/pkg/reconcile
 
 func (r *BookkeeperClusterReconciler) Reconcile(ctx context.Context, request ctrl.Request) (ctrl.Result, error) {
-	//	_ = log.FromContext(ctx)
-
-	log.Printf("Reconciling BookkeeperCluster %s/%s\n", request.Namespace, request.Name)
+	// the controller already adds the name and namespace of the request
+	ctx = log.IntoContext(ctx, log.FromContext(ctx, "reconcileID", uuid.NewUUID()))
+	log.FromContext(ctx).Info("Reconciling BookkeeperCluster")
 
 	// Fetch the BookkeeperCluster instance
 	bookkeeperCluster := &bookkeeperv1alpha1.BookkeeperCluster{}
-	err := r.Client.Get(context.TODO(), request.NamespacedName, bookkeeperCluster)
+	err := r.Client.Get(ctx, request.NamespacedName, bookkeeperCluster)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
-			log.Printf("BookkeeperCluster %s/%s not found. Ignoring since object must be deleted\n", request.Namespace, request.Name)
+			log.FromContext(ctx).Info("BookkeeperCluster not found. Ignoring since object must be deleted")
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
-		log.Printf("failed to get BookkeeperCluster: %v", err)
+		log.FromContext(ctx).Error(err, "failed to get BookkeeperCluster")
 		return reconcile.Result{}, err
 	}
 
+	if bookkeeperCluster.Spec.Paused {
+		err = r.reconcilePaused(withPhase(ctx, "paused"), bookkeeperCluster)
+		if err != nil {
+			log.FromContext(ctx).Error(err, "failed to update status of paused bookkeeper cluster")
+			return reconcile.Result{}, err
+		}
+		return reconcile.Result{RequeueAfter: ReconcileTime}, nil
+	}
+
 	// Set default configuration for unspecified values
 	changed := bookkeeperCluster.WithDefaults()
 	if changed {
-		log.Printf("Setting default settings for bookkeeper-cluster: %s", request.Name)
-		if err = r.Client.Update(context.TODO(), bookkeeperCluster); err != nil {
+		log.FromContext(ctx).Info("Setting default settings for bookkeeper-cluster")
+		if err = r.Client.Update(ctx, bookkeeperCluster); err != nil {
 			return reconcile.Result{}, err
 		}
 		return reconcile.Result{Requeue: true}, nil
 	}
 
-	err = r.run(bookkeeperCluster)
+	err = r.run(ctx, bookkeeperCluster)
 	if err != nil {
-		log.Printf("failed to reconcile bookkeeper cluster (%s): %v", bookkeeperCluster.Name, err)
+		log.FromContext(ctx).Error(err, "failed to reconcile bookkeeper cluster")
 		return reconcile.Result{}, err
 	}
 
 	return reconcile.Result{RequeueAfter: ReconcileTime}, nil
 
 }
-func (r *BookkeeperClusterReconciler) run(p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) run(ctx context.Context, p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+	// the health of zookeeper is reported even when a phase fails, since it
+	// then tells a zookeeper outage apart from a failure of the operator
+	defer func() {
+		healthErr := r.reconcileZookeeperHealth(withPhase(ctx, "zookeeperHealth"), p)
+		if healthErr != nil && err == nil {
+			err = fmt.Errorf("failed to inspect zookeeper: %v", healthErr)
+		}
+	}()
+
+	err = r.reconcileResumed(withPhase(ctx, "paused"), p)
+	if err != nil {
+		return fmt.Errorf("failed to resume reconciliation: %v", err)
+	}
+
 	// Clean up zookeeper metadata
-	err = r.reconcileFinalizers(p)
+	err = r.reconcileFinalizers(withPhase(ctx, "finalizers"), p)
 	if err != nil {
 		return fmt.Errorf("failed to clean up zookeeper: %v", err)
 	}
 
-	err = r.reconcileConfigMap(p)
+	err = r.reconcileConfigMap(withPhase(ctx, "configMap"), p)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile configMap %v", err)
 	}
 
-	err = r.reconcilePdb(p)
+	err = r.reconcilePdb(withPhase(ctx, "pdb"), p)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile pdb %v", err)
 	}
 
-	err = r.reconcileService(p)
+	err = r.reconcileService(withPhase(ctx, "service"), p)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile service %v", err)
 	}
 
-	err = r.deployCluster(p)
+	err = r.reconcileMonitoring(withPhase(ctx, "monitoring"), p)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile monitoring %v", err)
+	}
+
+	err = r.reconcileLocalPersistentVolumes(withPhase(ctx, "localPersistentVolumes"), p)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile local persistent volumes %v", err)
+	}
+
+	err = r.reconcileRackConfigMap(withPhase(ctx, "rackConfigMap"), p)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile rack configMap %v", err)
+	}
+
+	reachable, err := r.reconcileZookeeperReachable(withPhase(ctx, "zookeeper"), p)
+	if err != nil {
+		return fmt.Errorf("failed to check zookeeper: %v", err)
+	}
+	if reachable {
+		initialized, err := r.reconcileMetadata(withPhase(ctx, "metadata"), p)
+		if err != nil {
+			return fmt.Errorf("failed to reconcile cluster metadata: %v", err)
+		}
+		if !initialized {
+			// the bookies are deployed once the metadata init job has completed
+			return nil
+		}
+	} else {
+		// the metadata init job would not reach zookeeper either, and only
+		// the bookies deployed before are reconciled
+		deployed, err := r.isBookieDeployed(ctx, p)
+		if err != nil || !deployed {
+			return err
+		}
+	}
+
+	err = r.deployCluster(withPhase(ctx, "deploy"), p)
 	if err != nil {
 		return fmt.Errorf("failed to deploy cluster: %v", err)
 	}
 
-	err = r.syncClusterSize(p)
+	err = r.syncClusterSize(withPhase(ctx, "scale"), p)
 	if err != nil {
 		return fmt.Errorf("failed to sync cluster size: %v", err)
 	}
 
+	err = r.reconcileBookieReplacement(withPhase(ctx, "replaceBookie"), p)
+	if err != nil {
+		return fmt.Errorf("failed to replace bookie: %v", err)
+	}
+
 	// Upgrade
-	err = r.syncClusterVersion(p)
+	err = r.syncClusterVersion(withPhase(ctx, "upgrade"), p)
 	if err != nil {
 		return fmt.Errorf("failed to sync cluster version: %v", err)
 	}
 
 	// Rollback
-	err = r.rollbackFailedUpgrade(p)
+	err = r.rollbackFailedUpgrade(withPhase(ctx, "rollback"), p)
 	if err != nil {
 		return fmt.Errorf("Rollback attempt failed: %v", err)
 	}
 
-	err = r.reconcileClusterStatus(p)
+	err = r.repairBookies(withPhase(ctx, "repair"), p)
+	if err != nil {
+		return fmt.Errorf("failed to repair bookies: %v", err)
+	}
+
+	err = r.reconcileClusterStatus(withPhase(ctx, "status"), p)
 	if err != nil {
 		return fmt.Errorf("failed to reconcile cluster status: %v", err)
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) deployCluster(p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
-	err = r.deployBookie(p)
+// withPhase adds the reconcile phase to the logger of the context
+func withPhase(ctx context.Context, phase string) context.Context {
+	return log.IntoContext(ctx, log.FromContext(ctx, "phase", phase))
+}
+
+func (r *BookkeeperClusterReconciler) deployCluster(ctx context.Context, p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+	err = r.deployBookie(ctx, p)
 	if err != nil {
-		log.Printf("failed to deploy bookie: %v", err)
+		log.FromContext(ctx).Error(err, "failed to deploy bookie")
 		return err
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) deployBookie(p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) deployBookie(ctx context.Context, p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 
 	statefulSet := MakeBookieStatefulSet(p)
 	controllerutil.SetControllerReference(p, statefulSet, r.Scheme)
@@ -183,71 +280,116 @@ func (r *BookkeeperClusterReconciler) deployBookie(p *bookkeeperv1alpha1.Bookkee
 			}
 		}
 	}
-	err = r.Client.Create(context.TODO(), statefulSet)
+	hash, err := r.configHash(ctx, p)
 	if err != nil {
-		if !errors.IsAlreadyExists(err) {
+		return err
+	}
+	if hash != "" {
+		statefulSet.Annotations[ConfigHashAnnotation] = hash
+		statefulSet.Spec.Template.Annotations[ConfigHashAnnotation] = hash
+	}
+
+	sts := &appsv1.StatefulSet{}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: statefulSet.Name, Namespace: p.Namespace}, sts)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get stateful set: %v", err)
+		}
+		err = r.apply(ctx, statefulSet)
+		if err != nil {
+			return fmt.Errorf("failed to create stateful set: %v", err)
+		}
+		r.Recorder.Eventf(p, corev1.EventTypeNormal, EventReasonCreated, "Created bookie statefulset %s with %d replicas", statefulSet.Name, p.Spec.Replicas)
+		return nil
+	}
+
+	// the pod template is updated by the upgrade and rollback themselves
+	if r.checkVersionUpgradeTriggered(ctx, p) || r.isRollbackTriggered(ctx, p) {
+		return nil
+	}
+	// the size is synced on its own, and the other fields can't be changed
+	statefulSet.Spec.Replicas = sts.Spec.Replicas
+	statefulSet.Spec.Selector = sts.Spec.Selector
+	statefulSet.Spec.ServiceName = sts.Spec.ServiceName
+	statefulSet.Spec.PodManagementPolicy = sts.Spec.PodManagementPolicy
+	statefulSet.Spec.VolumeClaimTemplates = sts.Spec.VolumeClaimTemplates
+	// the first hash of a cluster created without it is only recorded on the
+	// StatefulSet, so that upgrading the operator doesn't restart the bookies
+	if _, ok := sts.Spec.Template.Annotations[ConfigHashAnnotation]; !ok {
+		if seeded, ok := sts.Annotations[ConfigHashAnnotation]; !ok || seeded == hash {
+			delete(statefulSet.Spec.Template.Annotations, ConfigHashAnnotation)
+		}
+	}
+	err = r.apply(ctx, statefulSet)
+	if err != nil {
+		return fmt.Errorf("failed to update stateful set: %v", err)
+	}
+	if sts.Spec.Template.Annotations[ConfigHashAnnotation] != statefulSet.Spec.Template.Annotations[ConfigHashAnnotation] {
+		r.Recorder.Event(p, corev1.EventTypeNormal, EventReasonConfigChanged, "Referenced ConfigMaps or Secrets changed")
+	}
+	// only the fields owned by the operator are applied, so changes made to
+	// the template by other controllers do not restart the bookies
+	if !reflect.DeepEqual(sts.Spec.Template, statefulSet.Spec.Template) || p.Status.RestartPending {
+		err = r.restartStsPod(ctx, p)
+		if err != nil {
 			return err
-		} else {
-			sts := &appsv1.StatefulSet{}
-			name := util.StatefulSetNameForBookie(p.Name)
-			err := r.Client.Get(context.TODO(),
-				types.NamespacedName{Name: name, Namespace: p.Namespace}, sts)
-			if err != nil {
-				return err
-			}
-			if !r.checkVersionUpgradeTriggered(p) && !r.isRollbackTriggered(p) {
-				originalsts := sts.DeepCopy()
-				sts.Spec.Template = statefulSet.Spec.Template
-				err = r.Client.Update(context.TODO(), sts)
-				if err != nil {
-					return fmt.Errorf("failed to update stateful set: %v", err)
-				}
-				if !reflect.DeepEqual(originalsts.Spec.Template, sts.Spec.Template) {
-					err = r.restartStsPod(p)
-					if err != nil {
-						return err
-					}
-				}
-			}
 		}
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) syncClusterSize(p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
-	err = r.syncBookieSize(p)
+func (r *BookkeeperClusterReconciler) syncClusterSize(ctx context.Context, p *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+	err = r.syncBookieSize(ctx, p)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) syncBookieSize(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) syncBookieSize(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 	sts := &appsv1.StatefulSet{}
 	name := util.StatefulSetNameForBookie(bk.Name)
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: bk.Namespace}, sts)
+	err = r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, sts)
 	if err != nil {
 		return fmt.Errorf("failed to get stateful-set (%s): %v", sts.Name, err)
 	}
 
 	if *sts.Spec.Replicas != bk.Spec.Replicas {
+		previous := *sts.Spec.Replicas
+		if bk.Spec.Replicas < previous {
+			var pods []string
+			for i := bk.Spec.Replicas; i < previous; i++ {
+				pods = append(pods, fmt.Sprintf("%s-%d", sts.Name, i))
+			}
+			if r.dryRun(ctx, bk, "scale bookies from %d to %d, deleting pods %s", previous, bk.Spec.Replicas, strings.Join(pods, ",")) {
+				return nil
+			}
+		}
 		sts.Spec.Replicas = &(bk.Spec.Replicas)
-		err = r.Client.Update(context.TODO(), sts)
+		err = r.Client.Update(ctx, sts)
 		if err != nil {
 			return fmt.Errorf("failed to update size of stateful-set (%s): %v", sts.Name, err)
 		}
+		r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonScaled, "Scaled bookies from %d to %d", previous, bk.Spec.Replicas)
 
-		err = r.syncStatefulSetPvc(sts)
+		err = r.syncStatefulSetExternalServices(ctx, sts)
 		if err != nil {
-			return fmt.Errorf("failed to sync pvcs of stateful-set (%s): %v", sts.Name, err)
+			return fmt.Errorf("failed to sync services of stateful-set (%s): %v", sts.Name, err)
 		}
 	}
+
+	// the pvcs of removed bookies are swept on every reconcile, so that the
+	// ones kept in dry run mode or after a failed deletion are not leaked
+	err = r.syncStatefulSetPvc(ctx, bk, sts)
+	if err != nil {
+		return fmt.Errorf("failed to sync pvcs of stateful-set (%s): %v", sts.Name, err)
+	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) reconcileFinalizers(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) reconcileFinalizers(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 	currentBookkeeperCluster := &bookkeeperv1alpha1.BookkeeperCluster{}
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: bk.Name, Namespace: bk.Namespace}, currentBookkeeperCluster)
+	err = r.Client.Get(ctx, types.NamespacedName{Name: bk.Name, Namespace: bk.Namespace}, currentBookkeeperCluster)
 	if err != nil {
 		return fmt.Errorf("failed to get bookkeeper cluster (%s): %v", bk.Name, err)
 	}
@@ -259,7 +401,7 @@ func (r *BookkeeperClusterReconciler) reconcileFinalizers(bk *bookkeeperv1alpha1
 			finalizer := util.ZkFinalizer
 			configMap := &corev1.ConfigMap{}
 			if strings.TrimSpace(bk.Spec.EnvVars) != "" {
-				err = r.Client.Get(context.TODO(), types.NamespacedName{Name: strings.TrimSpace(bk.Spec.EnvVars), Namespace: bk.Namespace}, configMap)
+				err = r.Client.Get(ctx, types.NamespacedName{Name: strings.TrimSpace(bk.Spec.EnvVars), Namespace: bk.Namespace}, configMap)
 				if err != nil {
 					return fmt.Errorf("failed to get the configmap %s: %v", bk.Spec.EnvVars, err)
 				}
@@ -271,7 +413,7 @@ func (r *BookkeeperClusterReconciler) reconcileFinalizers(bk *bookkeeperv1alpha1
 				}
 			}
 			bk.ObjectMeta.Finalizers = append(bk.ObjectMeta.Finalizers, finalizer)
-			if err = r.Client.Update(context.TODO(), bk); err != nil {
+			if err = r.Client.Update(ctx, bk); err != nil {
 				return fmt.Errorf("failed to add the finalizer (%s): %v", bk.Name, err)
 			}
 		}
@@ -279,18 +421,23 @@ func (r *BookkeeperClusterReconciler) reconcileFinalizers(bk *bookkeeperv1alpha1
 		// checks whether the slice of finalizers contains a string with the given prefix
 		if util.ContainsStringWithPrefix(bk.ObjectMeta.Finalizers, util.ZkFinalizer) {
 			finalizer, pravegaClusterName := getFinalizerAndClusterName(bk.ObjectMeta.Finalizers)
+			if err = r.deleteLocalPersistentVolumes(ctx, bk); err != nil {
+				return err
+			}
+			if isDryRun(bk) {
+				return r.planZookeeperCleanup(ctx, bk, pravegaClusterName)
+			}
 			bk.ObjectMeta.Finalizers = util.RemoveString(bk.ObjectMeta.Finalizers, finalizer)
-			if err = r.Client.Update(context.TODO(), bk); err != nil {
+			if err = r.Client.Update(ctx, bk); err != nil {
 				return fmt.Errorf("failed to update Bookkeeper object (%s): %v", bk.Name, err)
 			}
-			if err = r.cleanUpZookeeperMeta(bk, pravegaClusterName); err != nil {
+			if err = r.cleanUpZookeeperMeta(ctx, bk, pravegaClusterName); err != nil {
 				// emit an event for zk metadata cleanup failure
 				message := fmt.Sprintf("failed to cleanup %s metadata from zookeeper (znode path: /pravega/%s): %v", bk.Name, pravegaClusterName, err)
-				event := bk.NewApplicationEvent("ZKMETA_CLEANUP_ERROR", "ZK Metadata Cleanup Failed", message, "Error")
-				pubErr := r.Client.Create(context.TODO(), event)
-				if pubErr != nil {
-					log.Printf("Error publishing zk metadata cleanup failure event to k8s. %v", pubErr)
+				if util.IsZookeeperNoAuth(err) {
+					message = fmt.Sprintf("the operator is not allowed to delete the %s metadata from zookeeper (znode path: /pravega/%s), delete it as a ZooKeeper super user: %v", bk.Name, pravegaClusterName, err)
 				}
+				r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonZookeeperCleanupFailed, message)
 				return fmt.Errorf(message)
 			}
 		}
@@ -298,18 +445,22 @@ func (r *BookkeeperClusterReconciler) reconcileFinalizers(bk *bookkeeperv1alpha1
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) cleanUpZookeeperMeta(bk *bookkeeperv1alpha1.BookkeeperCluster, pravegaClusterName string) (err error) {
+func (r *BookkeeperClusterReconciler) cleanUpZookeeperMeta(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, pravegaClusterName string) (err error) {
 	if err = bk.WaitForClusterToTerminate(r.Client); err != nil {
 		return fmt.Errorf("failed to wait for cluster pods termination (%s): %v", bk.Name, err)
 	}
 
-	if err = util.DeleteAllZnodes(bk.Spec.ZookeeperUri, bk.Namespace, pravegaClusterName); err != nil {
-		return fmt.Errorf("failed to delete zookeeper znodes for (%s): %v", bk.Name, err)
+	config, err := r.zookeeperConfig(ctx, bk)
+	if err != nil {
+		return err
+	}
+	if err = r.zookeeper().DeleteAllZnodes(ctx, config, pravegaClusterName); err != nil {
+		return fmt.Errorf("failed to delete zookeeper znodes for (%s): %w", bk.Name, err)
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) syncStatefulSetPvc(sts *appsv1.StatefulSet) error {
+func (r *BookkeeperClusterReconciler) syncStatefulSetPvc(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, sts *appsv1.StatefulSet) error {
 	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
 		MatchLabels: sts.Spec.Template.Labels,
 	})
@@ -322,13 +473,16 @@ func (r *BookkeeperClusterReconciler) syncStatefulSetPvc(sts *appsv1.StatefulSet
 		Namespace:     sts.Namespace,
 		LabelSelector: selector,
 	}
-	err = r.Client.List(context.TODO(), pvcList, pvclistOps)
+	err = r.Client.List(ctx, pvcList, pvclistOps)
 	if err != nil {
 		return err
 	}
 
 	for _, pvcItem := range pvcList.Items {
 		if util.IsOrphan(pvcItem.Name, *sts.Spec.Replicas) {
+			if r.dryRun(ctx, bk, "delete pvc %s of a removed bookie", pvcItem.Name) {
+				continue
+			}
 			pvcDelete := &corev1.PersistentVolumeClaim{
 				ObjectMeta: metav1.ObjectMeta{
 					Name:      pvcItem.Name,
@@ -336,7 +490,7 @@ func (r *BookkeeperClusterReconciler) syncStatefulSetPvc(sts *appsv1.StatefulSet
 				},
 			}
 
-			err = r.Client.Delete(context.TODO(), pvcDelete)
+			err = r.Client.Delete(ctx, pvcDelete)
 			if err != nil {
 				return fmt.Errorf("failed to delete pvc: %v", err)
 			}
@@ -344,95 +498,551 @@ func (r *BookkeeperClusterReconciler) syncStatefulSetPvc(sts *appsv1.StatefulSet
 	}
 	return nil
 }
-func (r *BookkeeperClusterReconciler) reconcileConfigMap(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) reconcileConfigMap(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 
 	currentConfigMap := &corev1.ConfigMap{}
 	configMap := MakeBookieConfigMap(bk)
 	controllerutil.SetControllerReference(bk, configMap, r.Scheme)
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: util.ConfigMapNameForBookie(bk.Name), Namespace: bk.Namespace}, currentConfigMap)
+	err = r.Client.Get(ctx, types.NamespacedName{Name: util.ConfigMapNameForBookie(bk.Name), Namespace: bk.Namespace}, currentConfigMap)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get configmap (%s): %v", configMap.Name, err)
+	}
+	created := errors.IsNotFound(err)
+	err = r.apply(ctx, configMap)
 	if err != nil {
-		if errors.IsNotFound(err) {
-			err = r.Client.Create(context.TODO(), configMap)
-			if err != nil && !errors.IsAlreadyExists(err) {
-				return err
-			}
-		}
-	} else {
-		currentConfigMap := &corev1.ConfigMap{}
-		err = r.Client.Get(context.TODO(), types.NamespacedName{Name: util.ConfigMapNameForBookie(bk.Name), Namespace: bk.Namespace}, currentConfigMap)
-		eq := util.CompareConfigMap(currentConfigMap, configMap)
-		if !eq {
-			err := r.Client.Update(context.TODO(), configMap)
-			if err != nil {
-				return err
-			}
-			//restarting sts pods
-			if !r.checkVersionUpgradeTriggered(bk) {
-				err = r.restartStsPod(bk)
-				if err != nil {
-					return err
-				}
-			}
+		return fmt.Errorf("failed to apply configmap (%s): %v", configMap.Name, err)
+	}
+	if created || util.CompareConfigMap(currentConfigMap, configMap) {
+		return nil
+	}
+	r.Recorder.Event(bk, corev1.EventTypeNormal, EventReasonConfigChanged, "Bookie configuration changed")
+	//restarting sts pods
+	if !r.checkVersionUpgradeTriggered(ctx, bk) {
+		err = r.restartStsPod(ctx, bk)
+		if err != nil {
+			return err
 		}
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) checkVersionUpgradeTriggered(bk *bookkeeperv1alpha1.BookkeeperCluster) bool {
+func (r *BookkeeperClusterReconciler) checkVersionUpgradeTriggered(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) bool {
 	currentBookkeeperCluster := &bookkeeperv1alpha1.BookkeeperCluster{}
-	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: bk.Name, Namespace: bk.Namespace}, currentBookkeeperCluster)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: bk.Name, Namespace: bk.Namespace}, currentBookkeeperCluster)
 	if err == nil && currentBookkeeperCluster.Status.CurrentVersion != bk.Spec.Version {
 		return true
 	}
 	return false
 }
-func (r *BookkeeperClusterReconciler) reconcilePdb(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) reconcilePdb(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 
 	pdb := MakeBookiePodDisruptionBudget(bk)
 	controllerutil.SetControllerReference(bk, pdb, r.Scheme)
-	err = r.Client.Create(context.TODO(), pdb)
-	if err != nil && !errors.IsAlreadyExists(err) {
-		return err
+	err = r.apply(ctx, pdb)
+	if err != nil {
+		return fmt.Errorf("failed to apply pdb (%s): %v", pdb.Name, err)
 	}
+	return nil
+}
 
-	currentPdb := &policyv1.PodDisruptionBudget{}
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: util.PdbNameForBookie(bk.Name), Namespace: bk.Namespace}, currentPdb)
+func (r *BookkeeperClusterReconciler) reconcileService(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	headlessService := MakeBookieHeadlessService(bk)
+	controllerutil.SetControllerReference(bk, headlessService, r.Scheme)
+	err := r.apply(ctx, headlessService)
+	if err != nil {
+		return fmt.Errorf("failed to apply svc (%s): %v", headlessService.Name, err)
+	}
+
+	if bk.IsExternalAccessEnabled() {
+		for i := int32(0); i < bk.Spec.Replicas; i++ {
+			err = r.reconcileExternalService(ctx, bk, MakeBookieExternalService(bk, i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reconcileMonitoring creates the ServiceMonitor and PrometheusRule of the
+// cluster. They are skipped when the prometheus-operator CRDs are not installed.
+func (r *BookkeeperClusterReconciler) reconcileMonitoring(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+	metrics := bk.Spec.Metrics
+	if bk.IsMetricsEnabled() && metrics.ServiceMonitor != nil && metrics.ServiceMonitor.Enabled {
+		err = r.applyMonitoringObject(ctx, bk, MakeBookieServiceMonitor(bk))
+	} else {
+		err = r.deleteMonitoringObject(ctx, bk, ServiceMonitorGVK)
+	}
 	if err != nil {
 		return err
 	}
-	return r.updatePdb(currentPdb, pdb)
+
+	if bk.IsMetricsEnabled() && metrics.PrometheusRule != nil && metrics.PrometheusRule.Enabled {
+		return r.applyMonitoringObject(ctx, bk, MakeBookiePrometheusRule(bk))
+	}
+	return r.deleteMonitoringObject(ctx, bk, PrometheusRuleGVK)
+}
+
+func (r *BookkeeperClusterReconciler) applyMonitoringObject(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, obj *unstructured.Unstructured) error {
+	controllerutil.SetControllerReference(bk, obj, r.Scheme)
+	err := r.apply(ctx, obj)
+	if meta.IsNoMatchError(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to apply %s (%s): %v", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
+
+func (r *BookkeeperClusterReconciler) deleteMonitoringObject(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, gvk schema.GroupVersionKind) error {
+	obj := &unstructured.Unstructured{}
+	obj.SetGroupVersionKind(gvk)
+	obj.SetName(util.StatefulSetNameForBookie(bk.Name))
+	obj.SetNamespace(bk.Namespace)
+	err := r.Client.Delete(ctx, obj)
+	if err != nil && !errors.IsNotFound(err) && !meta.IsNoMatchError(err) {
+		return fmt.Errorf("failed to delete %s (%s): %v", obj.GetKind(), obj.GetName(), err)
+	}
+	return nil
+}
+
+func (r *BookkeeperClusterReconciler) reconcileExternalService(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, svc *corev1.Service) error {
+	controllerutil.SetControllerReference(bk, svc, r.Scheme)
+	// the node ports are allocated by the API server, which also drops them
+	// when the type changes to ClusterIP
+	err := r.apply(ctx, svc)
+	if err != nil {
+		return fmt.Errorf("failed to apply svc (%s): %v", svc.Name, err)
+	}
+	return nil
 }
 
-func (r *BookkeeperClusterReconciler) updatePdb(currentPdb *policyv1.PodDisruptionBudget, newPdb *policyv1.PodDisruptionBudget) (err error) {
+// reconcileRackConfigMap keeps the zone of each bookie node in the racks
+// ConfigMap read by the bookie rack resolution script
+func (r *BookkeeperClusterReconciler) reconcileRackConfigMap(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	name := util.RackConfigMapNameForBookie(bk.Name)
+	if !bk.IsRackAware() {
+		cm := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: bk.Namespace}}
+		err := r.Client.Delete(ctx, cm)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete configmap (%s): %v", name, err)
+		}
+		return nil
+	}
 
-	if !reflect.DeepEqual(currentPdb.Spec.MaxUnavailable, newPdb.Spec.MaxUnavailable) {
-		currentPdb.Spec.MaxUnavailable = newPdb.Spec.MaxUnavailable
-		err = r.Client.Update(context.TODO(), currentPdb)
+	listOps := &client.ListOptions{
+		Namespace:     bk.Namespace,
+		LabelSelector: labels.SelectorFromSet(bk.LabelsForBookkeeperCluster()),
+	}
+	podList := &corev1.PodList{}
+	err := r.Client.List(ctx, podList, listOps)
+	if err != nil {
+		return err
+	}
+	zones := map[string]string{}
+	for _, pod := range podList.Items {
+		nodeName := pod.Spec.NodeName
+		if _, ok := zones[nodeName]; ok || nodeName == "" {
+			continue
+		}
+		node := &corev1.Node{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: nodeName}, node)
 		if err != nil {
-			return fmt.Errorf("failed to update pdb (%s): %v", currentPdb.Name, err)
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get node (%s): %v", nodeName, err)
 		}
+		zones[nodeName] = node.Labels[ZoneLabel]
+	}
+
+	configMap := MakeBookieRackConfigMap(bk, podList.Items, zones)
+	controllerutil.SetControllerReference(bk, configMap, r.Scheme)
+	// the script reads the racks file on each lookup, so no restart is needed
+	err = r.apply(ctx, configMap)
+	if err != nil {
+		return fmt.Errorf("failed to apply configmap (%s): %v", name, err)
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) reconcileService(bk *bookkeeperv1alpha1.BookkeeperCluster) error {
-	headlessService := MakeBookieHeadlessService(bk)
-	controllerutil.SetControllerReference(bk, headlessService, r.Scheme)
-	err := r.Client.Create(context.TODO(), headlessService)
-	if err != nil && !errors.IsAlreadyExists(err) {
+// reconcileMetadata restores the metadata snapshot given in spec.restore and
+// runs the metadata init job until the instance ID of the cluster is known,
+// and makes sure the bookie PVCs do not hold data of another cluster
+// instance. It returns false while the bookies cannot be deployed yet.
+func (r *BookkeeperClusterReconciler) reconcileMetadata(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (bool, error) {
+	if bk.Status.InstanceID == "" {
+		done, err := r.runRestoreJob(ctx, bk)
+		if err == nil && done {
+			done, err = r.runMetadataInitJob(ctx, bk)
+		}
+		if err != nil || !done {
+			// bookies deployed before the metadata was managed by the operator
+			// are kept running while the instance ID is looked up
+			deployed, getErr := r.isBookieDeployed(ctx, bk)
+			if getErr != nil {
+				return false, getErr
+			}
+			if deployed && err != nil {
+				log.FromContext(ctx).Info("failed to initialize the cluster metadata", "error", err.Error())
+				return true, nil
+			}
+			return deployed, err
+		}
+	}
+	return true, r.checkPvcInstanceID(ctx, bk)
+}
+
+// isBookieDeployed returns true if the bookie StatefulSet exists
+func (r *BookkeeperClusterReconciler) isBookieDeployed(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (bool, error) {
+	sts := &appsv1.StatefulSet{}
+	name := util.StatefulSetNameForBookie(bk.Name)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, sts)
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to get stateful set (%s): %v", name, err)
+	}
+	return true, nil
+}
+
+func (r *BookkeeperClusterReconciler) runMetadataInitJob(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (bool, error) {
+	name := util.MetadataInitJobNameForBookie(bk.Name)
+	job := &batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get job (%s): %v", name, err)
+		}
+		job = MakeBookieMetadataInitJob(bk)
+		controllerutil.SetControllerReference(bk, job, r.Scheme)
+		log.FromContext(ctx).Info("creating metadata init job", "job", name)
+		err = r.Client.Create(ctx, job)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create job (%s): %v", name, err)
+		}
+		return false, nil
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			// the failed job is deleted so that the next reconcile, after the
+			// backoff of the returned error, runs it again
+			bk.Status.SetMetadataInitializedConditionFalse(c.Reason, c.Message)
+			r.Client.Status().Update(ctx, bk)
+			r.Recorder.Eventf(bk, corev1.EventTypeWarning, EventReasonMetadataInitFailed, "Metadata init job %s failed: %s", name, c.Message)
+			propagation := metav1.DeletePropagationBackground
+			err = r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil && !errors.IsNotFound(err) {
+				return false, fmt.Errorf("failed to delete job (%s): %v", name, err)
+			}
+			return false, fmt.Errorf("metadata init job (%s) failed: %s", name, c.Message)
+		}
+	}
+	if job.Status.Succeeded == 0 {
+		return false, nil
+	}
+
+	instanceID, err := r.getMetadataInitJobResult(ctx, job)
+	if err != nil {
+		return false, err
+	}
+	log.FromContext(ctx).Info("cluster metadata initialized", "instanceID", instanceID)
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonMetadataInitialized, "Cluster metadata initialized with instance ID %s", instanceID)
+	bk.Status.InstanceID = instanceID
+	bk.Status.SetMetadataInitializedConditionTrue()
+	err = r.Client.Status().Update(ctx, bk)
+	if err != nil {
+		return false, fmt.Errorf("failed to record instance ID: %v", err)
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err = r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete job (%s): %v", name, err)
+	}
+	return true, nil
+}
+
+// runRestoreJob writes the snapshot given in spec.restore to ZooKeeper, once,
+// before the metadata is initialized. The restore fails if the metadata of
+// the cluster already exists in ZooKeeper. It returns true when there is
+// nothing left to restore.
+func (r *BookkeeperClusterReconciler) runRestoreJob(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (bool, error) {
+	restore := bk.Spec.Restore
+	if restore == nil || bk.Status.RestoredSnapshot == restore.Snapshot {
+		return true, nil
+	}
+
+	name := util.RestoreJobNameForBookie(bk.Name)
+	job := &batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get job (%s): %v", name, err)
+		}
+		if r.OperatorImage == "" {
+			return false, r.setRestoreFailed(ctx, bk, "the operator image is not known, set the --operator-image flag of the operator")
+		}
+		b := &bookkeeperv1alpha1.BookkeeperBackup{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: restore.BackupName, Namespace: bk.Namespace}, b)
+		if err != nil {
+			return false, r.setRestoreFailed(ctx, bk, fmt.Sprintf("failed to get bookkeeper backup (%s): %v", restore.BackupName, err))
+		}
+		if err = validateBackupStorage(&b.Spec.Storage); err != nil {
+			return false, r.setRestoreFailed(ctx, bk, err.Error())
+		}
+		ledgersRoot, err := ledgersRootPath(ctx, r.Client, bk)
+		if err != nil {
+			return false, err
+		}
+		// the cookies of the snapshot only match the volumes of the bookies
+		// they were taken from, new volumes get new cookies
+		pvcList := &corev1.PersistentVolumeClaimList{}
+		err = r.Client.List(ctx, pvcList, &client.ListOptions{
+			Namespace:     bk.Namespace,
+			LabelSelector: labels.SelectorFromSet(bk.LabelsForBookkeeperCluster()),
+		})
+		if err != nil {
+			return false, fmt.Errorf("failed to list pvcs: %v", err)
+		}
+		// the restore overwrites the metadata of the cluster in zookeeper
+		if r.dryRun(ctx, bk, "restore snapshot %s of backup %s to the ledgers root %s", restore.Snapshot, restore.BackupName, ledgersRoot) {
+			return false, nil
+		}
+		job = MakeBookieRestoreJob(bk, b, r.OperatorImage, ledgersRoot, len(pvcList.Items) == 0)
+		controllerutil.SetControllerReference(bk, job, r.Scheme)
+		log.FromContext(ctx).Info("creating metadata restore job", "job", name, "snapshot", restore.Snapshot)
+		err = r.Client.Create(ctx, job)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create job (%s): %v", name, err)
+		}
+		r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonMetadataRestoreStarted, "Restoring cluster metadata from snapshot %s of backup %s", restore.Snapshot, restore.BackupName)
+		return false, nil
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			// the failed job is deleted so that the next reconcile, after the
+			// backoff of the returned error, runs it again
+			err = r.setRestoreFailed(ctx, bk, fmt.Sprintf("metadata restore job (%s) failed: %s", name, c.Message))
+			propagation := metav1.DeletePropagationBackground
+			if deleteErr := r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation}); deleteErr != nil && !errors.IsNotFound(deleteErr) {
+				return false, fmt.Errorf("failed to delete job (%s): %v", name, deleteErr)
+			}
+			return false, err
+		}
+	}
+	if job.Status.Succeeded == 0 {
+		return false, nil
+	}
+
+	log.FromContext(ctx).Info("cluster metadata restored", "snapshot", restore.Snapshot)
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonMetadataRestored, "Cluster metadata restored from snapshot %s", restore.Snapshot)
+	bk.Status.RestoredSnapshot = restore.Snapshot
+	err = r.Client.Status().Update(ctx, bk)
+	if err != nil {
+		return false, fmt.Errorf("failed to record restored snapshot: %v", err)
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err = r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete job (%s): %v", name, err)
+	}
+	return true, nil
+}
+
+// setRestoreFailed reports the failed restore in the MetadataInitialized
+// condition, the bookies are not deployed until it is fixed
+func (r *BookkeeperClusterReconciler) setRestoreFailed(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, message string) error {
+	_, c := bk.Status.GetClusterCondition(bookkeeperv1alpha1.ClusterConditionMetadataInitialized)
+	if c == nil || c.Reason != EventReasonMetadataRestoreFailed || c.Message != message {
+		r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonMetadataRestoreFailed, message)
+		bk.Status.SetMetadataInitializedConditionFalse(EventReasonMetadataRestoreFailed, message)
+		r.Client.Status().Update(ctx, bk)
+	}
+	return fmt.Errorf("%s", message)
+}
+
+// getMetadataInitJobResult reads the instance ID from the termination message
+// of the succeeded metadata init pod
+func (r *BookkeeperClusterReconciler) getMetadataInitJobResult(ctx context.Context, job *batchv1.Job) (string, error) {
+	podList := &corev1.PodList{}
+	listOps := &client.ListOptions{
+		Namespace:     job.Namespace,
+		LabelSelector: labels.SelectorFromSet(map[string]string{"job-name": job.Name}),
+	}
+	err := r.Client.List(ctx, podList, listOps)
+	if err != nil {
+		return "", err
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodSucceeded {
+			continue
+		}
+		for _, cs := range pod.Status.ContainerStatuses {
+			if cs.State.Terminated != nil && cs.State.Terminated.Message != "" {
+				return strings.TrimSpace(cs.State.Terminated.Message), nil
+			}
+		}
+	}
+	return "", fmt.Errorf("no instance ID reported by job (%s)", job.Name)
+}
+
+// checkPvcInstanceID refuses bookie PVCs holding data of another cluster
+// instance, whose cookies would not match. The PVCs are annotated with the
+// instance ID through the volume claim templates; PVCs created before the
+// instance ID was known are left unannotated, as their data can't be told apart.
+func (r *BookkeeperClusterReconciler) checkPvcInstanceID(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	pvcList := &corev1.PersistentVolumeClaimList{}
+	listOps := &client.ListOptions{
+		Namespace:     bk.Namespace,
+		LabelSelector: labels.SelectorFromSet(bk.LabelsForBookkeeperCluster()),
+	}
+	err := r.Client.List(ctx, pvcList, listOps)
+	if err != nil {
 		return err
 	}
+	for _, pvc := range pvcList.Items {
+		instanceID, ok := pvc.Annotations[InstanceIDAnnotation]
+		if ok && instanceID != bk.Status.InstanceID {
+			message := fmt.Sprintf("pvc %s holds data of cluster instance %s", pvc.Name, instanceID)
+			bk.Status.SetMetadataInitializedConditionFalse(EventReasonInstanceIDMismatch, message)
+			r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonInstanceIDMismatch, message)
+			r.Client.Status().Update(ctx, bk)
+			return fmt.Errorf("%s, expected instance %s", message, bk.Status.InstanceID)
+		}
+	}
+	return nil
+}
+
+func (r *BookkeeperClusterReconciler) reconcileLocalPersistentVolumes(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	if !bk.Spec.Storage.IsNodeLocal() {
+		return nil
+	}
 
-	currentPdb := &policyv1.PodDisruptionBudget{}
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: util.PdbNameForBookie(bk.Name), Namespace: bk.Namespace}, currentPdb)
+	desired := map[string]bool{}
+	for _, pv := range MakeBookieLocalPersistentVolumes(bk) {
+		desired[pv.Name] = true
+		err := r.Client.Create(ctx, pv)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create persistent volume (%s): %v", pv.Name, err)
+		}
+	}
 
+	// Volumes of removed bookies are released once their claims are deleted on
+	// scale down. The data is left on the node, only the volume object is dropped.
+	pvList := &corev1.PersistentVolumeList{}
+	err := r.Client.List(ctx, pvList, client.MatchingLabels(bk.LabelsForBookkeeperCluster()))
+	if err != nil {
+		return err
+	}
+	for i := range pvList.Items {
+		pv := &pvList.Items[i]
+		if desired[pv.Name] || pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != bk.Namespace {
+			continue
+		}
+		if pv.Status.Phase == corev1.VolumeReleased || pv.Status.Phase == corev1.VolumeAvailable {
+			if r.dryRun(ctx, bk, "delete local persistent volume %s of a removed bookie", pv.Name) {
+				continue
+			}
+			log.FromContext(ctx).Info("deleting local persistent volume of removed bookie", "persistentVolume", pv.Name)
+			err = r.Client.Delete(ctx, pv)
+			if err != nil && !errors.IsNotFound(err) {
+				return fmt.Errorf("failed to delete persistent volume (%s): %v", pv.Name, err)
+			}
+		}
+	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) restartStsPod(bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+// deleteLocalPersistentVolumes deletes the node local volumes of a deleted
+// cluster. The volumes are removed once their claims are garbage collected,
+// and the data is left on the nodes.
+func (r *BookkeeperClusterReconciler) deleteLocalPersistentVolumes(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	pvList := &corev1.PersistentVolumeList{}
+	err := r.Client.List(ctx, pvList, client.MatchingLabels(bk.LabelsForBookkeeperCluster()))
+	if err != nil {
+		return err
+	}
+	for i := range pvList.Items {
+		pv := &pvList.Items[i]
+		if pv.Spec.ClaimRef == nil || pv.Spec.ClaimRef.Namespace != bk.Namespace || !pv.DeletionTimestamp.IsZero() {
+			continue
+		}
+		if r.dryRun(ctx, bk, "delete local persistent volume %s of the deleted cluster", pv.Name) {
+			continue
+		}
+		log.FromContext(ctx).Info("deleting local persistent volume of deleted cluster", "persistentVolume", pv.Name)
+		err = r.Client.Delete(ctx, pv)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete persistent volume (%s): %v", pv.Name, err)
+		}
+	}
+	return nil
+}
+
+// getStrandedBookies returns the bookies whose node local volumes are pinned
+// to a node that has been removed from the cluster. Such bookies can't be
+// scheduled again until their data is moved or the bookie is replaced.
+func (r *BookkeeperClusterReconciler) getStrandedBookies(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) ([]string, error) {
+	pvList := &corev1.PersistentVolumeList{}
+	err := r.Client.List(ctx, pvList, client.MatchingLabels(bk.LabelsForBookkeeperCluster()))
+	if err != nil {
+		return nil, err
+	}
+	stsName := util.StatefulSetNameForBookie(bk.Name)
+	nodes := map[string]bool{}
+	stranded := map[string]bool{}
+	for _, pv := range pvList.Items {
+		claim := pv.Spec.ClaimRef
+		if claim == nil || claim.Namespace != bk.Namespace || pv.Spec.NodeAffinity == nil || pv.Spec.NodeAffinity.Required == nil {
+			continue
+		}
+		// claims are named <disk>-<statefulset>-<ordinal>
+		i := strings.Index(claim.Name, "-"+stsName+"-")
+		if i < 0 || util.IsOrphan(claim.Name, bk.Spec.Replicas) {
+			continue
+		}
+		bookie := claim.Name[i+1:]
+		for _, term := range pv.Spec.NodeAffinity.Required.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if expr.Key != "kubernetes.io/hostname" {
+					continue
+				}
+				for _, name := range expr.Values {
+					exists, ok := nodes[name]
+					if !ok {
+						node := &corev1.Node{}
+						err = r.Client.Get(ctx, types.NamespacedName{Name: name}, node)
+						if err != nil && !errors.IsNotFound(err) {
+							return nil, err
+						}
+						exists = err == nil
+						nodes[name] = exists
+					}
+					if !exists {
+						stranded[bookie] = true
+					}
+				}
+			}
+		}
+	}
+	var names []string
+	for name := range stranded {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (r *BookkeeperClusterReconciler) restartStsPod(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
 
 	currentSts := &appsv1.StatefulSet{}
-	err := r.Client.Get(context.TODO(), types.NamespacedName{Name: util.StatefulSetNameForBookie(bk.Name), Namespace: bk.Namespace}, currentSts)
+	err := r.Client.Get(ctx, types.NamespacedName{Name: util.StatefulSetNameForBookie(bk.Name), Namespace: bk.Namespace}, currentSts)
 	if err != nil {
 		return err
 	}
@@ -449,41 +1059,105 @@ func (r *BookkeeperClusterReconciler) restartStsPod(bk *bookkeeperv1alpha1.Bookk
 		Namespace:     currentSts.Namespace,
 		LabelSelector: selector,
 	}
-	err = r.Client.List(context.TODO(), podList, podlistOps)
+	err = r.Client.List(ctx, podList, podlistOps)
 	if err != nil {
 		return err
 	}
 	sort.SliceStable(podList.Items, func(i int, j int) bool {
 		return podList.Items[i].Name < podList.Items[j].Name
 	})
+	var names []string
 	for _, podItem := range podList.Items {
-		err := r.Client.Delete(context.TODO(), &podItem)
+		names = append(names, podItem.Name)
+	}
+	// the pending restart is recorded until all the bookies are restarted,
+	// since the StatefulSet already holds the new template
+	if !bk.Status.RestartPending {
+		bk.Status.RestartPending = true
+		if err = r.Client.Status().Update(ctx, bk); err != nil {
+			return fmt.Errorf("failed to record pending restart: %v", err)
+		}
+	}
+	if r.dryRun(ctx, bk, "restart bookies %s", strings.Join(names, ",")) {
+		return nil
+	}
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonRestartStarted, "Restarting %d bookies", len(podList.Items))
+	for _, podItem := range podList.Items {
+		err := r.Client.Delete(ctx, &podItem)
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+		err = r.waitForPodTermination(ctx, &podItem, podTerminationTimeout(bk))
+		if err == nil {
+			err = r.waitForPodReplacement(ctx, &podItem, 10*time.Minute)
+		}
 		if err != nil {
+			r.Recorder.Eventf(bk, corev1.EventTypeWarning, EventReasonRestartFailed, "Failed to restart bookie %s: %v", podItem.Name, err)
 			return err
-		} else {
-			start := time.Now()
-			pod := &corev1.Pod{}
-			err = r.Client.Get(context.TODO(), types.NamespacedName{Name: podItem.ObjectMeta.Name, Namespace: podItem.ObjectMeta.Namespace}, pod)
-			for util.IsPodReady(pod) {
-				if time.Since(start) > 10*time.Minute {
-					return fmt.Errorf("failed to delete Bookkeeper pod (%s) for 10 mins ", podItem.ObjectMeta.Name)
-				}
-				err = r.Client.Get(context.TODO(), types.NamespacedName{Name: podItem.ObjectMeta.Name, Namespace: podItem.ObjectMeta.Namespace}, pod)
-			}
-			start = time.Now()
-			err = r.Client.Get(context.TODO(), types.NamespacedName{Name: podItem.ObjectMeta.Name, Namespace: podItem.ObjectMeta.Namespace}, pod)
-			for !util.IsPodReady(pod) {
-				if time.Since(start) > 10*time.Minute {
-					return fmt.Errorf("failed to get Bookkeeper pod (%s) as ready for 10 mins ", podItem.ObjectMeta.Name)
-				}
-				err = r.Client.Get(context.TODO(), types.NamespacedName{Name: podItem.ObjectMeta.Name, Namespace: podItem.ObjectMeta.Namespace}, pod)
-			}
 		}
 	}
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonRestartFinished, "Restarted %d bookies", len(podList.Items))
+	bk.Status.RestartPending = false
+	if err = r.Client.Status().Update(ctx, bk); err != nil {
+		return fmt.Errorf("failed to clear pending restart: %v", err)
+	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) syncStatefulSetExternalServices(sts *appsv1.StatefulSet) error {
+// podPollInterval is the delay between two checks of a pod being restarted
+var podPollInterval = time.Second
+
+// podTerminationTimeout is how long a deleted bookie pod may take to
+// terminate: its grace period plus a minute for the kubelet to clean up
+func podTerminationTimeout(bk *bookkeeperv1alpha1.BookkeeperCluster) time.Duration {
+	grace := int64(bookkeeperv1alpha1.DefaultTerminationGracePeriodSeconds)
+	if bk.Spec.TerminationGracePeriodSeconds != nil {
+		grace = *bk.Spec.TerminationGracePeriodSeconds
+	}
+	return time.Duration(grace)*time.Second + time.Minute
+}
+
+// waitForPodTermination waits until the given pod is gone, i.e. its preStop
+// hook has run and its containers have stopped
+func (r *BookkeeperClusterReconciler) waitForPodTermination(ctx context.Context, old *corev1.Pod, timeout time.Duration) error {
+	err := wait.PollImmediate(podPollInterval, timeout, func() (bool, error) {
+		pod := &corev1.Pod{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: old.Name, Namespace: old.Namespace}, pod)
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return pod.UID != old.UID, nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete Bookkeeper pod (%s) in %v: %v", old.Name, timeout, err)
+	}
+	return nil
+}
+
+// waitForPodReplacement waits until the pod recreated in place of the given
+// one is ready
+func (r *BookkeeperClusterReconciler) waitForPodReplacement(ctx context.Context, old *corev1.Pod, timeout time.Duration) error {
+	err := wait.PollImmediate(podPollInterval, timeout, func() (bool, error) {
+		pod := &corev1.Pod{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: old.Name, Namespace: old.Namespace}, pod)
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return pod.UID != old.UID && util.IsPodReady(pod), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get Bookkeeper pod (%s) as ready in %v: %v", old.Name, timeout, err)
+	}
+	return nil
+}
+
+func (r *BookkeeperClusterReconciler) syncStatefulSetExternalServices(ctx context.Context, sts *appsv1.StatefulSet) error {
 	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
 		MatchLabels: sts.Spec.Template.Labels,
 	})
@@ -496,7 +1170,7 @@ func (r *BookkeeperClusterReconciler) syncStatefulSetExternalServices(sts *appsv
 		Namespace:     sts.Namespace,
 		LabelSelector: selector,
 	}
-	err = r.Client.List(context.TODO(), serviceList, servicelistOps)
+	err = r.Client.List(ctx, serviceList, servicelistOps)
 	if err != nil {
 		return err
 	}
@@ -510,7 +1184,7 @@ func (r *BookkeeperClusterReconciler) syncStatefulSetExternalServices(sts *appsv
 				},
 			}
 
-			err = r.Client.Delete(context.TODO(), svcDelete)
+			err = r.Client.Delete(ctx, svcDelete)
 			if err != nil {
 				return fmt.Errorf("failed to delete svc: %v", err)
 			}
@@ -519,7 +1193,7 @@ func (r *BookkeeperClusterReconciler) syncStatefulSetExternalServices(sts *appsv
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) reconcileClusterStatus(bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+func (r *BookkeeperClusterReconciler) reconcileClusterStatus(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
 
 	bk.Status.Init()
 
@@ -529,7 +1203,7 @@ func (r *BookkeeperClusterReconciler) reconcileClusterStatus(bk *bookkeeperv1alp
 		LabelSelector: labels.SelectorFromSet(bk.LabelsForBookkeeperCluster()),
 	}
 	podList := &corev1.PodList{}
-	err := r.Client.List(context.TODO(), podList, listOps)
+	err := r.Client.List(ctx, podList, listOps)
 	if err != nil {
 		return err
 	}
@@ -539,12 +1213,29 @@ func (r *BookkeeperClusterReconciler) reconcileClusterStatus(bk *bookkeeperv1alp
 		unreadyMembers []string
 	)
 
+	bookieConditions := bk.Status.BookieConditions
+	bk.Status.BookieConditions = nil
+	for _, c := range bookieConditions {
+		// failed repairs are tracked by repairBookies
+		if c.Type == bookkeeperv1alpha1.BookieConditionRepairFailed {
+			bk.Status.BookieConditions = append(bk.Status.BookieConditions, c)
+		}
+	}
 	for _, pod := range podList.Items {
 		if util.IsPodReady(&pod) {
 			readyMembers = append(readyMembers, pod.Name)
 		} else {
 			unreadyMembers = append(unreadyMembers, pod.Name)
 		}
+		if mismatch, message := util.IsCookieMismatch(&pod); mismatch {
+			// keep the transition time of known mismatches
+			for _, c := range bookieConditions {
+				if c.Bookie == pod.Name && c.Type == bookkeeperv1alpha1.BookieConditionCookieMismatch {
+					bk.Status.BookieConditions = append(bk.Status.BookieConditions, c)
+				}
+			}
+			bk.Status.SetBookieConditionTrue(pod.Name, bookkeeperv1alpha1.BookieConditionCookieMismatch, "InvalidCookie", message)
+		}
 	}
 
 	if len(readyMembers) == expectedSize {
@@ -559,25 +1250,237 @@ func (r *BookkeeperClusterReconciler) reconcileClusterStatus(bk *bookkeeperv1alp
 	bk.Status.Members.Ready = readyMembers
 	bk.Status.Members.Unready = unreadyMembers
 
-	err = r.Client.Status().Update(context.TODO(), bk)
+	if bk.Spec.Storage.IsNodeLocal() {
+		stranded, err := r.getStrandedBookies(ctx, bk)
+		if err != nil {
+			return fmt.Errorf("failed to check storage nodes: %v", err)
+		}
+		bk.Status.Members.Stranded = stranded
+		if len(stranded) == 0 {
+			bk.Status.SetStorageNodesAvailableConditionTrue()
+		} else {
+			bk.Status.SetStorageNodesAvailableConditionFalse("StorageNodeNotFound",
+				fmt.Sprintf("node holding the data of bookies %s no longer exists", strings.Join(stranded, ",")))
+		}
+	}
+
+	err = r.Client.Status().Update(ctx, bk)
 	if err != nil {
 		return fmt.Errorf("failed to update cluster status: %v", err)
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) rollbackFailedUpgrade(bk *bookkeeperv1alpha1.BookkeeperCluster) error {
-	if r.isRollbackTriggered(bk) {
+// repairBookies formats the bookies failing with a cookie mismatch and
+// deletes their stale cookie from ZooKeeper, if auto repair is enabled
+func (r *BookkeeperClusterReconciler) repairBookies(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	if !bk.Spec.AutoRepair {
+		return nil
+	}
+	jobList := &batchv1.JobList{}
+	err := r.Client.List(ctx, jobList, &client.ListOptions{
+		Namespace:     bk.Namespace,
+		LabelSelector: labels.SelectorFromSet(labelsForBookieJob(bk, "bookie-repair")),
+	})
+	if err != nil {
+		return err
+	}
+	repairing := map[string]bool{}
+	for i := range jobList.Items {
+		job := &jobList.Items[i]
+		bookie := strings.TrimSuffix(job.Name, "-repair")
+		repairing[bookie] = true
+		err = r.repairBookie(ctx, bk, bookie, job)
+		if err != nil {
+			return fmt.Errorf("failed to repair bookie (%s): %v", bookie, err)
+		}
+	}
+
+	// a failed repair is retried once its job is deleted
+	var retried []string
+	for _, c := range bk.Status.BookieConditions {
+		if c.Type == bookkeeperv1alpha1.BookieConditionRepairFailed && !repairing[c.Bookie] {
+			retried = append(retried, c.Bookie)
+		}
+	}
+	if len(retried) > 0 {
+		for _, bookie := range retried {
+			bk.Status.ClearBookieCondition(bookie, bookkeeperv1alpha1.BookieConditionRepairFailed)
+		}
+		err = r.Client.Status().Update(ctx, bk)
+		if err != nil {
+			return fmt.Errorf("failed to update bookie conditions: %v", err)
+		}
+	}
+
+	listOps := &client.ListOptions{
+		Namespace:     bk.Namespace,
+		LabelSelector: labels.SelectorFromSet(bk.LabelsForBookie()),
+	}
+	podList := &corev1.PodList{}
+	err = r.Client.List(ctx, podList, listOps)
+	if err != nil {
+		return err
+	}
+	for _, pod := range podList.Items {
+		if repairing[pod.Name] {
+			continue
+		}
+		if mismatch, _ := util.IsCookieMismatch(&pod); !mismatch {
+			continue
+		}
+		if replacement := bk.Status.BookieReplacement; replacement.IsInProgress() && replacement.Bookie == pod.Name {
+			continue
+		}
+		err = r.startBookieRepair(ctx, bk, &pod)
+		if err != nil {
+			return fmt.Errorf("failed to repair bookie (%s): %v", pod.Name, err)
+		}
+	}
+	return nil
+}
+
+// startBookieRepair creates the suspended repair Job of the bookie, then
+// deletes the bookie pod. The Job only formats the bookie volumes once the
+// pod is gone.
+func (r *BookkeeperClusterReconciler) startBookieRepair(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, pod *corev1.Pod) error {
+	if r.dryRun(ctx, bk, "format bookie %s after a cookie mismatch, delete its cookie and restart it", pod.Name) {
+		return nil
+	}
+	job := MakeBookieRepairJob(bk, pod)
+	controllerutil.SetControllerReference(bk, job, r.Scheme)
+	log.FromContext(ctx).Info("stopping bookie with a cookie mismatch", "pod", pod.Name)
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonBookieRepairStarted, "Formatting bookie %s after a cookie mismatch", pod.Name)
+	err := r.Client.Create(ctx, job)
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create job (%s): %v", job.Name, err)
+	}
+	err = r.Client.Delete(ctx, pod)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete pod (%s): %v", pod.Name, err)
+	}
+	return nil
+}
+
+// repairBookie resumes the repair Job of the bookie once the failed pod is
+// gone. Once the Job has formatted the bookie volumes, it deletes the bookie
+// cookie from ZooKeeper and restarts the bookie. A failed Job, or a cookie
+// the operator is not allowed to delete, is recorded in the bookie conditions,
+// and the repair is not retried while the Job is kept.
+func (r *BookkeeperClusterReconciler) repairBookie(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, bookie string, job *batchv1.Job) error {
+	if bk.Status.GetBookieCondition(bookie, bookkeeperv1alpha1.BookieConditionRepairFailed) != nil {
+		return nil
+	}
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			return r.failBookieRepair(ctx, bk, bookie, c.Reason, fmt.Sprintf("repair job %s failed: %s", job.Name, c.Message))
+		}
+	}
+
+	pod := &corev1.Pod{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: bookie, Namespace: bk.Namespace}, pod)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get pod (%s): %v", bookie, err)
+		}
+		pod = nil
+	}
+	if job.Spec.Suspend != nil && *job.Spec.Suspend {
+		if pod != nil && string(pod.UID) == job.Annotations[RepairPodUIDAnnotation] {
+			// the failed pod is still terminating
+			return nil
+		}
+		log.FromContext(ctx).Info("formatting bookie after a cookie mismatch", "pod", bookie)
+		suspend := false
+		job.Spec.Suspend = &suspend
+		err = r.Client.Update(ctx, job)
+		if err != nil {
+			return fmt.Errorf("failed to resume job (%s): %v", job.Name, err)
+		}
+		return nil
+	}
+	if job.Status.Succeeded == 0 {
+		return nil
+	}
+
+	ledgersRoot, err := ledgersRootPath(ctx, r.Client, bk)
+	if err != nil {
+		return err
+	}
+	config, err := r.zookeeperConfig(ctx, bk)
+	if err != nil {
+		return err
+	}
+	err = r.zookeeper().DeleteBookieCookie(ctx, config, ledgersRoot, bookie)
+	if util.IsZookeeperNoAuth(err) {
+		// retrying would fail the same way until the ACL or the credentials change
+		return r.failBookieRepair(ctx, bk, bookie, "ZookeeperNoAuth", fmt.Sprintf("the operator is not allowed to delete the cookie of bookie %s in ZooKeeper: %v", bookie, err))
+	}
+	if err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("restarting repaired bookie", "pod", bookie)
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonBookieRepaired, "Deleted the stale cookie of bookie %s and restarted it", bookie)
+	if pod != nil {
+		// the pod created while the volumes were formatted still fails
+		err = r.Client.Delete(ctx, pod)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pod (%s): %v", bookie, err)
+		}
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete job (%s): %v", job.Name, err)
+	}
+	return nil
+}
+
+// failBookieRepair records the failed repair of the bookie in its conditions.
+// The repair is not retried until its Job is deleted.
+func (r *BookkeeperClusterReconciler) failBookieRepair(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, bookie string, reason string, message string) error {
+	log.FromContext(ctx).Info("bookie repair failed", "pod", bookie, "reason", message)
+	r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonBookieRepairFailed, message)
+	bk.Status.SetBookieConditionTrue(bookie, bookkeeperv1alpha1.BookieConditionRepairFailed, reason, message)
+	err := r.Client.Status().Update(ctx, bk)
+	if err != nil {
+		return fmt.Errorf("failed to update bookie conditions: %v", err)
+	}
+	return nil
+}
+
+// ledgersRootPath returns the ZooKeeper path of the BookKeeper metadata, as
+// set by the bookie entrypoint unless overridden in the options
+func ledgersRootPath(ctx context.Context, c client.Client, bk *bookkeeperv1alpha1.BookkeeperCluster) (string, error) {
+	if path, ok := bk.Spec.Options["zkLedgersRootPath"]; ok {
+		return path, nil
+	}
+	pravegaClusterName := "pravega-cluster"
+	if envVars := strings.TrimSpace(bk.Spec.EnvVars); envVars != "" {
+		configMap := &corev1.ConfigMap{}
+		err := c.Get(ctx, types.NamespacedName{Name: envVars, Namespace: bk.Namespace}, configMap)
+		if err != nil {
+			return "", fmt.Errorf("failed to get the configmap %s: %v", envVars, err)
+		}
+		if name, ok := configMap.Data["PRAVEGA_CLUSTER_NAME"]; ok {
+			pravegaClusterName = name
+		}
+	}
+	return fmt.Sprintf("/%s/%s/bookkeeper/ledgers", util.PravegaPath, pravegaClusterName), nil
+}
+
+func (r *BookkeeperClusterReconciler) rollbackFailedUpgrade(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	if r.isRollbackTriggered(ctx, bk) {
 		// start rollback to previous version
 		previousVersion := bk.Status.GetLastVersion()
-		log.Printf("Rolling back to last cluster version  %v", previousVersion)
+		log.FromContext(ctx).Info("Rolling back to last cluster version", "version", previousVersion)
 		//Rollback cluster to previous version
-		return r.rollbackClusterVersion(bk, previousVersion)
+		return r.rollbackClusterVersion(ctx, bk, previousVersion)
 	}
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) isRollbackTriggered(bk *bookkeeperv1alpha1.BookkeeperCluster) bool {
+func (r *BookkeeperClusterReconciler) isRollbackTriggered(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) bool {
 	if bk.Status.IsClusterInUpgradeFailedState() && bk.Spec.Version == bk.Status.GetLastVersion() {
 		return true
 	}
@@ -601,5 +1504,9 @@ func getFinalizerAndClusterName(slice []string) (string, string) {
 func (r *BookkeeperClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&bookkeeperv1alpha1.BookkeeperCluster{}).
+		Owns(&batchv1.Job{}).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.clustersReferencing)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.clustersReferencing)).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
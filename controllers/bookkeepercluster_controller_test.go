@@ -14,19 +14,26 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pravega/bookkeeper-operator/pkg/controller/config"
 	policyv1 "k8s.io/api/policy/v1"
-	"k8s.io/apimachinery/pkg/util/intstr"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	gozk "github.com/samuel/go-zookeeper/zk"
 	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -67,6 +74,8 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					Namespace: Namespace,
 				},
 			}
+			// the metadata of the cluster has already been initialized
+			b.Status.InstanceID = "a1b2c3d4-0000-0000-0000-000000000000"
 			s.AddKnownTypes(v1alpha1.GroupVersion, b)
 		})
 		Context("Without spec", func() {
@@ -74,16 +83,15 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 				client          client.Client
 				err             error
 				foundBookkeeper *v1alpha1.BookkeeperCluster
-				ctx             context.Context
 			)
 
 			BeforeEach(func() {
 				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b).Build()
 				//client = fake.NewFakeClient(b)
 
-				r = &BookkeeperClusterReconciler{Client: client, Scheme: s}
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
 				//1st reconcile
-				res, err = r.Reconcile(ctx, req)
+				res, err = r.Reconcile(context.TODO(), req)
 			})
 			It("shouldn't error", func() {
 				Ω(err).Should(BeNil())
@@ -106,13 +114,13 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 			Context("After defaults are applied", func() {
 				BeforeEach(func() {
 					// 2nd reconcile
-					res, err = r.Reconcile(ctx, req)
+					res, err = r.Reconcile(context.TODO(), req)
 				})
 				It("should requeue after ReconfileTime delay", func() {
 					Ω(res.RequeueAfter).To(Equal(ReconcileTime))
 				})
 				It("should set current version on 2nd reconcile ", func() {
-					res, err = r.Reconcile(ctx, req)
+					res, err = r.Reconcile(context.TODO(), req)
 					foundBookkeeper := &v1alpha1.BookkeeperCluster{}
 					err = client.Get(context.TODO(), req.NamespacedName, foundBookkeeper)
 					Ω(err).Should(BeNil())
@@ -121,7 +129,7 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 			})
 			Context("Checking Cluster deployment", func() {
 				BeforeEach(func() {
-					res, err = r.Reconcile(ctx, req)
+					res, err = r.Reconcile(context.TODO(), req)
 					foundBookkeeper = &v1alpha1.BookkeeperCluster{}
 					err = client.Get(context.TODO(), req.NamespacedName, foundBookkeeper)
 				})
@@ -129,34 +137,20 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					Ω(err).Should(BeNil())
 				})
 			})
-			Context("checking updatePDB", func() {
+			Context("checking reconcilePdb", func() {
 				var (
 					err1 error
 					str1 string
 				)
 				BeforeEach(func() {
-					res, err = r.Reconcile(ctx, req)
+					res, err = r.Reconcile(context.TODO(), req)
+					foundBookkeeper = &v1alpha1.BookkeeperCluster{}
+					r.Client.Get(context.TODO(), req.NamespacedName, foundBookkeeper)
+					foundBookkeeper.Spec.MaxUnavailableBookkeeperReplicas = 3
+					err1 = r.reconcilePdb(context.TODO(), foundBookkeeper)
 					currentpdb := &policyv1.PodDisruptionBudget{}
 					pdbname := fmt.Sprintf("%s-bookie", b.Name)
 					r.Client.Get(context.TODO(), types.NamespacedName{Name: pdbname, Namespace: b.Namespace}, currentpdb)
-					maxUnavailable := intstr.FromInt(3)
-					newpdb := &policyv1.PodDisruptionBudget{
-						TypeMeta: metav1.TypeMeta{
-							Kind:       "PodDisruptionBudget",
-							APIVersion: "policy/v1",
-						},
-						ObjectMeta: metav1.ObjectMeta{
-							Name:      "test-name",
-							Namespace: b.Namespace,
-						},
-						Spec: policyv1.PodDisruptionBudgetSpec{
-							MaxUnavailable: &maxUnavailable,
-							Selector: &metav1.LabelSelector{
-								MatchLabels: b.LabelsForBookie(),
-							},
-						},
-					}
-					err1 = r.updatePdb(currentpdb, newpdb)
 					str1 = fmt.Sprintf("%s", currentpdb.Spec.MaxUnavailable)
 				})
 				It("should not give error", func() {
@@ -171,9 +165,9 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					ans1, ans2 bool
 				)
 				BeforeEach(func() {
-					ans1 = r.checkVersionUpgradeTriggered(b)
+					ans1 = r.checkVersionUpgradeTriggered(context.TODO(), b)
 					b.Spec.Version = "0.8.0"
-					ans2 = r.checkVersionUpgradeTriggered(b)
+					ans2 = r.checkVersionUpgradeTriggered(context.TODO(), b)
 				})
 				It("ans1 should be false", func() {
 					Ω(ans1).To(Equal(false))
@@ -194,11 +188,11 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					b.Spec.BlockOwnerDeletion = &flag
 					b.WithDefaults()
 					//to ensure the client get for BookKeepercluster fails
-					err = r.syncBookieSize(b)
-					_, _ = r.Reconcile(ctx, req)
+					err = r.syncBookieSize(context.TODO(), b)
+					_, _ = r.Reconcile(context.TODO(), req)
 					b.Spec.Replicas = 5
 					client.Update(context.TODO(), b)
-					_, err1 = r.Reconcile(ctx, req)
+					_, err1 = r.Reconcile(context.TODO(), req)
 				})
 
 				It("should give error", func() {
@@ -207,6 +201,34 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 				It("should not give error", func() {
 					Ω(err1).Should(BeNil())
 				})
+				It("should record the creation and the scaling of the bookies", func() {
+					foundBookkeeper = &v1alpha1.BookkeeperCluster{}
+					_ = client.Get(context.TODO(), req.NamespacedName, foundBookkeeper)
+					foundBookkeeper.Spec.Replicas = 5
+					Ω(r.syncBookieSize(context.TODO(), foundBookkeeper)).Should(Succeed())
+					var events []string
+					recorder := r.Recorder.(*record.FakeRecorder)
+					for len(recorder.Events) > 0 {
+						events = append(events, <-recorder.Events)
+					}
+					Ω(events).Should(ContainElement(HavePrefix("Normal " + EventReasonCreated)))
+					Ω(events).Should(ContainElement("Normal " + EventReasonScaled + " Scaled bookies from 3 to 5"))
+				})
+				It("should delete the pvcs of removed bookies without scaling", func() {
+					foundBookkeeper = &v1alpha1.BookkeeperCluster{}
+					_ = client.Get(context.TODO(), req.NamespacedName, foundBookkeeper)
+					pvc := &corev1.PersistentVolumeClaim{
+						ObjectMeta: metav1.ObjectMeta{
+							Name:      "ledger-example-bookie-7",
+							Namespace: b.Namespace,
+							Labels:    MakeBookieStatefulSet(foundBookkeeper).Spec.Template.Labels,
+						},
+					}
+					Ω(client.Create(context.TODO(), pvc)).Should(Succeed())
+					Ω(r.syncBookieSize(context.TODO(), foundBookkeeper)).Should(Succeed())
+					err = client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, pvc)
+					Ω(errors.IsNotFound(err)).Should(BeTrue())
+				})
 			})
 			Context("reconcileFinalizers", func() {
 				BeforeEach(func() {
@@ -214,11 +236,11 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					config.DisableFinalizer = false
 					b.Spec.EnvVars = "vars"
 					client.Update(context.TODO(), b)
-					_, err = r.Reconcile(ctx, req)
+					_, err = r.Reconcile(context.TODO(), req)
 					now := metav1.Now()
 					b.SetDeletionTimestamp(&now)
 					client.Update(context.TODO(), b)
-					_, err = r.Reconcile(ctx, req)
+					_, err = r.Reconcile(context.TODO(), req)
 				})
 				It("should not give error", func() {
 					Ω(err).Should(BeNil())
@@ -230,13 +252,13 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 				})
 				It("should have 1 finalizer", func() {
 					config.DisableFinalizer = false
-					err = r.reconcileFinalizers(b)
+					err = r.reconcileFinalizers(context.TODO(), b)
 					Expect(b.ObjectMeta.Finalizers).To(HaveLen(1))
 					Expect(err).NotTo(HaveOccurred())
 				})
 				It("should have 0 finalizer", func() {
 					config.DisableFinalizer = true
-					err = r.reconcileFinalizers(b)
+					err = r.reconcileFinalizers(context.TODO(), b)
 					Expect(b.ObjectMeta.Finalizers).To(HaveLen(0))
 					Expect(err).NotTo(HaveOccurred())
 				})
@@ -245,7 +267,7 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 				BeforeEach(func() {
 					b.WithDefaults()
 					s := MakeBookieStatefulSet(b)
-					err = r.syncStatefulSetExternalServices(s)
+					err = r.syncStatefulSetExternalServices(context.TODO(), s)
 				})
 				It("should not give error", func() {
 					Ω(err).Should(BeNil())
@@ -267,7 +289,7 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					r.Client.Create(context.TODO(), sts)
 					name := b.Name
 					_ = r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: b.Namespace}, sts)
-					err = r.syncStatefulSetExternalServices(sts)
+					err = r.syncStatefulSetExternalServices(context.TODO(), sts)
 				})
 				It("should not give error", func() {
 					Ω(err).Should(BeNil())
@@ -289,7 +311,7 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					r.Client.Create(context.TODO(), sts)
 					name := b.Name
 					_ = r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: b.Namespace}, sts)
-					err = r.syncStatefulSetPvc(sts)
+					err = r.syncStatefulSetPvc(context.TODO(), b, sts)
 				})
 				It("should not give error", func() {
 					Ω(err).Should(BeNil())
@@ -305,7 +327,7 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 					foundBookkeeper.Spec.Version = foundBookkeeper.Status.GetLastVersion()
 					foundBookkeeper.Status.SetErrorConditionTrue("UpgradeFailed", " ")
 					r.Client.Update(context.TODO(), foundBookkeeper)
-					err = r.rollbackFailedUpgrade(foundBookkeeper)
+					err = r.rollbackFailedUpgrade(context.TODO(), foundBookkeeper)
 				})
 				It("should not give error", func() {
 					Ω(err).Should(BeNil())
@@ -315,9 +337,10 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 				var str1, str2 string
 				BeforeEach(func() {
 					b.WithDefaults()
+					r.Zookeeper = &fakeZookeeper{deleteErr: fmt.Errorf("zk: could not connect to a server")}
 					config.DisableFinalizer = false
 					client.Update(context.TODO(), b)
-					r.reconcileFinalizers(b)
+					r.reconcileFinalizers(context.TODO(), b)
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookkeeper)
 					str1, str2 = getFinalizerAndClusterName(foundBookkeeper.Finalizers)
 				})
@@ -331,9 +354,9 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 			Context("Should have Reconcile Result false when request namespace does not contain bk cluster", func() {
 				BeforeEach(func() {
 					client = fake.NewFakeClient(b)
-					r = &BookkeeperClusterReconciler{Client: client, Scheme: s}
+					r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
 					req.NamespacedName.Namespace = "temp"
-					res, err = r.Reconcile(ctx, req)
+					res, err = r.Reconcile(context.TODO(), req)
 				})
 				It("should have false in reconcile result", func() {
 					Ω(res.Requeue).To(Equal(false))
@@ -343,13 +366,14 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 			Context("reconcileFinalizers", func() {
 				BeforeEach(func() {
 					b.WithDefaults()
+					r.Zookeeper = &fakeZookeeper{deleteErr: fmt.Errorf("zk: could not connect to a server")}
 					config.DisableFinalizer = false
 					client.Update(context.TODO(), b)
-					err = r.reconcileFinalizers(b)
+					err = r.reconcileFinalizers(context.TODO(), b)
 					now := metav1.Now()
 					b.SetDeletionTimestamp(&now)
 					client.Update(context.TODO(), b)
-					err = r.reconcileFinalizers(b)
+					err = r.reconcileFinalizers(context.TODO(), b)
 
 				})
 				It("should give error due to failure in connecting to zookeeper", func() {
@@ -359,12 +383,583 @@ var _ = Describe("BookkeeperCluster Controller", func() {
 			Context("cleanUpZookeeperMeta", func() {
 				BeforeEach(func() {
 					b.WithDefaults()
-					err = r.cleanUpZookeeperMeta(b, "pravega")
+					r.Zookeeper = &fakeZookeeper{deleteErr: fmt.Errorf("zk: could not connect to a server")}
+					err = r.cleanUpZookeeperMeta(context.TODO(), b, "pravega")
 				})
 				It("should give error", func() {
 					Ω(err).ShouldNot(BeNil())
 				})
 			})
 		})
+		Context("With local storage", func() {
+			var (
+				client client.Client
+				err    error
+			)
+			BeforeEach(func() {
+				b.Spec = v1alpha1.BookkeeperClusterSpec{
+					Replicas: 3,
+					Storage: &v1alpha1.BookkeeperStorageSpec{
+						Mode: v1alpha1.StorageModeLocal,
+						Local: &v1alpha1.LocalStorageSpec{
+							Nodes:       []string{"node-0", "node-1", "node-2"},
+							LedgerPath:  "/mnt/ledgers",
+							JournalPath: "/mnt/journal",
+							IndexPath:   "/mnt/index",
+						},
+					},
+				}
+				b.WithDefaults()
+				node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-0"}}
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, node).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+			})
+			Context("reconcileLocalPersistentVolumes", func() {
+				var pvList *corev1.PersistentVolumeList
+				BeforeEach(func() {
+					err = r.reconcileLocalPersistentVolumes(context.TODO(), b)
+					pvList = &corev1.PersistentVolumeList{}
+					client.List(context.TODO(), pvList)
+				})
+				It("should not give error", func() {
+					Ω(err).Should(BeNil())
+				})
+				It("should create the local volumes", func() {
+					Ω(pvList.Items).Should(HaveLen(9))
+				})
+				It("should delete the volumes of a deleted cluster", func() {
+					err = r.deleteLocalPersistentVolumes(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					client.List(context.TODO(), pvList)
+					Ω(pvList.Items).Should(BeEmpty())
+				})
+				It("should delete released volumes of removed bookies", func() {
+					b.Spec.Replicas = 2
+					for i := range pvList.Items {
+						pvList.Items[i].Status.Phase = corev1.VolumeReleased
+						client.Status().Update(context.TODO(), &pvList.Items[i])
+					}
+					err = r.reconcileLocalPersistentVolumes(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					client.List(context.TODO(), pvList)
+					Ω(pvList.Items).Should(HaveLen(6))
+				})
+			})
+			Context("getStrandedBookies", func() {
+				var stranded []string
+				BeforeEach(func() {
+					r.reconcileLocalPersistentVolumes(context.TODO(), b)
+					stranded, err = r.getStrandedBookies(context.TODO(), b)
+				})
+				It("should report the bookies whose node is gone", func() {
+					Ω(err).Should(BeNil())
+					Ω(stranded).Should(Equal([]string{"example-bookie-1", "example-bookie-2"}))
+				})
+				It("should ignore the volumes of removed bookies", func() {
+					b.Spec.Replicas = 2
+					stranded, err = r.getStrandedBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					Ω(stranded).Should(Equal([]string{"example-bookie-1"}))
+				})
+				It("should set the storage nodes condition", func() {
+					b.Status.Init()
+					err = r.reconcileClusterStatus(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionStorageNodesAvailable)
+					Ω(condition.Status).Should(Equal(corev1.ConditionFalse))
+					Ω(b.Status.Members.Stranded).Should(HaveLen(2))
+				})
+			})
+		})
+		Context("With external access", func() {
+			var (
+				client  client.Client
+				err     error
+				svcList *corev1.ServiceList
+			)
+			BeforeEach(func() {
+				b.Spec = v1alpha1.BookkeeperClusterSpec{
+					Replicas: 3,
+					ExternalAccess: &v1alpha1.ExternalAccess{
+						Enabled: true,
+					},
+				}
+				b.WithDefaults()
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+				err = r.reconcileService(context.TODO(), b)
+				svcList = &corev1.ServiceList{}
+				client.List(context.TODO(), svcList)
+			})
+			It("should create the headless service and a service per bookie", func() {
+				Ω(err).Should(BeNil())
+				Ω(svcList.Items).Should(HaveLen(4))
+			})
+			It("should update the type and annotations of existing services", func() {
+				b.Spec.ExternalAccess.Type = corev1.ServiceTypeClusterIP
+				b.Spec.ExternalAccess.Annotations = map[string]string{"key": "value"}
+				err = r.reconcileService(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				svc := &corev1.Service{}
+				client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0", Namespace: b.Namespace}, svc)
+				Ω(svc.Spec.Type).Should(Equal(corev1.ServiceTypeClusterIP))
+				Ω(svc.Annotations).Should(HaveKeyWithValue("key", "value"))
+			})
+			It("should delete the services of removed bookies", func() {
+				sts := MakeBookieStatefulSet(b)
+				replicas := int32(1)
+				sts.Spec.Replicas = &replicas
+				err = r.syncStatefulSetExternalServices(context.TODO(), sts)
+				Ω(err).Should(BeNil())
+				client.List(context.TODO(), svcList)
+				Ω(svcList.Items).Should(HaveLen(2))
+			})
+		})
+		Context("With rack awareness", func() {
+			var (
+				client client.Client
+				err    error
+				cm     *corev1.ConfigMap
+			)
+			BeforeEach(func() {
+				b.Spec = v1alpha1.BookkeeperClusterSpec{
+					RackAware: &v1alpha1.RackAwareSpec{Enabled: true},
+				}
+				b.WithDefaults()
+				node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{
+					Name:   "node-0",
+					Labels: map[string]string{ZoneLabel: "zone-a"},
+				}}
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "example-bookie-0",
+						Namespace: b.Namespace,
+						Labels:    b.LabelsForBookie(),
+					},
+					Spec: corev1.PodSpec{NodeName: "node-0"},
+				}
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, node, pod).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+				err = r.reconcileRackConfigMap(context.TODO(), b)
+				cm = &corev1.ConfigMap{}
+			})
+			It("should write the zone of each bookie", func() {
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-racks", Namespace: b.Namespace}, cm)
+				Ω(err).Should(BeNil())
+				Ω(cm.Data["racks"]).Should(ContainSubstring("/zone-a"))
+			})
+			It("should delete the racks once rack awareness is disabled", func() {
+				b.Spec.RackAware.Enabled = false
+				err = r.reconcileRackConfigMap(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-racks", Namespace: b.Namespace}, cm)
+				Ω(errors.IsNotFound(err)).Should(BeTrue())
+			})
+		})
+		Context("With a bookie pod being restarted", func() {
+			var (
+				client client.Client
+				pod    *corev1.Pod
+			)
+			BeforeEach(func() {
+				podPollInterval = 10 * time.Millisecond
+				b.WithDefaults()
+				pod = &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "example-bookie-0",
+						Namespace: b.Namespace,
+						UID:       "old",
+						Labels:    b.LabelsForBookie(),
+					},
+				}
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, pod).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+			})
+			It("should wait for the pod to terminate", func() {
+				err := r.waitForPodTermination(context.TODO(), pod, 50*time.Millisecond)
+				Ω(err).ShouldNot(BeNil())
+				client.Delete(context.TODO(), pod)
+				err = r.waitForPodTermination(context.TODO(), pod, 50*time.Millisecond)
+				Ω(err).Should(BeNil())
+			})
+			It("should wait for the replacement pod to be ready", func() {
+				err := r.waitForPodReplacement(context.TODO(), pod, 50*time.Millisecond)
+				Ω(err).ShouldNot(BeNil())
+				client.Delete(context.TODO(), pod)
+				replacement := pod.DeepCopy()
+				replacement.ResourceVersion = ""
+				replacement.UID = "new"
+				replacement.Status.Conditions = []corev1.PodCondition{
+					{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+				}
+				client.Create(context.TODO(), replacement)
+				err = r.waitForPodReplacement(context.TODO(), pod, 50*time.Millisecond)
+				Ω(err).Should(BeNil())
+			})
+			It("should report terminating pods", func() {
+				sts := MakeBookieStatefulSet(b)
+				terminating, err := r.hasTerminatingPod(context.TODO(), sts)
+				Ω(err).Should(BeNil())
+				Ω(terminating).Should(BeFalse())
+				pod.Finalizers = []string{"example.com/test"}
+				client.Update(context.TODO(), pod)
+				client.Delete(context.TODO(), pod)
+				terminating, err = r.hasTerminatingPod(context.TODO(), sts)
+				Ω(err).Should(BeNil())
+				Ω(terminating).Should(BeTrue())
+			})
+			It("should give deleted pods their grace period to terminate", func() {
+				grace := int64(120)
+				b.Spec.TerminationGracePeriodSeconds = &grace
+				Ω(podTerminationTimeout(b)).Should(Equal(3 * time.Minute))
+			})
+		})
+		Context("With metrics", func() {
+			var (
+				client client.Client
+				err    error
+				sm     *unstructured.Unstructured
+			)
+			BeforeEach(func() {
+				b.Spec = v1alpha1.BookkeeperClusterSpec{
+					Metrics: &v1alpha1.MetricsSpec{Enabled: true},
+				}
+				b.WithDefaults()
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+				err = r.reconcileMonitoring(context.TODO(), b)
+				sm = &unstructured.Unstructured{}
+				sm.SetGroupVersionKind(ServiceMonitorGVK)
+			})
+			It("should create the service monitor", func() {
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie", Namespace: b.Namespace}, sm)
+				Ω(err).Should(BeNil())
+			})
+			It("should delete the service monitor once metrics are disabled", func() {
+				b.Spec.Metrics.Enabled = false
+				err = r.reconcileMonitoring(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie", Namespace: b.Namespace}, sm)
+				Ω(errors.IsNotFound(err)).Should(BeTrue())
+			})
+			It("should add the metrics port to an existing headless service", func() {
+				b.Spec.Metrics.Enabled = false
+				err = r.reconcileService(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				b.Spec.Metrics.Enabled = true
+				err = r.reconcileService(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				svc := &corev1.Service{}
+				client.Get(context.TODO(), types.NamespacedName{Name: b.HeadlessServiceNameForBookie(), Namespace: b.Namespace}, svc)
+				Ω(svc.Spec.Ports).Should(HaveLen(2))
+			})
+		})
+		Context("Metadata initialization", func() {
+			var (
+				client      client.Client
+				err         error
+				initialized bool
+			)
+			BeforeEach(func() {
+				b.Status.InstanceID = ""
+				b.WithDefaults()
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+				initialized, err = r.reconcileMetadata(context.TODO(), b)
+			})
+			It("should create the metadata init job before deploying bookies", func() {
+				Ω(err).Should(BeNil())
+				Ω(initialized).Should(BeFalse())
+				job := &batchv1.Job{}
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: b.Namespace}, job)
+				Ω(err).Should(BeNil())
+			})
+			It("should record the instance ID reported by the job", func() {
+				job := &batchv1.Job{}
+				client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: b.Namespace}, job)
+				job.Status.Succeeded = 1
+				client.Update(context.TODO(), job)
+				pod := &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "example-bookie-metadata-init-abcde",
+						Namespace: b.Namespace,
+						Labels:    map[string]string{"job-name": job.Name},
+					},
+					Status: corev1.PodStatus{
+						Phase: corev1.PodSucceeded,
+						ContainerStatuses: []corev1.ContainerStatus{
+							{State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: "instance-1"}}},
+						},
+					},
+				}
+				client.Create(context.TODO(), pod)
+				initialized, err = r.reconcileMetadata(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				Ω(initialized).Should(BeTrue())
+				foundBookkeeper := &v1alpha1.BookkeeperCluster{}
+				client.Get(context.TODO(), types.NamespacedName{Name: b.Name, Namespace: b.Namespace}, foundBookkeeper)
+				Ω(foundBookkeeper.Status.InstanceID).Should(Equal("instance-1"))
+				_, condition := foundBookkeeper.Status.GetClusterCondition(v1alpha1.ClusterConditionMetadataInitialized)
+				Ω(condition.Status).Should(Equal(corev1.ConditionTrue))
+			})
+			It("should refuse pvcs holding data of another instance", func() {
+				b.Status.InstanceID = "instance-1"
+				pvc := &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:        "ledger-example-bookie-0",
+						Namespace:   b.Namespace,
+						Labels:      b.LabelsForBookie(),
+						Annotations: map[string]string{InstanceIDAnnotation: "instance-0"},
+					},
+				}
+				client.Create(context.TODO(), pvc)
+				initialized, err = r.reconcileMetadata(context.TODO(), b)
+				Ω(err).ShouldNot(BeNil())
+				Ω(err.Error()).Should(ContainSubstring("instance-0"))
+				_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionMetadataInitialized)
+				Ω(condition.Reason).Should(Equal("InstanceIDMismatch"))
+			})
+			It("should leave pvcs created before the instance ID was known unannotated", func() {
+				b.Status.InstanceID = "instance-1"
+				pvc := &corev1.PersistentVolumeClaim{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "ledger-example-bookie-0",
+						Namespace: b.Namespace,
+						Labels:    b.LabelsForBookie(),
+					},
+				}
+				client.Create(context.TODO(), pvc)
+				initialized, err = r.reconcileMetadata(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				Ω(initialized).Should(BeTrue())
+				client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: pvc.Namespace}, pvc)
+				Ω(pvc.Annotations).ShouldNot(HaveKey(InstanceIDAnnotation))
+			})
+			It("should annotate the claims of new stateful sets with the instance ID", func() {
+				b.Status.InstanceID = "instance-1"
+				sts := MakeBookieStatefulSet(b)
+				for _, pvc := range sts.Spec.VolumeClaimTemplates {
+					Ω(pvc.Annotations[InstanceIDAnnotation]).Should(Equal("instance-1"))
+				}
+			})
+			It("should delete a failed job so that it runs again", func() {
+				job := &batchv1.Job{}
+				client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: b.Namespace}, job)
+				job.Status.Conditions = []batchv1.JobCondition{
+					{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+				}
+				client.Update(context.TODO(), job)
+				initialized, err = r.reconcileMetadata(context.TODO(), b)
+				Ω(err).ShouldNot(BeNil())
+				Ω(initialized).Should(BeFalse())
+				_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionMetadataInitialized)
+				Ω(condition.Reason).Should(Equal("BackoffLimitExceeded"))
+				err = client.Get(context.TODO(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, job)
+				Ω(errors.IsNotFound(err)).Should(BeTrue())
+				initialized, err = r.reconcileMetadata(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				err = client.Get(context.TODO(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, job)
+				Ω(err).Should(BeNil())
+			})
+		})
+		Context("Cookie mismatch", func() {
+			var (
+				client client.Client
+				err    error
+				pod    *corev1.Pod
+			)
+			BeforeEach(func() {
+				b.WithDefaults()
+				pod = &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "example-bookie-0",
+						Namespace: b.Namespace,
+						Labels:    b.LabelsForBookie(),
+						UID:       "failed",
+					},
+					Spec: MakeBookiePodTemplate(b).Spec,
+					Status: corev1.PodStatus{
+						ContainerStatuses: []corev1.ContainerStatus{
+							{
+								Name: "bookie",
+								State: corev1.ContainerState{
+									Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+								},
+								LastTerminationState: corev1.ContainerState{
+									Terminated: &corev1.ContainerStateTerminated{
+										ExitCode: 1,
+										Message:  "BookieException$InvalidCookieException: Cookie mismatch",
+									},
+								},
+							},
+						},
+					},
+				}
+			})
+			JustBeforeEach(func() {
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, pod).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+			})
+			It("should set the condition of the bookie", func() {
+				err = r.reconcileClusterStatus(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				condition := b.Status.GetBookieCondition("example-bookie-0", v1alpha1.BookieConditionCookieMismatch)
+				Ω(condition).ShouldNot(BeNil())
+				Ω(condition.Message).Should(ContainSubstring("InvalidCookieException"))
+			})
+			It("should not repair the bookie unless auto repair is enabled", func() {
+				err = r.repairBookies(context.TODO(), b)
+				Ω(err).Should(BeNil())
+				job := &batchv1.Job{}
+				err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0-repair", Namespace: b.Namespace}, job)
+				Ω(errors.IsNotFound(err)).Should(BeTrue())
+			})
+			Context("With auto repair", func() {
+				BeforeEach(func() {
+					b.Spec.AutoRepair = true
+				})
+				It("should format the bookie once its pod is gone", func() {
+					err = r.repairBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					job := &batchv1.Job{}
+					err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0-repair", Namespace: b.Namespace}, job)
+					Ω(err).Should(BeNil())
+					Ω(*job.Spec.Suspend).Should(BeTrue())
+					err = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, &corev1.Pod{})
+					Ω(errors.IsNotFound(err)).Should(BeTrue())
+
+					// the pod created by the stateful set meanwhile does not hold the job
+					recreated := pod.DeepCopy()
+					recreated.ResourceVersion = ""
+					recreated.UID = "recreated"
+					client.Create(context.TODO(), recreated)
+					err = r.repairBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					client.Get(context.TODO(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, job)
+					Ω(*job.Spec.Suspend).Should(BeFalse())
+				})
+				It("should not format the bookie while the failed pod is terminating", func() {
+					client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: pod.Namespace}, pod)
+					pod.Finalizers = []string{"example.com/test"}
+					client.Update(context.TODO(), pod)
+					err = r.repairBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					err = r.repairBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					job := &batchv1.Job{}
+					client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0-repair", Namespace: b.Namespace}, job)
+					Ω(*job.Spec.Suspend).Should(BeTrue())
+				})
+				It("should record a failed repair and not retry it", func() {
+					err = r.repairBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					job := &batchv1.Job{}
+					client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0-repair", Namespace: b.Namespace}, job)
+					job.Status.Conditions = []batchv1.JobCondition{
+						{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"},
+					}
+					client.Update(context.TODO(), job)
+					pod.ResourceVersion = ""
+					pod.UID = "recreated"
+					client.Create(context.TODO(), pod)
+					for i := 0; i < 2; i++ {
+						err = r.repairBookies(context.TODO(), b)
+						Ω(err).Should(BeNil())
+					}
+					condition := b.Status.GetBookieCondition("example-bookie-0", v1alpha1.BookieConditionRepairFailed)
+					Ω(condition).ShouldNot(BeNil())
+					Ω(condition.Reason).Should(Equal("BackoffLimitExceeded"))
+					client.Get(context.TODO(), types.NamespacedName{Name: job.Name, Namespace: job.Namespace}, job)
+					Ω(job.Status.Conditions).Should(HaveLen(1))
+					Ω(r.reconcileClusterStatus(context.TODO(), b)).Should(BeNil())
+					Ω(b.Status.GetBookieCondition("example-bookie-0", v1alpha1.BookieConditionRepairFailed)).ShouldNot(BeNil())
+
+					// deleting the job retries the repair
+					client.Delete(context.TODO(), job)
+					err = r.repairBookies(context.TODO(), b)
+					Ω(err).Should(BeNil())
+					Ω(b.Status.GetBookieCondition("example-bookie-0", v1alpha1.BookieConditionRepairFailed)).Should(BeNil())
+				})
+				It("should not retry a cookie the operator may not delete", func() {
+					zk := &fakeZookeeper{deleteErr: fmt.Errorf("failed to delete cookie (example-bookie-0:3181): %w", gozk.ErrNoAuth)}
+					r.Zookeeper = zk
+					r.repairBookies(context.TODO(), b)
+					r.repairBookies(context.TODO(), b)
+					job := &batchv1.Job{}
+					client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0-repair", Namespace: b.Namespace}, job)
+					job.Status.Succeeded = 1
+					client.Update(context.TODO(), job)
+					for i := 0; i < 2; i++ {
+						err = r.repairBookies(context.TODO(), b)
+						Ω(err).Should(BeNil())
+					}
+					Ω(zk.deletedCookies).Should(Equal([]string{"example-bookie-0"}))
+					condition := b.Status.GetBookieCondition("example-bookie-0", v1alpha1.BookieConditionRepairFailed)
+					Ω(condition).ShouldNot(BeNil())
+					Ω(condition.Reason).Should(Equal("ZookeeperNoAuth"))
+					Ω(condition.Message).Should(ContainSubstring("not allowed to delete the cookie of bookie example-bookie-0"))
+				})
+				It("should read the ledgers root from the options", func() {
+					b.Spec.Options["zkLedgersRootPath"] = "/ledgers"
+					path, err := ledgersRootPath(context.TODO(), r.Client, b)
+					Ω(err).Should(BeNil())
+					Ω(path).Should(Equal("/ledgers"))
+					delete(b.Spec.Options, "zkLedgersRootPath")
+					path, _ = ledgersRootPath(context.TODO(), r.Client, b)
+					Ω(path).Should(Equal("/pravega/pravega-cluster/bookkeeper/ledgers"))
+				})
+			})
+		})
+		Context("With several clusters reconciled concurrently", func() {
+			var (
+				client client.Client
+				names  = []string{"bk1", "bk2", "bk3", "bk4"}
+			)
+			BeforeEach(func() {
+				var objects []runtime.Object
+				for _, name := range names {
+					bk := b.DeepCopy()
+					bk.Name = name
+					objects = append(objects, bk)
+				}
+				client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(objects...).Build()
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100), MaxConcurrentReconciles: len(names)}
+				var wg sync.WaitGroup
+				for _, name := range names {
+					wg.Add(1)
+					go func(name string) {
+						defer GinkgoRecover()
+						defer wg.Done()
+						req := reconcile.Request{NamespacedName: types.NamespacedName{Name: name, Namespace: Namespace}}
+						// defaults, then deployment
+						for i := 0; i < 2; i++ {
+							_, err := r.Reconcile(context.TODO(), req)
+							Ω(err).Should(BeNil())
+						}
+					}(name)
+				}
+				wg.Wait()
+			})
+			It("should deploy the bookies of every cluster", func() {
+				for _, name := range names {
+					sts := &appsv1.StatefulSet{}
+					err := client.Get(context.TODO(), types.NamespacedName{Name: name + "-bookie", Namespace: Namespace}, sts)
+					Ω(err).Should(BeNil())
+					Ω(sts.OwnerReferences).Should(HaveLen(1))
+					Ω(sts.OwnerReferences[0].Name).Should(Equal(name))
+				}
+			})
+			It("should keep the configuration of the clusters apart", func() {
+				for _, name := range names {
+					cm := &corev1.ConfigMap{}
+					err := client.Get(context.TODO(), types.NamespacedName{Name: name + "-bookie", Namespace: Namespace}, cm)
+					Ω(err).Should(BeNil())
+					Ω(cm.OwnerReferences).Should(HaveLen(1))
+					Ω(cm.OwnerReferences[0].Name).Should(Equal(name))
+				}
+			})
+		})
 	})
 })
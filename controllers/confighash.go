@@ -0,0 +1,208 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"sort"
+
+	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+// ConfigHashAnnotation holds, on the bookie pod template, the hash of the
+// ConfigMaps and Secrets provided by the user the bookies read their
+// environment from. The bookies are restarted when it changes.
+const ConfigHashAnnotation = "bookkeeper.pravega.io/config-hash"
+
+// ConfigWatchLabel marks the ConfigMaps and Secrets the operator watches. The
+// operator labels the objects referenced by the bookies, so that the bookies
+// are restarted as soon as one of them changes.
+const ConfigWatchLabel = "bookkeeper.pravega.io/watch"
+
+// NewCacheFunc restricts the ConfigMaps and Secrets of the caches built by
+// newCache, or by cache.New if nil, to the ones with ConfigWatchLabel. The
+// client must not read them from the cache.
+func NewCacheFunc(newCache cache.NewCacheFunc) cache.NewCacheFunc {
+	if newCache == nil {
+		newCache = cache.New
+	}
+	selector := cache.ObjectSelector{Label: labels.SelectorFromSet(labels.Set{ConfigWatchLabel: "true"})}
+	return func(config *rest.Config, opts cache.Options) (cache.Cache, error) {
+		opts.SelectorsByObject = cache.SelectorsByObject{
+			&corev1.ConfigMap{}: selector,
+			&corev1.Secret{}:    selector,
+		}
+		return newCache(config, opts)
+	}
+}
+
+// bookieConfigReferences returns the names of the ConfigMaps and Secrets
+// referenced by the environment of the bookie pod containers, except the
+// ConfigMap generated by the operator, and of the ZooKeeper client Secrets
+func bookieConfigReferences(bk *bookkeeperv1alpha1.BookkeeperCluster) (configMaps []string, secrets []string) {
+	podSpec := makeBookiePodSpec(bk)
+	cms := map[string]bool{}
+	scs := map[string]bool{}
+	containers := append(append([]corev1.Container{}, podSpec.InitContainers...), podSpec.Containers...)
+	for _, c := range containers {
+		for _, env := range c.EnvFrom {
+			if env.ConfigMapRef != nil {
+				cms[env.ConfigMapRef.Name] = true
+			}
+			if env.SecretRef != nil {
+				scs[env.SecretRef.Name] = true
+			}
+		}
+		for _, env := range c.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if env.ValueFrom.ConfigMapKeyRef != nil {
+				cms[env.ValueFrom.ConfigMapKeyRef.Name] = true
+			}
+			if env.ValueFrom.SecretKeyRef != nil {
+				scs[env.ValueFrom.SecretKeyRef.Name] = true
+			}
+		}
+	}
+	if zkTLS := bk.ZookeeperTLS(); zkTLS != nil {
+		scs[zkTLS.SecretName] = true
+	}
+	if auth := bk.ZookeeperAuth(); auth != nil {
+		scs[auth.SecretName] = true
+	}
+	delete(cms, util.ConfigMapNameForBookie(bk.Name))
+	return sortedKeys(cms), sortedKeys(scs)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// configHash returns the hash of the data of the ConfigMaps and Secrets
+// referenced by the bookie pods, or an empty string when there are none.
+// Missing objects are hashed by name only, so that their creation changes
+// the hash.
+func (r *BookkeeperClusterReconciler) configHash(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (string, error) {
+	configMaps, secrets := bookieConfigReferences(bk)
+	if len(configMaps) == 0 && len(secrets) == 0 {
+		return "", nil
+	}
+	h := sha256.New()
+	for _, name := range configMaps {
+		cm := &corev1.ConfigMap{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, cm)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get configmap (%s): %v", name, err)
+		}
+		if err == nil {
+			err = r.watchConfigObject(ctx, cm)
+			if err != nil {
+				return "", fmt.Errorf("failed to label configmap (%s): %v", name, err)
+			}
+		}
+		fmt.Fprintf(h, "configmap %s\n", name)
+		hashData(h, cm.Data, cm.BinaryData)
+	}
+	for _, name := range secrets {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, secret)
+		if err != nil && !errors.IsNotFound(err) {
+			return "", fmt.Errorf("failed to get secret (%s): %v", name, err)
+		}
+		if err == nil {
+			err = r.watchConfigObject(ctx, secret)
+			if err != nil {
+				return "", fmt.Errorf("failed to label secret (%s): %v", name, err)
+			}
+		}
+		fmt.Fprintf(h, "secret %s\n", name)
+		hashData(h, nil, secret.Data)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// watchConfigObject adds ConfigWatchLabel to a referenced ConfigMap or
+// Secret, which is only watched once labeled
+func (r *BookkeeperClusterReconciler) watchConfigObject(ctx context.Context, obj client.Object) error {
+	if obj.GetLabels()[ConfigWatchLabel] == "true" {
+		return nil
+	}
+	patch := client.MergeFrom(obj.DeepCopyObject().(client.Object))
+	objLabels := obj.GetLabels()
+	if objLabels == nil {
+		objLabels = map[string]string{}
+	}
+	objLabels[ConfigWatchLabel] = "true"
+	obj.SetLabels(objLabels)
+	return r.Client.Patch(ctx, obj, patch)
+}
+
+func hashData(h hash.Hash, data map[string]string, binaryData map[string][]byte) {
+	all := map[string]bool{}
+	for k := range data {
+		all[k] = true
+	}
+	for k := range binaryData {
+		all[k] = true
+	}
+	for _, k := range sortedKeys(all) {
+		fmt.Fprintf(h, "%q=%q%q\n", k, data[k], binaryData[k])
+	}
+}
+
+// clustersReferencing maps a ConfigMap or Secret to the BookkeeperClusters
+// whose bookie pods read their environment from it
+func (r *BookkeeperClusterReconciler) clustersReferencing(obj client.Object) []reconcile.Request {
+	ctx := context.TODO()
+	bkList := &bookkeeperv1alpha1.BookkeeperClusterList{}
+	err := r.Client.List(ctx, bkList, client.InNamespace(obj.GetNamespace()))
+	if err != nil {
+		log.FromContext(ctx).Error(err, "failed to list bookkeeper clusters", "namespace", obj.GetNamespace())
+		return nil
+	}
+	_, isSecret := obj.(*corev1.Secret)
+	var requests []reconcile.Request
+	for i := range bkList.Items {
+		bk := &bkList.Items[i]
+		bk.WithDefaults()
+		configMaps, secrets := bookieConfigReferences(bk)
+		names := configMaps
+		if isSecret {
+			names = secrets
+		}
+		for _, name := range names {
+			if name == obj.GetName() {
+				requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: bk.Name, Namespace: bk.Namespace}})
+				break
+			}
+		}
+	}
+	return requests
+}
@@ -0,0 +1,179 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Referenced configuration", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+	)
+
+	var (
+		s      = scheme.Scheme
+		r      *BookkeeperClusterReconciler
+		b      *v1alpha1.BookkeeperCluster
+		cm     *corev1.ConfigMap
+		secret *corev1.Secret
+		client client.Client
+	)
+
+	BeforeEach(func() {
+		b = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      Name,
+				Namespace: Namespace,
+			},
+			Spec: v1alpha1.BookkeeperClusterSpec{
+				EnvVars: "bookie-env",
+				Env: []corev1.EnvVar{
+					{
+						Name: "PASSWORD",
+						ValueFrom: &corev1.EnvVarSource{
+							SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "bookie-secret"},
+								Key:                  "password",
+							},
+						},
+					},
+				},
+			},
+		}
+		b.WithDefaults()
+		b.Status.CurrentVersion = b.Spec.Version
+		s.AddKnownTypes(v1alpha1.GroupVersion, b, &v1alpha1.BookkeeperClusterList{})
+		cm = &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "bookie-env", Namespace: Namespace},
+			Data:       map[string]string{"BK_key": "value"},
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "bookie-secret", Namespace: Namespace},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		}
+		client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, cm, secret).Build()
+		r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+	})
+
+	Context("bookieConfigReferences", func() {
+		It("should list the referenced objects except the generated configmap", func() {
+			configMaps, secrets := bookieConfigReferences(b)
+			Ω(configMaps).Should(Equal([]string{"bookie-env"}))
+			Ω(secrets).Should(Equal([]string{"bookie-secret"}))
+		})
+	})
+
+	Context("configHash", func() {
+		It("should be empty without referenced objects", func() {
+			b.Spec.EnvVars = ""
+			b.Spec.Env = nil
+			hash, err := r.configHash(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(hash).Should(BeEmpty())
+		})
+		It("should change with the data of the referenced objects", func() {
+			hash1, err := r.configHash(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			// the secret was labeled meanwhile
+			Ω(client.Get(context.TODO(), types.NamespacedName{Name: secret.Name, Namespace: Namespace}, secret)).Should(Succeed())
+			secret.Data["password"] = []byte("changed")
+			Ω(client.Update(context.TODO(), secret)).Should(Succeed())
+			hash2, err := r.configHash(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(hash2).ShouldNot(Equal(hash1))
+		})
+	})
+
+	Context("deployBookie", func() {
+		It("should update the hash on the pod template when the configmap changes", func() {
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			sts := &appsv1.StatefulSet{}
+			key := types.NamespacedName{Name: "example-bookie", Namespace: Namespace}
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			hash1 := sts.Spec.Template.Annotations[ConfigHashAnnotation]
+			Ω(hash1).ShouldNot(BeEmpty())
+
+			Ω(client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: Namespace}, cm)).Should(Succeed())
+			cm.Data["BK_key"] = "changed"
+			Ω(client.Update(context.TODO(), cm)).Should(Succeed())
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			Ω(sts.Spec.Template.Annotations[ConfigHashAnnotation]).ShouldNot(Equal(hash1))
+		})
+		It("should watch the referenced objects without the watch label", func() {
+			Ω(secret.Labels).ShouldNot(HaveKey(ConfigWatchLabel))
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			found := &corev1.Secret{}
+			Ω(client.Get(context.TODO(), types.NamespacedName{Name: "bookie-secret", Namespace: Namespace}, found)).Should(Succeed())
+			Ω(found.Labels).Should(HaveKeyWithValue(ConfigWatchLabel, "true"))
+			Ω(found.Data["password"]).Should(Equal([]byte("secret")))
+
+			// the change of the now labeled secret triggers a reconcile
+			found.Data["password"] = []byte("changed")
+			Ω(client.Update(context.TODO(), found)).Should(Succeed())
+			requests := r.clustersReferencing(found)
+			Ω(requests).Should(HaveLen(1))
+			sts := &appsv1.StatefulSet{}
+			key := types.NamespacedName{Name: "example-bookie", Namespace: Namespace}
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			hash1 := sts.Spec.Template.Annotations[ConfigHashAnnotation]
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			Ω(sts.Spec.Template.Annotations[ConfigHashAnnotation]).ShouldNot(Equal(hash1))
+		})
+		It("should only record the first hash of an existing cluster on the statefulset", func() {
+			sts := MakeBookieStatefulSet(b)
+			Ω(client.Create(context.TODO(), sts)).Should(Succeed())
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			key := types.NamespacedName{Name: "example-bookie", Namespace: Namespace}
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			Ω(sts.Annotations[ConfigHashAnnotation]).ShouldNot(BeEmpty())
+			Ω(sts.Spec.Template.Annotations).ShouldNot(HaveKey(ConfigHashAnnotation))
+
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			Ω(sts.Spec.Template.Annotations).ShouldNot(HaveKey(ConfigHashAnnotation))
+
+			Ω(client.Get(context.TODO(), types.NamespacedName{Name: cm.Name, Namespace: Namespace}, cm)).Should(Succeed())
+			cm.Data["BK_key"] = "changed"
+			Ω(client.Update(context.TODO(), cm)).Should(Succeed())
+			Ω(r.deployBookie(context.TODO(), b)).Should(Succeed())
+			Ω(client.Get(context.TODO(), key, sts)).Should(Succeed())
+			Ω(sts.Spec.Template.Annotations[ConfigHashAnnotation]).ShouldNot(BeEmpty())
+			Ω(sts.Spec.Template.Annotations[ConfigHashAnnotation]).Should(Equal(sts.Annotations[ConfigHashAnnotation]))
+		})
+	})
+
+	Context("clustersReferencing", func() {
+		It("should enqueue the clusters referencing the object", func() {
+			requests := r.clustersReferencing(secret)
+			Ω(requests).Should(HaveLen(1))
+			Ω(requests[0].Name).Should(Equal(Name))
+		})
+		It("should ignore the objects not referenced by any cluster", func() {
+			other := &corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "bookie-secret", Namespace: Namespace}}
+			Ω(r.clustersReferencing(other)).Should(BeEmpty())
+		})
+	})
+})
@@ -11,13 +11,59 @@
 package controllers
 
 import (
+	"context"
 	"testing"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
 )
 
 func TestBookkeeperAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 	RunSpecs(t, "BookkeeperCluster Controller Tests")
 }
+
+// fakeZookeeper replaces ZooKeeper in the tests, which have none to connect to
+type fakeZookeeper struct {
+	checkErr error
+
+	readWrite []string
+	readOnly  []string
+	listErr   error
+	block     bool
+
+	znodes []string
+	listed []string
+
+	deleteErr       error
+	deletedClusters []string
+	deletedCookies  []string
+}
+
+func (zk *fakeZookeeper) Check(*util.ZookeeperConfig) error {
+	return zk.checkErr
+}
+
+func (zk *fakeZookeeper) ListRegisteredBookies(ctx context.Context, _ *util.ZookeeperConfig, _ string) ([]string, []string, error) {
+	if zk.block {
+		<-ctx.Done()
+		return nil, nil, ctx.Err()
+	}
+	return zk.readWrite, zk.readOnly, zk.listErr
+}
+
+func (zk *fakeZookeeper) ListAllZnodes(_ *util.ZookeeperConfig, pravegaClusterName string) ([]string, error) {
+	zk.listed = append(zk.listed, pravegaClusterName)
+	return zk.znodes, nil
+}
+
+func (zk *fakeZookeeper) DeleteAllZnodes(_ context.Context, _ *util.ZookeeperConfig, pravegaClusterName string) error {
+	zk.deletedClusters = append(zk.deletedClusters, pravegaClusterName)
+	return zk.deleteErr
+}
+
+func (zk *fakeZookeeper) DeleteBookieCookie(_ context.Context, _ *util.ZookeeperConfig, _ string, bookie string) error {
+	zk.deletedCookies = append(zk.deletedCookies, bookie)
+	return zk.deleteErr
+}
@@ -0,0 +1,64 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/controller/config"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// DryRunAnnotation set to "true" puts the cluster in dry run mode: the
+// destructive operations are logged and recorded as events instead of being
+// run
+const DryRunAnnotation = "bookkeeper.pravega.io/dry-run"
+
+// isDryRun returns true if the destructive operations on the cluster should
+// only be reported, with the annotation or the --dry-run flag
+func isDryRun(bk *bookkeeperv1alpha1.BookkeeperCluster) bool {
+	return config.DryRun || bk.Annotations[DryRunAnnotation] == "true"
+}
+
+// dryRun reports the given operation and returns true if the cluster is in
+// dry run mode, in which case the caller skips the operation
+func (r *BookkeeperClusterReconciler) dryRun(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, format string, args ...interface{}) bool {
+	if !isDryRun(bk) {
+		return false
+	}
+	message := "Dry run: would " + fmt.Sprintf(format, args...)
+	log.FromContext(ctx).Info(message)
+	r.Recorder.Event(bk, corev1.EventTypeNormal, EventReasonDryRun, message)
+	return true
+}
+
+// planZookeeperCleanup reports the znodes the finalizer of the deleted
+// cluster would remove. The finalizer is kept, so the cleanup runs once the
+// dry run mode is turned off.
+func (r *BookkeeperClusterReconciler) planZookeeperCleanup(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, pravegaClusterName string) error {
+	zkConfig, err := r.zookeeperConfig(ctx, bk)
+	if err != nil {
+		return err
+	}
+	znodes, err := r.zookeeper().ListAllZnodes(zkConfig, pravegaClusterName)
+	if err != nil {
+		return fmt.Errorf("failed to list zookeeper znodes for (%s): %v", bk.Name, err)
+	}
+	root := fmt.Sprintf("/%s/%s", util.PravegaPath, pravegaClusterName)
+	log.FromContext(ctx).Info("Dry run: would delete zookeeper znodes", "path", root, "znodes", strings.Join(znodes, ","))
+	r.dryRun(ctx, bk, "delete %d znodes under %s and remove the finalizer", len(znodes), root)
+	return nil
+}
@@ -0,0 +1,216 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/controller/config"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Dry run", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+	)
+
+	var (
+		s        = scheme.Scheme
+		r        *BookkeeperClusterReconciler
+		b        *v1alpha1.BookkeeperCluster
+		pod      *corev1.Pod
+		client   client.Client
+		recorder *record.FakeRecorder
+		err      error
+	)
+
+	BeforeEach(func() {
+		b = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        Name,
+				Namespace:   Namespace,
+				Annotations: map[string]string{DryRunAnnotation: "true"},
+			},
+		}
+		b.WithDefaults()
+		b.Status.InstanceID = "a1b2c3d4-0000-0000-0000-000000000000"
+		s.AddKnownTypes(v1alpha1.GroupVersion, b, &v1alpha1.BookkeeperBackup{}, &v1alpha1.BookkeeperBackupList{})
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "example-bookie-0",
+				Namespace: Namespace,
+				Labels:    b.LabelsForBookie(),
+			},
+		}
+	})
+	JustBeforeEach(func() {
+		client = fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(b, pod).Build()
+		recorder = record.NewFakeRecorder(100)
+		r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: recorder}
+	})
+
+	It("should only be enabled with the annotation or the flag", func() {
+		Ω(isDryRun(b)).Should(BeTrue())
+		b.Annotations[DryRunAnnotation] = "false"
+		Ω(isDryRun(b)).Should(BeFalse())
+		config.DryRun = true
+		defer func() { config.DryRun = false }()
+		Ω(isDryRun(b)).Should(BeTrue())
+	})
+
+	It("should keep the pvcs of removed bookies", func() {
+		sts := MakeBookieStatefulSet(b)
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "ledger-example-bookie-4",
+				Namespace: Namespace,
+				Labels:    sts.Spec.Template.Labels,
+			},
+		}
+		client.Create(context.TODO(), pvc)
+		err = r.syncStatefulSetPvc(context.TODO(), b, sts)
+		Ω(err).Should(BeNil())
+		err = client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: Namespace}, pvc)
+		Ω(err).Should(BeNil())
+		Ω(<-recorder.Events).Should(ContainSubstring("would delete pvc ledger-example-bookie-4"))
+	})
+
+	It("should not scale the bookies down", func() {
+		client.Create(context.TODO(), MakeBookieStatefulSet(b))
+		b.Spec.Replicas = 1
+		err = r.syncBookieSize(context.TODO(), b)
+		Ω(err).Should(BeNil())
+		sts := &appsv1.StatefulSet{}
+		client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie", Namespace: Namespace}, sts)
+		Ω(*sts.Spec.Replicas).Should(Equal(int32(3)))
+		Ω(<-recorder.Events).Should(ContainSubstring("would scale bookies from 3 to 1, deleting pods example-bookie-1,example-bookie-2"))
+	})
+
+	It("should not restart the bookies and keep the restart pending", func() {
+		b.Status.CurrentVersion = b.Spec.Version
+		client.Status().Update(context.TODO(), b)
+		client.Create(context.TODO(), MakeBookieStatefulSet(b))
+		err = r.restartStsPod(context.TODO(), b)
+		Ω(err).Should(BeNil())
+		err = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: Namespace}, &corev1.Pod{})
+		Ω(err).Should(BeNil())
+		Ω(<-recorder.Events).Should(ContainSubstring("would restart bookies example-bookie-0"))
+		found := &v1alpha1.BookkeeperCluster{}
+		client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, found)
+		Ω(found.Status.RestartPending).Should(BeTrue())
+
+		// the template is unchanged, but the restart is still pending
+		err = r.deployBookie(context.TODO(), found)
+		Ω(err).Should(BeNil())
+		Ω(recorder.Events).Should(Receive(ContainSubstring("would restart bookies example-bookie-0")))
+	})
+
+	It("should not restore the metadata", func() {
+		b.Status.InstanceID = ""
+		b.Spec.Restore = &v1alpha1.RestoreSpec{BackupName: "nightly", Snapshot: "old-1"}
+		backup := &v1alpha1.BookkeeperBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: Namespace},
+			Spec: v1alpha1.BookkeeperBackupSpec{
+				ClusterName: Name,
+				Storage: v1alpha1.BackupStorageSpec{
+					PersistentVolumeClaim: &v1alpha1.PVCBackupStorage{ClaimName: "backups"},
+				},
+			},
+		}
+		client.Create(context.TODO(), backup)
+		r.OperatorImage = "pravega/bookkeeper-operator:latest"
+		restored, err := r.runRestoreJob(context.TODO(), b)
+		Ω(err).Should(BeNil())
+		Ω(restored).Should(BeFalse())
+		err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-restore", Namespace: Namespace}, &batchv1.Job{})
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+		Ω(<-recorder.Events).Should(ContainSubstring("would restore snapshot old-1 of backup nightly"))
+	})
+
+	Context("With a cookie mismatch", func() {
+		BeforeEach(func() {
+			b.Spec.AutoRepair = true
+			pod.Status.ContainerStatuses = []corev1.ContainerStatus{
+				{
+					Name: "bookie",
+					State: corev1.ContainerState{
+						Waiting: &corev1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+					},
+					LastTerminationState: corev1.ContainerState{
+						Terminated: &corev1.ContainerStateTerminated{
+							ExitCode: 1,
+							Message:  "BookieException$InvalidCookieException: Cookie mismatch",
+						},
+					},
+				},
+			}
+		})
+		It("should not format the bookie", func() {
+			err = r.repairBookies(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-0-repair", Namespace: Namespace}, &batchv1.Job{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+			Ω(<-recorder.Events).Should(ContainSubstring("would format bookie example-bookie-0"))
+		})
+	})
+
+	Context("With a bookie being replaced", func() {
+		BeforeEach(func() {
+			b.Status.BookieReplacement = &v1alpha1.BookieReplacementStatus{
+				Bookie: "example-bookie-0",
+				Phase:  v1alpha1.BookieReplacementDecommissioning,
+			}
+		})
+		It("should not decommission the bookie", func() {
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: util.DecommissionJobNameForBookie("example-bookie-0"), Namespace: Namespace}, &batchv1.Job{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+		})
+	})
+
+	Context("With a deleted cluster", func() {
+		var zk *fakeZookeeper
+		BeforeEach(func() {
+			now := metav1.NewTime(time.Now())
+			b.DeletionTimestamp = &now
+			b.Finalizers = []string{util.ZkFinalizer + "_pravega"}
+		})
+		JustBeforeEach(func() {
+			zk = &fakeZookeeper{znodes: []string{"/pravega/pravega", "/pravega/pravega/bookkeeper"}}
+			r.Zookeeper = zk
+		})
+		It("should keep the znodes and the finalizer", func() {
+			err = r.reconcileFinalizers(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(zk.listed).Should(Equal([]string{"pravega"}))
+			Ω(zk.deletedClusters).Should(BeEmpty())
+			found := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, found)
+			Ω(found.Finalizers).Should(ContainElement(util.ZkFinalizer + "_pravega"))
+			Ω(<-recorder.Events).Should(ContainSubstring("would delete 2 znodes under /pravega/pravega"))
+		})
+	})
+})
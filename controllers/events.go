@@ -0,0 +1,53 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+// Reasons of the events recorded on BookkeeperClusters
+const (
+	EventReasonCreated                = "Created"
+	EventReasonScaled                 = "Scaled"
+	EventReasonConfigChanged          = "ConfigChanged"
+	EventReasonRestartStarted         = "RestartStarted"
+	EventReasonRestartFinished        = "RestartFinished"
+	EventReasonRestartFailed          = "RestartFailed"
+	EventReasonUpgradeStarted         = "UpgradeStarted"
+	EventReasonUpgradeProgressed      = "UpgradeProgressed"
+	EventReasonUpgradeCompleted       = "UpgradeCompleted"
+	EventReasonUpgradeFailed          = "UpgradeFailed"
+	EventReasonRollbackStarted        = "RollbackStarted"
+	EventReasonRollbackCompleted      = "RollbackCompleted"
+	EventReasonRollbackFailed         = "RollbackFailed"
+	EventReasonMetadataInitialized    = "MetadataInitialized"
+	EventReasonMetadataInitFailed     = "MetadataInitFailed"
+	EventReasonInstanceIDMismatch     = "InstanceIDMismatch"
+	EventReasonBookieRepairStarted    = "BookieRepairStarted"
+	EventReasonBookieRepaired         = "BookieRepaired"
+	EventReasonBookieRepairFailed     = "BookieRepairFailed"
+	EventReasonReplacementStarted     = "BookieReplacementStarted"
+	EventReasonReplacementCompleted   = "BookieReplacementCompleted"
+	EventReasonReplacementFailed      = "BookieReplacementFailed"
+	EventReasonZookeeperCleanupFailed = "ZookeeperCleanupFailed"
+	EventReasonZookeeperUnreachable   = "ZookeeperUnreachable"
+	EventReasonDryRun                 = "DryRun"
+	EventReasonPaused                 = "Paused"
+	EventReasonResumed                = "Resumed"
+)
+
+// Reasons of the events recorded on BookkeeperClusters restoring their
+// metadata, and on BookkeeperBackups
+const (
+	EventReasonMetadataRestoreStarted = "MetadataRestoreStarted"
+	EventReasonMetadataRestored       = "MetadataRestored"
+	EventReasonMetadataRestoreFailed  = "MetadataRestoreFailed"
+	EventReasonBackupStarted          = "BackupStarted"
+	EventReasonBackupCompleted        = "BackupCompleted"
+	EventReasonBackupFailed           = "BackupFailed"
+)
@@ -0,0 +1,46 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"context"
+
+	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// reconcilePaused only updates the status of a cluster with spec.paused set.
+// Nothing else is changed: the defaults are only applied to report the status
+// and are not stored, the finalizer is not run and the bookies are neither
+// restarted, scaled, upgraded nor repaired.
+func (r *BookkeeperClusterReconciler) reconcilePaused(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	bk.WithDefaults()
+	if !bk.Status.IsPaused() {
+		log.FromContext(ctx).Info("reconciliation paused")
+		r.Recorder.Event(bk, corev1.EventTypeNormal, EventReasonPaused, "Reconciliation paused, only the status is updated")
+	}
+	bk.Status.SetPausedConditionTrue("PausedBySpec", "spec.paused is set")
+	return r.reconcileClusterStatus(ctx, bk)
+}
+
+// reconcileResumed clears the Paused condition once spec.paused is unset
+func (r *BookkeeperClusterReconciler) reconcileResumed(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	if !bk.Status.IsPaused() {
+		return nil
+	}
+	log.FromContext(ctx).Info("reconciliation resumed")
+	r.Recorder.Event(bk, corev1.EventTypeNormal, EventReasonResumed, "Reconciliation resumed")
+	bk.Status.SetPausedConditionFalse()
+	// an upgrade or rollback paused midway gets a full upgradeTimeout again
+	bk.Status.ResetProgressTime()
+	return r.Client.Status().Update(ctx, bk)
+}
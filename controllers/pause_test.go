@@ -0,0 +1,149 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+)
+
+var _ = Describe("Paused cluster", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+	)
+
+	var (
+		s        = scheme.Scheme
+		r        *BookkeeperClusterReconciler
+		b        *v1alpha1.BookkeeperCluster
+		pod      *corev1.Pod
+		client   client.Client
+		recorder *record.FakeRecorder
+		req      reconcile.Request
+		err      error
+	)
+
+	BeforeEach(func() {
+		b = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      Name,
+				Namespace: Namespace,
+			},
+		}
+		b.WithDefaults()
+		b.Spec.Paused = true
+		b.Status.InstanceID = "a1b2c3d4-0000-0000-0000-000000000000"
+		b.Status.CurrentVersion = b.Spec.Version
+		s.AddKnownTypes(v1alpha1.GroupVersion, b, &v1alpha1.BookkeeperClusterList{})
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "example-bookie-0",
+				Namespace: Namespace,
+				Labels:    b.LabelsForBookie(),
+			},
+			Status: corev1.PodStatus{
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		}
+		req = reconcile.Request{NamespacedName: types.NamespacedName{Name: Name, Namespace: Namespace}}
+	})
+	JustBeforeEach(func() {
+		client = fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(b, pod).Build()
+		recorder = record.NewFakeRecorder(100)
+		r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: recorder}
+		_, err = r.Reconcile(context.TODO(), req)
+	})
+
+	It("should not change the cluster", func() {
+		Ω(err).Should(BeNil())
+		err = client.Get(context.TODO(), types.NamespacedName{Name: util.StatefulSetNameForBookie(Name), Namespace: Namespace}, &appsv1.StatefulSet{})
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+		err = client.Get(context.TODO(), types.NamespacedName{Name: util.ConfigMapNameForBookie(Name), Namespace: Namespace}, &corev1.ConfigMap{})
+		Ω(errors.IsNotFound(err)).Should(BeTrue())
+		found := &v1alpha1.BookkeeperCluster{}
+		client.Get(context.TODO(), req.NamespacedName, found)
+		Ω(found.Finalizers).Should(BeEmpty())
+	})
+
+	It("should update the status", func() {
+		found := &v1alpha1.BookkeeperCluster{}
+		client.Get(context.TODO(), req.NamespacedName, found)
+		Ω(found.Status.ReadyReplicas).Should(BeEquivalentTo(1))
+		Ω(found.Status.IsPaused()).Should(BeTrue())
+		_, condition := found.Status.GetClusterCondition(v1alpha1.ClusterConditionPaused)
+		Ω(condition.Reason).Should(Equal("PausedBySpec"))
+		Ω(<-recorder.Events).Should(ContainSubstring(EventReasonPaused))
+	})
+
+	It("should resume once spec.paused is unset", func() {
+		found := &v1alpha1.BookkeeperCluster{}
+		client.Get(context.TODO(), req.NamespacedName, found)
+		found.Spec.Paused = false
+		client.Update(context.TODO(), found)
+		<-recorder.Events
+		r.Reconcile(context.TODO(), req)
+		client.Get(context.TODO(), req.NamespacedName, found)
+		Ω(found.Status.IsPaused()).Should(BeFalse())
+		Ω(found.Finalizers).ShouldNot(BeEmpty())
+		Ω(<-recorder.Events).Should(ContainSubstring(EventReasonResumed))
+	})
+
+	Context("created without defaults", func() {
+		BeforeEach(func() {
+			b.Spec = v1alpha1.BookkeeperClusterSpec{Paused: true}
+		})
+
+		It("should report the status of the defaulted spec", func() {
+			Ω(err).Should(BeNil())
+			found := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), req.NamespacedName, found)
+			Ω(found.Status.Replicas).Should(BeEquivalentTo(v1alpha1.DefaultBookkeeperReplicas))
+			Ω(found.Status.ReadyReplicas).Should(BeEquivalentTo(1))
+		})
+	})
+
+	Context("paused during an upgrade", func() {
+		BeforeEach(func() {
+			b.Status.Init()
+			b.Status.SetUpgradingConditionTrue("UpgradeBookkeeper", "0")
+			i, _ := b.Status.GetClusterCondition(v1alpha1.ClusterConditionUpgrading)
+			b.Status.Conditions[i].LastUpdateTime = time.Now().Add(-time.Hour).Format(time.RFC3339)
+		})
+
+		It("should restart the upgrade timeout once resumed", func() {
+			found := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), req.NamespacedName, found)
+			Ω(checkSyncTimeout(found, "UpgradeBookkeeper", 0, 10)).ShouldNot(BeNil())
+			found.Spec.Paused = false
+			client.Update(context.TODO(), found)
+			r.reconcileResumed(context.TODO(), found)
+			client.Get(context.TODO(), req.NamespacedName, found)
+			Ω(found.Status.IsPaused()).Should(BeFalse())
+			Ω(found.Status.IsClusterInUpgradingState()).Should(BeTrue())
+			Ω(checkSyncTimeout(found, "UpgradeBookkeeper", 0, 10)).Should(BeNil())
+		})
+	})
+})
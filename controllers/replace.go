@@ -0,0 +1,490 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ReplaceBookieAnnotation requests the replacement of the bookie with the
+// given ordinal, e.g. after its disk died
+const ReplaceBookieAnnotation = "bookkeeper.pravega.io/replace-bookie"
+
+// bookieReplacementTimeout is how long the replacement waits for the new
+// bookie to become ready before it fails
+const bookieReplacementTimeout = 30 * time.Minute
+
+// reconcileBookieReplacement replaces the bookie requested with the
+// replace-bookie annotation. The bookie is stopped by deleting its PVCs and
+// pod, then decommissioned, its node local volumes are wiped, and the
+// replacement completes once the new bookie is ready.
+func (r *BookkeeperClusterReconciler) reconcileBookieReplacement(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	replacement := bk.Status.BookieReplacement
+	if !replacement.IsInProgress() {
+		value, ok := bk.Annotations[ReplaceBookieAnnotation]
+		if !ok {
+			return nil
+		}
+		return r.startBookieReplacement(ctx, bk, value)
+	}
+
+	switch replacement.Phase {
+	case bookkeeperv1alpha1.BookieReplacementStopping:
+		return r.stopBookie(ctx, bk)
+	case bookkeeperv1alpha1.BookieReplacementDecommissioning:
+		return r.decommissionBookie(ctx, bk)
+	case bookkeeperv1alpha1.BookieReplacementReplacing:
+		return r.waitForNewBookie(ctx, bk)
+	}
+	return nil
+}
+
+func (r *BookkeeperClusterReconciler) startBookieReplacement(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, value string) error {
+	now := time.Now().Format(time.RFC3339)
+	ordinal, err := strconv.Atoi(strings.TrimSpace(value))
+	if err != nil || ordinal < 0 || ordinal >= int(bk.Spec.Replicas) {
+		replacement := &bookkeeperv1alpha1.BookieReplacementStatus{
+			Bookie:    value,
+			Phase:     bookkeeperv1alpha1.BookieReplacementFailed,
+			StartTime: now,
+		}
+		return r.finishBookieReplacement(ctx, bk, replacement, fmt.Sprintf("invalid bookie ordinal %q", value))
+	}
+
+	podName := fmt.Sprintf("%s-%d", util.StatefulSetNameForBookie(bk.Name), ordinal)
+	pod := &corev1.Pod{}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: podName, Namespace: bk.Namespace}, pod)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get pod (%s): %v", podName, err)
+		}
+		pod = nil
+	}
+
+	bookieID := bookieIDForPod(bk, podName, pod)
+	message := fmt.Sprintf("stopping bookie %s (%s)", podName, bookieID)
+	log.FromContext(ctx).Info("replacing bookie", "pod", podName, "bookieID", bookieID)
+	bk.Status.BookieReplacement = &bookkeeperv1alpha1.BookieReplacementStatus{
+		Bookie:    podName,
+		BookieID:  bookieID,
+		Phase:     bookkeeperv1alpha1.BookieReplacementStopping,
+		Message:   message,
+		StartTime: now,
+		PhaseTime: now,
+	}
+	r.Recorder.Event(bk, corev1.EventTypeNormal, EventReasonReplacementStarted, message)
+	err = r.Client.Status().Update(ctx, bk)
+	if err != nil {
+		return fmt.Errorf("failed to update bookie replacement status: %v", err)
+	}
+	return nil
+}
+
+// stopBookie deletes the PVCs and the pod of the replaced bookie, and waits
+// until the pod is gone. The PVCs stay in use until then, and the pod the
+// StatefulSet recreates can't mount them, so the bookie stays down while it
+// is decommissioned.
+func (r *BookkeeperClusterReconciler) stopBookie(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	replacement := bk.Status.BookieReplacement
+	if r.dryRun(ctx, bk, "stop bookie %s (%s) by deleting its pvcs and pod, then decommission it", replacement.Bookie, replacement.BookieID) {
+		return nil
+	}
+	if replacement.PodUID == "" {
+		err := r.deleteBookiePvcs(ctx, bk, replacement.Bookie)
+		if err != nil {
+			return err
+		}
+		uid, err := r.deleteBookiePod(ctx, bk, replacement.Bookie)
+		if err != nil {
+			return err
+		}
+		if uid != "" {
+			replacement.PodUID = uid
+			replacement.Message = fmt.Sprintf("waiting for bookie %s to stop", replacement.Bookie)
+			err = r.Client.Status().Update(ctx, bk)
+			if err != nil {
+				return fmt.Errorf("failed to update bookie replacement status: %v", err)
+			}
+			return nil
+		}
+	} else {
+		pod := &corev1.Pod{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: replacement.Bookie, Namespace: bk.Namespace}, pod)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get pod (%s): %v", replacement.Bookie, err)
+		}
+		if err == nil && string(pod.UID) == replacement.PodUID {
+			return nil
+		}
+	}
+
+	log.FromContext(ctx).Info("decommissioning bookie", "pod", replacement.Bookie, "bookieID", replacement.BookieID)
+	message := fmt.Sprintf("decommissioning bookie %s (%s)", replacement.Bookie, replacement.BookieID)
+	return r.setBookieReplacementPhase(ctx, bk, bookkeeperv1alpha1.BookieReplacementDecommissioning, message)
+}
+
+// decommissionBookie runs the decommission Job of the stopped bookie, then
+// deletes the pod the StatefulSet recreated meanwhile, so that it is created
+// again with new PVCs
+func (r *BookkeeperClusterReconciler) decommissionBookie(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	replacement := bk.Status.BookieReplacement
+	if r.dryRun(ctx, bk, "decommission bookie %s (%s), then delete its pod", replacement.Bookie, replacement.BookieID) {
+		return nil
+	}
+	name := util.DecommissionJobNameForBookie(replacement.Bookie)
+	job := &batchv1.Job{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to get job (%s): %v", name, err)
+		}
+		job = MakeBookieDecommissionJob(bk, replacement.Bookie, replacement.BookieID)
+		controllerutil.SetControllerReference(bk, job, r.Scheme)
+		err = r.Client.Create(ctx, job)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return fmt.Errorf("failed to create job (%s): %v", name, err)
+		}
+		return nil
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			replacement.Phase = bookkeeperv1alpha1.BookieReplacementFailed
+			return r.finishBookieReplacement(ctx, bk, replacement, fmt.Sprintf("failed to decommission bookie %s: %s", replacement.Bookie, c.Message))
+		}
+	}
+	if job.Status.Succeeded == 0 {
+		return nil
+	}
+	if bk.Spec.Storage.IsNodeLocal() {
+		wiped, err := r.wipeLocalBookie(ctx, bk)
+		if err != nil || !wiped {
+			return err
+		}
+	}
+
+	// the recreated pod is either pending on the deleted PVCs or runs on PVCs
+	// the StatefulSet recreated after the bookie stopped, and in both cases
+	// can't start the new bookie before it is deleted
+	err = r.deleteBookiePvcs(ctx, bk, replacement.Bookie)
+	if err != nil {
+		return err
+	}
+	uid, err := r.deleteBookiePod(ctx, bk, replacement.Bookie)
+	if err != nil {
+		return err
+	}
+	if uid != "" {
+		replacement.PodUID = uid
+	}
+	propagation := metav1.DeletePropagationBackground
+	err = r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete job (%s): %v", name, err)
+	}
+
+	log.FromContext(ctx).Info("waiting for the new bookie to register", "pod", replacement.Bookie)
+	message := fmt.Sprintf("waiting for the new bookie %s to register", replacement.Bookie)
+	return r.setBookieReplacementPhase(ctx, bk, bookkeeperv1alpha1.BookieReplacementReplacing, message)
+}
+
+// waitForNewBookie completes the replacement once the new bookie pod is ready,
+// i.e. once the new bookie has formatted its volumes and registered, and fails
+// it if that takes longer than bookieReplacementTimeout
+func (r *BookkeeperClusterReconciler) waitForNewBookie(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	replacement := bk.Status.BookieReplacement
+	if bk.Spec.Storage.IsNodeLocal() {
+		err := r.preBindLocalBookieVolumes(ctx, bk)
+		if err != nil {
+			return err
+		}
+	}
+	pod := &corev1.Pod{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: replacement.Bookie, Namespace: bk.Namespace}, pod)
+	if err != nil && !errors.IsNotFound(err) {
+		return fmt.Errorf("failed to get pod (%s): %v", replacement.Bookie, err)
+	}
+	if err == nil && string(pod.UID) != replacement.PodUID && util.IsPodReady(pod) {
+		replacement.Phase = bookkeeperv1alpha1.BookieReplacementCompleted
+		return r.finishBookieReplacement(ctx, bk, replacement, fmt.Sprintf("bookie %s replaced", replacement.Bookie))
+	}
+	phaseTime, err := time.Parse(time.RFC3339, replacement.PhaseTime)
+	if err == nil && time.Since(phaseTime) > bookieReplacementTimeout {
+		replacement.Phase = bookkeeperv1alpha1.BookieReplacementFailed
+		return r.finishBookieReplacement(ctx, bk, replacement, fmt.Sprintf("the new bookie %s is not ready after %v", replacement.Bookie, bookieReplacementTimeout))
+	}
+	return nil
+}
+
+// setBookieReplacementPhase moves the replacement to the given phase
+func (r *BookkeeperClusterReconciler) setBookieReplacementPhase(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, phase bookkeeperv1alpha1.BookieReplacementPhase, message string) error {
+	replacement := bk.Status.BookieReplacement
+	replacement.Phase = phase
+	replacement.Message = message
+	replacement.PhaseTime = time.Now().Format(time.RFC3339)
+	err := r.Client.Status().Update(ctx, bk)
+	if err != nil {
+		return fmt.Errorf("failed to update bookie replacement status: %v", err)
+	}
+	return nil
+}
+
+// wipeLocalBookie formats the node local volumes of the decommissioned bookie.
+// The old data and cookie would be left on the node otherwise, and the new
+// bookie would fail the cookie validation. The volumes are bound to wipe
+// claims, which the wipe Job mounts, and the claims and the Job are deleted
+// once the volumes are formatted.
+func (r *BookkeeperClusterReconciler) wipeLocalBookie(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (bool, error) {
+	replacement := bk.Status.BookieReplacement
+	pvs, err := r.getLocalBookieVolumes(ctx, bk, replacement.Bookie)
+	if err != nil {
+		return false, err
+	}
+	name := util.WipeJobNameForBookie(replacement.Bookie)
+	job := &batchv1.Job{}
+	err = r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, job)
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to get job (%s): %v", name, err)
+		}
+		for disk, pv := range pvs {
+			claim := makeBookieWipeClaim(bk, pv, util.WipeClaimNameForBookie(disk, replacement.Bookie))
+			controllerutil.SetControllerReference(bk, claim, r.Scheme)
+			err = r.Client.Create(ctx, claim)
+			if err != nil && !errors.IsAlreadyExists(err) {
+				return false, fmt.Errorf("failed to create pvc (%s): %v", claim.Name, err)
+			}
+			err = r.preBindLocalPersistentVolume(ctx, bk, pv, claim.Name)
+			if err != nil {
+				return false, err
+			}
+		}
+		log.FromContext(ctx).Info("wiping the node local volumes of the bookie", "pod", replacement.Bookie)
+		job = MakeBookieWipeJob(bk, replacement.Bookie)
+		controllerutil.SetControllerReference(bk, job, r.Scheme)
+		err = r.Client.Create(ctx, job)
+		if err != nil && !errors.IsAlreadyExists(err) {
+			return false, fmt.Errorf("failed to create job (%s): %v", name, err)
+		}
+		return false, nil
+	}
+
+	for _, c := range job.Status.Conditions {
+		if c.Type == batchv1.JobFailed && c.Status == corev1.ConditionTrue {
+			replacement.Phase = bookkeeperv1alpha1.BookieReplacementFailed
+			return false, r.finishBookieReplacement(ctx, bk, replacement, fmt.Sprintf("failed to wipe the volumes of bookie %s: %s", replacement.Bookie, c.Message))
+		}
+	}
+	if job.Status.Succeeded == 0 {
+		return false, nil
+	}
+
+	propagation := metav1.DeletePropagationBackground
+	err = r.Client.Delete(ctx, job, &client.DeleteOptions{PropagationPolicy: &propagation})
+	if err != nil && !errors.IsNotFound(err) {
+		return false, fmt.Errorf("failed to delete job (%s): %v", name, err)
+	}
+	for disk := range pvs {
+		claim := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      util.WipeClaimNameForBookie(disk, replacement.Bookie),
+				Namespace: bk.Namespace,
+			},
+		}
+		err = r.Client.Delete(ctx, claim)
+		if err != nil && !errors.IsNotFound(err) {
+			return false, fmt.Errorf("failed to delete pvc (%s): %v", claim.Name, err)
+		}
+	}
+	return true, nil
+}
+
+// preBindLocalBookieVolumes pre-binds the wiped node local volumes of the
+// replaced bookie to its claims again. A claim deleted during the replacement
+// may still be terminating, and the volumes are only handed over once the
+// StatefulSet has recreated it, since they would be released again otherwise.
+func (r *BookkeeperClusterReconciler) preBindLocalBookieVolumes(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) error {
+	replacement := bk.Status.BookieReplacement
+	pvs, err := r.getLocalBookieVolumes(ctx, bk, replacement.Bookie)
+	if err != nil {
+		return err
+	}
+	for disk, pv := range pvs {
+		name := fmt.Sprintf("%s-%s", disk, replacement.Bookie)
+		pvc := &corev1.PersistentVolumeClaim{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, pvc)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return fmt.Errorf("failed to get pvc (%s): %v", name, err)
+		}
+		if !pvc.DeletionTimestamp.IsZero() {
+			continue
+		}
+		err = r.preBindLocalPersistentVolume(ctx, bk, pv, name)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// preBindLocalPersistentVolume points the claim reference of the volume to the
+// given claim. The UID of the claim the volume was bound to is dropped, so
+// that the volume is bound to the claim with that name once it exists.
+func (r *BookkeeperClusterReconciler) preBindLocalPersistentVolume(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, pv *corev1.PersistentVolume, claim string) error {
+	if pv.Spec.ClaimRef != nil && pv.Spec.ClaimRef.Name == claim {
+		return nil
+	}
+	pv.Spec.ClaimRef = &corev1.ObjectReference{
+		Kind:      "PersistentVolumeClaim",
+		Namespace: bk.Namespace,
+		Name:      claim,
+	}
+	err := r.Client.Update(ctx, pv)
+	if err != nil {
+		return fmt.Errorf("failed to bind persistent volume (%s) to pvc (%s): %v", pv.Name, claim, err)
+	}
+	return nil
+}
+
+// getLocalBookieVolumes returns the node local volumes of the given bookie pod
+// by disk name
+func (r *BookkeeperClusterReconciler) getLocalBookieVolumes(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, podName string) (map[string]*corev1.PersistentVolume, error) {
+	ordinal, err := strconv.Atoi(strings.TrimPrefix(podName, util.StatefulSetNameForBookie(bk.Name)+"-"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bookie pod name %q", podName)
+	}
+	pvs := map[string]*corev1.PersistentVolume{}
+	for _, template := range makeBookieVolumeClaimTemplates(bk) {
+		name := util.LocalPersistentVolumeNameForBookie(bk.Namespace, bk.Name, template.Name, ordinal)
+		pv := &corev1.PersistentVolume{}
+		err = r.Client.Get(ctx, types.NamespacedName{Name: name}, pv)
+		if err != nil {
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to get persistent volume (%s): %v", name, err)
+		}
+		pvs[template.Name] = pv
+	}
+	return pvs, nil
+}
+
+// makeBookieWipeClaim returns the claim binding the given node local volume
+// for the wipe Job
+func makeBookieWipeClaim(bk *bookkeeperv1alpha1.BookkeeperCluster, pv *corev1.PersistentVolume, name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: bk.Namespace,
+			Labels:    labelsForBookieJob(bk, "bookie-wipe"),
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			AccessModes:      pv.Spec.AccessModes,
+			StorageClassName: &pv.Spec.StorageClassName,
+			VolumeMode:       pv.Spec.VolumeMode,
+			VolumeName:       pv.Name,
+			Resources: corev1.ResourceRequirements{
+				Requests: pv.Spec.Capacity,
+			},
+		},
+	}
+}
+
+// deleteBookiePvcs deletes the PVCs of the given bookie pod. They are only
+// removed once no pod uses them anymore.
+func (r *BookkeeperClusterReconciler) deleteBookiePvcs(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, podName string) error {
+	for _, template := range makeBookieVolumeClaimTemplates(bk) {
+		pvc := &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("%s-%s", template.Name, podName),
+				Namespace: bk.Namespace,
+			},
+		}
+		err := r.Client.Delete(ctx, pvc)
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete pvc (%s): %v", pvc.Name, err)
+		}
+	}
+	return nil
+}
+
+// deleteBookiePod deletes the given bookie pod and returns its UID, or an
+// empty UID if there is no such pod
+func (r *BookkeeperClusterReconciler) deleteBookiePod(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, podName string) (string, error) {
+	pod := &corev1.Pod{}
+	err := r.Client.Get(ctx, types.NamespacedName{Name: podName, Namespace: bk.Namespace}, pod)
+	if err == nil {
+		err = r.Client.Delete(ctx, pod)
+	}
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to delete pod (%s): %v", podName, err)
+	}
+	return string(pod.UID), nil
+}
+
+// finishBookieReplacement records the outcome of the replacement and removes
+// the replace-bookie annotation, so that another replacement can be requested
+func (r *BookkeeperClusterReconciler) finishBookieReplacement(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, replacement *bookkeeperv1alpha1.BookieReplacementStatus, message string) error {
+	replacement.Message = message
+	replacement.CompletionTime = time.Now().Format(time.RFC3339)
+	bk.Status.BookieReplacement = replacement
+	if replacement.Phase == bookkeeperv1alpha1.BookieReplacementFailed {
+		log.FromContext(ctx).Info("bookie replacement failed", "pod", replacement.Bookie, "reason", message)
+		r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonReplacementFailed, message)
+	} else {
+		log.FromContext(ctx).Info("bookie replacement completed", "pod", replacement.Bookie)
+		r.Recorder.Event(bk, corev1.EventTypeNormal, EventReasonReplacementCompleted, message)
+	}
+	err := r.Client.Status().Update(ctx, bk)
+	if err != nil {
+		return fmt.Errorf("failed to update bookie replacement status: %v", err)
+	}
+	delete(bk.Annotations, ReplaceBookieAnnotation)
+	err = r.Client.Update(ctx, bk)
+	if err != nil {
+		return fmt.Errorf("failed to remove annotation %s: %v", ReplaceBookieAnnotation, err)
+	}
+	return nil
+}
+
+// bookieIDForPod returns the ID the bookie of the given pod registers with:
+// its advertised address, the FQDN of the pod, or its IP address for versions
+// not using hostnames as bookie IDs
+func bookieIDForPod(bk *bookkeeperv1alpha1.BookkeeperCluster, podName string, pod *corev1.Pod) string {
+	host := fmt.Sprintf("%s.%s.%s.svc.cluster.local", podName, bk.HeadlessServiceNameForBookie(), bk.Namespace)
+	if bk.IsExternalAccessEnabled() {
+		host = strings.NewReplacer("$(POD_NAME)", podName, "$(POD_NAMESPACE)", bk.Namespace).Replace(bk.AdvertisedAddressForBookie())
+	} else if match, _ := util.CompareVersions(bk.Spec.Version, "0.5.0", "<"); match && pod != nil && pod.Status.PodIP != "" {
+		host = pod.Status.PodIP
+	}
+	return fmt.Sprintf("%s:%d", host, 3181)
+}
@@ -0,0 +1,284 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Bookie Replacement", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+	)
+
+	var (
+		s      = scheme.Scheme
+		r      *BookkeeperClusterReconciler
+		b      *v1alpha1.BookkeeperCluster
+		pod    *corev1.Pod
+		pvc    *corev1.PersistentVolumeClaim
+		client client.Client
+		err    error
+	)
+
+	BeforeEach(func() {
+		b = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        Name,
+				Namespace:   Namespace,
+				Annotations: map[string]string{ReplaceBookieAnnotation: "1"},
+			},
+		}
+		b.WithDefaults()
+		s.AddKnownTypes(v1alpha1.GroupVersion, b)
+		pod = &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "example-bookie-1",
+				Namespace: Namespace,
+				UID:       "old",
+				Labels:    b.LabelsForBookie(),
+			},
+		}
+		pvc = &corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "journal-example-bookie-1",
+				Namespace: Namespace,
+				Labels:    b.LabelsForBookie(),
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, pod, pvc).Build()
+		r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+		err = r.reconcileBookieReplacement(context.TODO(), b)
+	})
+
+	Context("Starting the replacement", func() {
+		It("should record the bookie to stop", func() {
+			Ω(err).Should(BeNil())
+			replacement := b.Status.BookieReplacement
+			Ω(replacement.Bookie).Should(Equal("example-bookie-1"))
+			Ω(replacement.BookieID).Should(Equal("example-bookie-1.example-bookie-headless.default.svc.cluster.local:3181"))
+			Ω(replacement.Phase).Should(Equal(v1alpha1.BookieReplacementStopping))
+		})
+		It("should stop the bookie before decommissioning it", func() {
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(b.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementStopping))
+			Ω(b.Status.BookieReplacement.PodUID).Should(Equal("old"))
+			err = client.Get(context.TODO(), types.NamespacedName{Name: pvc.Name, Namespace: Namespace}, pvc)
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: Namespace}, &corev1.Pod{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-decommission", Namespace: Namespace}, &batchv1.Job{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+		})
+		It("should wait until the pod is gone", func() {
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			terminating := pod.DeepCopy()
+			terminating.ResourceVersion = ""
+			client.Create(context.TODO(), terminating)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(b.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementStopping))
+		})
+		It("should run the decommission job once the bookie stopped", func() {
+			r.reconcileBookieReplacement(context.TODO(), b)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(b.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementDecommissioning))
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			job := &batchv1.Job{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-decommission", Namespace: Namespace}, job)
+			Ω(err).Should(BeNil())
+			Ω(job.Spec.Template.Spec.Containers[0].Args[2]).Should(ContainSubstring("decommissionbookie -bookieid example-bookie-1."))
+		})
+	})
+
+	Context("Once the bookie is decommissioned", func() {
+		JustBeforeEach(func() {
+			r.reconcileBookieReplacement(context.TODO(), b)
+			r.reconcileBookieReplacement(context.TODO(), b)
+			recreated := pod.DeepCopy()
+			recreated.ResourceVersion = ""
+			recreated.UID = "recreated"
+			client.Create(context.TODO(), recreated)
+			r.reconcileBookieReplacement(context.TODO(), b)
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-decommission", Namespace: Namespace}, job)
+			job.Status.Succeeded = 1
+			client.Update(context.TODO(), job)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+		})
+		It("should delete the recreated pod of the bookie", func() {
+			Ω(err).Should(BeNil())
+			Ω(b.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementReplacing))
+			Ω(b.Status.BookieReplacement.PodUID).Should(Equal("recreated"))
+			err = client.Get(context.TODO(), types.NamespacedName{Name: pod.Name, Namespace: Namespace}, &corev1.Pod{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+		})
+		It("should complete once the new bookie is ready", func() {
+			newPod := pod.DeepCopy()
+			newPod.ResourceVersion = ""
+			newPod.UID = "new"
+			newPod.Status.Conditions = []corev1.PodCondition{
+				{Type: corev1.PodReady, Status: corev1.ConditionTrue},
+			}
+			client.Create(context.TODO(), newPod)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			foundBookkeeper := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, foundBookkeeper)
+			Ω(foundBookkeeper.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementCompleted))
+			Ω(foundBookkeeper.Status.BookieReplacement.CompletionTime).ShouldNot(BeEmpty())
+			Ω(foundBookkeeper.Annotations).ShouldNot(HaveKey(ReplaceBookieAnnotation))
+		})
+		It("should fail if the new bookie is not ready in time", func() {
+			b.Status.BookieReplacement.PhaseTime = time.Now().Add(-bookieReplacementTimeout - time.Minute).Format(time.RFC3339)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			foundBookkeeper := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, foundBookkeeper)
+			Ω(foundBookkeeper.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementFailed))
+			Ω(foundBookkeeper.Annotations).ShouldNot(HaveKey(ReplaceBookieAnnotation))
+		})
+	})
+
+	Context("With node local storage", func() {
+		var pv *corev1.PersistentVolume
+
+		BeforeEach(func() {
+			b.Spec = v1alpha1.BookkeeperClusterSpec{
+				Replicas: 3,
+				Storage: &v1alpha1.BookkeeperStorageSpec{
+					Mode: v1alpha1.StorageModeLocal,
+					Local: &v1alpha1.LocalStorageSpec{
+						Nodes:       []string{"node-0", "node-1", "node-2"},
+						LedgerPath:  "/mnt/ledgers",
+						JournalPath: "/mnt/journal",
+						IndexPath:   "/mnt/index",
+					},
+				},
+			}
+			b.WithDefaults()
+		})
+
+		JustBeforeEach(func() {
+			for _, local := range MakeBookieLocalPersistentVolumes(b) {
+				local.Spec.ClaimRef.UID = "old-claim"
+				local.Status.Phase = corev1.VolumeReleased
+				client.Create(context.TODO(), local)
+			}
+			r.reconcileBookieReplacement(context.TODO(), b)
+			r.reconcileBookieReplacement(context.TODO(), b)
+			r.reconcileBookieReplacement(context.TODO(), b)
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-decommission", Namespace: Namespace}, job)
+			job.Status.Succeeded = 1
+			client.Update(context.TODO(), job)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			pv = &corev1.PersistentVolume{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "default-example-bookie-journal-1"}, pv)
+		})
+
+		It("should wipe the volumes of the bookie on its node", func() {
+			Ω(err).Should(BeNil())
+			Ω(b.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementDecommissioning))
+			Ω(pv.Spec.ClaimRef.Name).Should(Equal("journal-example-bookie-1-wipe"))
+			Ω(pv.Spec.ClaimRef.UID).Should(BeEmpty())
+			claim := &corev1.PersistentVolumeClaim{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "journal-example-bookie-1-wipe", Namespace: Namespace}, claim)
+			Ω(err).Should(BeNil())
+			Ω(claim.Spec.VolumeName).Should(Equal(pv.Name))
+			job := &batchv1.Job{}
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-wipe", Namespace: Namespace}, job)
+			Ω(err).Should(BeNil())
+			Ω(job.Spec.Template.Spec.Containers[0].Args[2]).Should(ContainSubstring("bookieformat"))
+			Ω(job.Spec.Template.Spec.Volumes).Should(ContainElement(corev1.Volume{
+				Name: "journal",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: "journal-example-bookie-1-wipe"},
+				},
+			}))
+		})
+
+		It("should hand the wiped volumes over to the new bookie", func() {
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-wipe", Namespace: Namespace}, job)
+			job.Status.Succeeded = 1
+			client.Update(context.TODO(), job)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(b.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementReplacing))
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "journal-example-bookie-1-wipe", Namespace: Namespace}, &corev1.PersistentVolumeClaim{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-wipe", Namespace: Namespace}, &batchv1.Job{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+
+			// the volume waits for the claim recreated by the StatefulSet
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			client.Get(context.TODO(), types.NamespacedName{Name: pv.Name}, pv)
+			Ω(pv.Spec.ClaimRef.Name).Should(Equal("journal-example-bookie-1-wipe"))
+			newPvc := pvc.DeepCopy()
+			newPvc.ResourceVersion = ""
+			client.Create(context.TODO(), newPvc)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			client.Get(context.TODO(), types.NamespacedName{Name: pv.Name}, pv)
+			Ω(pv.Spec.ClaimRef.Name).Should(Equal("journal-example-bookie-1"))
+			Ω(pv.Spec.ClaimRef.UID).Should(BeEmpty())
+		})
+
+		It("should fail the replacement if the volumes can't be wiped", func() {
+			job := &batchv1.Job{}
+			client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-1-wipe", Namespace: Namespace}, job)
+			job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "BackoffLimitExceeded"}}
+			client.Update(context.TODO(), job)
+			err = r.reconcileBookieReplacement(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			foundBookkeeper := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, foundBookkeeper)
+			Ω(foundBookkeeper.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementFailed))
+			Ω(foundBookkeeper.Status.BookieReplacement.Message).Should(ContainSubstring("failed to wipe the volumes of bookie example-bookie-1"))
+		})
+	})
+
+	Context("With an invalid ordinal", func() {
+		BeforeEach(func() {
+			b.Annotations[ReplaceBookieAnnotation] = "5"
+		})
+		It("should fail the replacement and remove the annotation", func() {
+			Ω(err).Should(BeNil())
+			foundBookkeeper := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, foundBookkeeper)
+			Ω(foundBookkeeper.Status.BookieReplacement.Phase).Should(Equal(v1alpha1.BookieReplacementFailed))
+			Ω(foundBookkeeper.Annotations).ShouldNot(HaveKey(ReplaceBookieAnnotation))
+		})
+	})
+})
@@ -18,7 +18,6 @@ import (
 
 	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
 	"github.com/pravega/bookkeeper-operator/pkg/util"
-	log "github.com/sirupsen/logrus"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
@@ -27,6 +26,7 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 type componentSyncVersionFun struct {
@@ -35,9 +35,9 @@ type componentSyncVersionFun struct {
 }
 
 // upgrade
-func (r *BookkeeperClusterReconciler) syncClusterVersion(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) syncClusterVersion(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 	defer func() {
-		r.Client.Status().Update(context.TODO(), bk)
+		r.Client.Status().Update(ctx, bk)
 	}()
 
 	// we cannot upgrade if cluster is in UpgradeFailed or Rollback state
@@ -59,28 +59,24 @@ func (r *BookkeeperClusterReconciler) syncClusterVersion(bk *bookkeeperv1alpha1.
 	if upgradeCondition.Status == corev1.ConditionTrue {
 		// Upgrade process already in progress
 		if bk.Status.TargetVersion == "" {
-			log.Println("syncing to an unknown version: cancelling upgrade process")
-			return r.clearUpgradeStatus(bk)
+			log.FromContext(ctx).Info("syncing to an unknown version: cancelling upgrade process")
+			return r.clearUpgradeStatus(ctx, bk)
 		}
 
 		if bk.Status.TargetVersion == bk.Status.CurrentVersion {
-			log.Printf("syncing to version '%s' completed", bk.Status.TargetVersion)
-			return r.clearUpgradeStatus(bk)
+			log.FromContext(ctx).Info("syncing to version completed", "version", bk.Status.TargetVersion)
+			return r.clearUpgradeStatus(ctx, bk)
 		}
 
 		//syncCompleted, err := r.syncComponentsVersion(p)
-		syncCompleted, err := r.syncBookkeeperVersion(bk)
+		syncCompleted, err := r.syncBookkeeperVersion(ctx, bk)
 		if err != nil {
-			log.Printf("error syncing cluster version, upgrade failed. %v", err)
+			log.FromContext(ctx).Error(err, "error syncing cluster version, upgrade failed")
 			bk.Status.SetErrorConditionTrue("UpgradeFailed", err.Error())
 			// emit an event for Upgrade Failure
 			message := fmt.Sprintf("Error Upgrading from version %v to %v. %v", bk.Status.CurrentVersion, bk.Status.TargetVersion, err.Error())
-			event := bk.NewEvent("UPGRADE_ERROR", bookkeeperv1alpha1.UpgradeErrorReason, message, "Error")
-			pubErr := r.Client.Create(context.TODO(), event)
-			if pubErr != nil {
-				log.Printf("Error publishing Upgrade Failure event to k8s. %v", pubErr)
-			}
-			r.clearUpgradeStatus(bk)
+			r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonUpgradeFailed, message)
+			r.clearUpgradeStatus(ctx, bk)
 			return err
 		}
 
@@ -88,7 +84,10 @@ func (r *BookkeeperClusterReconciler) syncClusterVersion(bk *bookkeeperv1alpha1.
 			// All component versions have been synced
 			bk.Status.AddToVersionHistory(bk.Status.TargetVersion)
 			bk.Status.CurrentVersion = bk.Status.TargetVersion
-			log.Printf("Upgrade completed for all bookkeeper components.")
+			// the upgrade has recreated all the bookies with the new template
+			bk.Status.RestartPending = false
+			log.FromContext(ctx).Info("Upgrade completed for all bookkeeper components")
+			r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonUpgradeCompleted, "Upgrade to version %s completed", bk.Status.CurrentVersion)
 		}
 		return nil
 	}
@@ -102,8 +101,8 @@ func (r *BookkeeperClusterReconciler) syncClusterVersion(bk *bookkeeperv1alpha1.
 	if !bk.Status.IsClusterInRollbackFailedState() {
 		// skip this check when cluster is in RollbackFailed state
 		if readyCondition == nil || readyCondition.Status != corev1.ConditionTrue {
-			r.clearUpgradeStatus(bk)
-			log.Print("cannot trigger upgrade if there are unready pods")
+			r.clearUpgradeStatus(ctx, bk)
+			log.FromContext(ctx).Info("cannot trigger upgrade if there are unready pods")
 			return nil
 		}
 	} else {
@@ -112,23 +111,24 @@ func (r *BookkeeperClusterReconciler) syncClusterVersion(bk *bookkeeperv1alpha1.
 	}
 
 	// Need to sync cluster versions
-	log.Printf("syncing cluster version from %s to %s", bk.Status.CurrentVersion, bk.Spec.Version)
+	log.FromContext(ctx).Info("syncing cluster version", "from", bk.Status.CurrentVersion, "to", bk.Spec.Version)
 	// Setting target version and condition.
 	// The upgrade process will start on the next reconciliation
 	bk.Status.TargetVersion = bk.Spec.Version
 	bk.Status.SetUpgradingConditionTrue("", "")
+	r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonUpgradeStarted, "Upgrading from version %s to %s", bk.Status.CurrentVersion, bk.Spec.Version)
 
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) clearUpgradeStatus(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+func (r *BookkeeperClusterReconciler) clearUpgradeStatus(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
 	bk.Status.SetUpgradingConditionFalse()
 	bk.Status.TargetVersion = ""
 	// need to deep copy the status struct, otherwise it will be overwritten
 	// when updating the CR below
 	status := bk.Status.DeepCopy()
 
-	if err := r.Client.Update(context.TODO(), bk); err != nil {
+	if err := r.Client.Update(ctx, bk); err != nil {
 		return err
 	}
 
@@ -136,40 +136,37 @@ func (r *BookkeeperClusterReconciler) clearUpgradeStatus(bk *bookkeeperv1alpha1.
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) rollbackClusterVersion(bk *bookkeeperv1alpha1.BookkeeperCluster, version string) (err error) {
+func (r *BookkeeperClusterReconciler) rollbackClusterVersion(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster, version string) (err error) {
 	defer func() {
-		r.Client.Status().Update(context.TODO(), bk)
+		r.Client.Status().Update(ctx, bk)
 	}()
 	_, rollbackCondition := bk.Status.GetClusterCondition(bookkeeperv1alpha1.ClusterConditionRollback)
 	if rollbackCondition == nil || rollbackCondition.Status != corev1.ConditionTrue {
 		// We're in the first iteration for Rollback
 		// Add Rollback Condition to Cluster Status
-		log.Printf("Updating Target Version to  %v", version)
+		log.FromContext(ctx).Info("Updating Target Version", "version", version)
 		bk.Status.TargetVersion = version
 		bk.Status.SetRollbackConditionTrue("", "")
-		updateErr := r.Client.Status().Update(context.TODO(), bk)
+		updateErr := r.Client.Status().Update(ctx, bk)
 		if updateErr != nil {
 			bk.Status.SetRollbackConditionFalse()
-			log.Printf("Error updating cluster: %v", updateErr.Error())
+			log.FromContext(ctx).Error(updateErr, "Error updating cluster")
 			return fmt.Errorf("Error updating cluster status. %v", updateErr)
 		}
+		r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonRollbackStarted, "Rolling back from version %s to %s", bk.Status.CurrentVersion, version)
 		return nil
 	}
 
-	syncCompleted, err := r.syncBookkeeperVersion(bk)
+	syncCompleted, err := r.syncBookkeeperVersion(ctx, bk)
 	if err != nil {
 		// Error rolling back, set appropriate status and ask for manual intervention
 		bk.Status.SetErrorConditionTrue("RollbackFailed", err.Error())
 		// emit an event for Rollback Failure
 		message := fmt.Sprintf("Error Rollingback from version %v to %v. %v", bk.Status.CurrentVersion, bk.Status.TargetVersion, err.Error())
-		event := bk.NewEvent("ROLLBACK_ERROR", bookkeeperv1alpha1.RollbackErrorReason, message, "Error")
-		pubErr := r.Client.Create(context.TODO(), event)
-		if pubErr != nil {
-			log.Printf("Error publishing ROLLBACK_ERROR event to k8s. %v", pubErr)
-		}
-		r.clearRollbackStatus(bk)
-		log.Printf("Error rolling back to cluster version %v. Reason: %v", version, err)
-		//r.Client.Status().Update(context.TODO(), p)
+		r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonRollbackFailed, message)
+		r.clearRollbackStatus(ctx, bk)
+		log.FromContext(ctx).Error(err, "Error rolling back to cluster version", "version", version)
+		//r.Client.Status().Update(ctx, p)
 		return err
 	}
 
@@ -178,22 +175,23 @@ func (r *BookkeeperClusterReconciler) rollbackClusterVersion(bk *bookkeeperv1alp
 		bk.Status.CurrentVersion = bk.Status.TargetVersion
 		// Set Error/UpgradeFailed Condition to 'false', so rollback is not triggered again
 		bk.Status.SetErrorConditionFalse()
-		r.clearRollbackStatus(bk)
-		log.Printf("Rollback to version %v completed for all bookkeeper components.", version)
+		r.clearRollbackStatus(ctx, bk)
+		log.FromContext(ctx).Info("Rollback completed for all bookkeeper components", "version", version)
+		r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonRollbackCompleted, "Rollback to version %s completed", version)
 	}
-	//r.Client.Status().Update(context.TODO(), p)
+	//r.Client.Status().Update(ctx, p)
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) clearRollbackStatus(bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
-	log.Printf("clearRollbackStatus")
+func (r *BookkeeperClusterReconciler) clearRollbackStatus(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (err error) {
+	log.FromContext(ctx).Info("clearing rollback status")
 	bk.Status.SetRollbackConditionFalse()
 	bk.Status.TargetVersion = ""
 	// need to deep copy the status struct, otherwise it will be overwritten
 	// when updating the CR below
 	status := bk.Status.DeepCopy()
 
-	if err := r.Client.Update(context.TODO(), bk); err != nil {
+	if err := r.Client.Update(ctx, bk); err != nil {
 		return err
 	}
 
@@ -201,10 +199,10 @@ func (r *BookkeeperClusterReconciler) clearRollbackStatus(bk *bookkeeperv1alpha1
 	return nil
 }
 
-func (r *BookkeeperClusterReconciler) syncBookkeeperVersion(bk *bookkeeperv1alpha1.BookkeeperCluster) (synced bool, err error) {
+func (r *BookkeeperClusterReconciler) syncBookkeeperVersion(ctx context.Context, bk *bookkeeperv1alpha1.BookkeeperCluster) (synced bool, err error) {
 	sts := &appsv1.StatefulSet{}
 	name := util.StatefulSetNameForBookie(bk.Name)
-	err = r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: bk.Namespace}, sts)
+	err = r.Client.Get(ctx, types.NamespacedName{Name: name, Namespace: bk.Namespace}, sts)
 	if err != nil {
 		return false, fmt.Errorf("failed to get statefulset (%s): %v", name, err)
 	}
@@ -218,24 +216,29 @@ func (r *BookkeeperClusterReconciler) syncBookkeeperVersion(bk *bookkeeperv1alph
 		bk.Status.UpdateProgress(bookkeeperv1alpha1.UpdatingBookkeeperReason, "0")
 		// Need to update pod template
 		// This will trigger the rolling upgrade process
-		log.Printf("updating statefulset (%s) template image to '%s'", sts.Name, targetImage)
+		log.FromContext(ctx).Info("updating statefulset template image", "statefulSet", sts.Name, "image", targetImage)
 
 		configMap := MakeBookieConfigMap(bk)
 		controllerutil.SetControllerReference(bk, configMap, r.Scheme)
 		currentConfigMap := &corev1.ConfigMap{}
 		cmName := util.ConfigMapNameForBookie(bk.Name)
-		err = r.Client.Get(context.TODO(), types.NamespacedName{Name: cmName, Namespace: bk.Namespace}, currentConfigMap)
+		err = r.Client.Get(ctx, types.NamespacedName{Name: cmName, Namespace: bk.Namespace}, currentConfigMap)
 		if err != nil {
 			return false, fmt.Errorf("failed to get configmap (%s): %v", cmName, err)
 		}
 		configMap.ObjectMeta.ResourceVersion = currentConfigMap.ObjectMeta.ResourceVersion
-		err = r.Client.Update(context.TODO(), configMap)
+		err = r.Client.Update(ctx, configMap)
 		if err != nil {
 			return false, err
 		}
 
-		sts.Spec.Template = MakeBookiePodTemplate(bk)
-		err = r.Client.Update(context.TODO(), sts)
+		template := MakeBookiePodTemplate(bk)
+		// the referenced configuration is unchanged by the upgrade
+		if hash, ok := sts.Spec.Template.Annotations[ConfigHashAnnotation]; ok {
+			template.Annotations[ConfigHashAnnotation] = hash
+		}
+		sts.Spec.Template = template
+		err = r.Client.Update(ctx, sts)
 		if err != nil {
 			return false, err
 		}
@@ -245,10 +248,10 @@ func (r *BookkeeperClusterReconciler) syncBookkeeperVersion(bk *bookkeeperv1alph
 	}
 
 	// Pod template already updated
-	log.Printf("statefulset (%s) status: %d updated, %d ready, %d target", sts.Name,
-		sts.Status.UpdatedReplicas, sts.Status.ReadyReplicas, sts.Status.Replicas)
+	log.FromContext(ctx).Info("statefulset status", "statefulSet", sts.Name,
+		"updated", sts.Status.UpdatedReplicas, "ready", sts.Status.ReadyReplicas, "target", sts.Status.Replicas)
 
-	pods, err := r.getStsPodsWithVersion(sts, bk.Status.TargetVersion)
+	pods, err := r.getStsPodsWithVersion(ctx, sts, bk.Status.TargetVersion)
 	if err != nil {
 		return false, err
 	}
@@ -268,34 +271,48 @@ func (r *BookkeeperClusterReconciler) syncBookkeeperVersion(bk *bookkeeperv1alph
 	}
 
 	// If all replicas are ready, upgrade an old pod
-	ready, err := r.checkUpdatedPods(pods, bk.Status.TargetVersion)
+	ready, err := r.checkUpdatedPods(ctx, pods, bk.Status.TargetVersion)
 	if err != nil {
 		// Abort if there is any errors with the updated pods
 		return false, err
 	}
 
 	if ready && *sts.Spec.Replicas != (int32)(len(pods)) {
+		terminating, err := r.hasTerminatingPod(ctx, sts)
+		if err != nil {
+			return false, err
+		}
+		if terminating {
+			// let the previous pod finish its graceful shutdown first
+			log.FromContext(ctx).Info("waiting for bookkeeper pod to terminate")
+			return false, nil
+		}
+
 		labels := bk.LabelsForBookkeeperCluster()
-		pod, err := r.getOneOutdatedPod(sts, bk.Status.TargetVersion, labels)
+		pod, err := r.getOneOutdatedPod(ctx, sts, bk.Status.TargetVersion, labels)
 		if err != nil {
 			return false, err
 		}
 
 		if pod == nil {
-			pods, err := r.getStsPodsWithVersion(sts, bk.Status.TargetVersion)
+			pods, err := r.getStsPodsWithVersion(ctx, sts, bk.Status.TargetVersion)
 			if err != nil {
 				return false, err
 			}
 			if *sts.Spec.Replicas == (int32)(len(pods)) {
-				log.Infof("All bookkeeper pods are updated")
+				log.FromContext(ctx).Info("All bookkeeper pods are updated")
 				return false, nil
 			}
 			return false, fmt.Errorf("could not obtain outdated pod")
 		}
 
-		log.Infof("updating pod: %s", pod.Name)
+		if r.dryRun(ctx, bk, "delete pod %s to update it to version %s", pod.Name, bk.Status.TargetVersion) {
+			return false, nil
+		}
+		log.FromContext(ctx).Info("updating pod", "pod", pod.Name)
+		r.Recorder.Eventf(bk, corev1.EventTypeNormal, EventReasonUpgradeProgressed, "Updating bookie %s to version %s", pod.Name, bk.Status.TargetVersion)
 
-		err = r.Client.Delete(context.TODO(), pod)
+		err = r.Client.Delete(ctx, pod)
 		if err != nil && !errors.IsNotFound(err) {
 			return false, err
 		}
@@ -304,7 +321,7 @@ func (r *BookkeeperClusterReconciler) syncBookkeeperVersion(bk *bookkeeperv1alph
 	return false, nil
 }
 
-func (r *BookkeeperClusterReconciler) checkUpdatedPods(pods []*corev1.Pod, version string) (bool, error) {
+func (r *BookkeeperClusterReconciler) checkUpdatedPods(ctx context.Context, pods []*corev1.Pod, version string) (bool, error) {
 	for _, pod := range pods {
 		if !util.IsPodReady(pod) {
 			// At least one updated pod is still not ready, check if it is faulty.
@@ -317,7 +334,7 @@ func (r *BookkeeperClusterReconciler) checkUpdatedPods(pods []*corev1.Pod, versi
 	return true, nil
 }
 
-func (r *BookkeeperClusterReconciler) getOneOutdatedPod(sts *appsv1.StatefulSet, version string, labels map[string]string) (*corev1.Pod, error) {
+func (r *BookkeeperClusterReconciler) getOneOutdatedPod(ctx context.Context, sts *appsv1.StatefulSet, version string, labels map[string]string) (*corev1.Pod, error) {
 	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
 		MatchLabels: labels,
 	})
@@ -330,7 +347,7 @@ func (r *BookkeeperClusterReconciler) getOneOutdatedPod(sts *appsv1.StatefulSet,
 		Namespace:     sts.Namespace,
 		LabelSelector: selector,
 	}
-	err = r.Client.List(context.TODO(), podList, podlistOps)
+	err = r.Client.List(ctx, podList, podlistOps)
 	if err != nil {
 		return nil, err
 	}
@@ -348,7 +365,31 @@ func (r *BookkeeperClusterReconciler) getOneOutdatedPod(sts *appsv1.StatefulSet,
 	return nil, nil
 }
 
-func (r *BookkeeperClusterReconciler) getStsPodsWithVersion(sts *appsv1.StatefulSet, version string) ([]*corev1.Pod, error) {
+// hasTerminatingPod returns true if a pod of the stateful set is being deleted
+func (r *BookkeeperClusterReconciler) hasTerminatingPod(ctx context.Context, sts *appsv1.StatefulSet) (bool, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
+		MatchLabels: sts.Spec.Template.Labels,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to convert label selector: %v", err)
+	}
+	podList := &corev1.PodList{}
+	err = r.Client.List(ctx, podList, &client.ListOptions{
+		Namespace:     sts.Namespace,
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return false, err
+	}
+	for _, pod := range podList.Items {
+		if pod.DeletionTimestamp != nil {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *BookkeeperClusterReconciler) getStsPodsWithVersion(ctx context.Context, sts *appsv1.StatefulSet, version string) ([]*corev1.Pod, error) {
 	selector, err := metav1.LabelSelectorAsSelector(&metav1.LabelSelector{
 		MatchLabels: sts.Spec.Template.Labels,
 	})
@@ -356,16 +397,16 @@ func (r *BookkeeperClusterReconciler) getStsPodsWithVersion(sts *appsv1.Stateful
 		return nil, fmt.Errorf("failed to convert label selector: %v", err)
 	}
 
-	return r.getPodsWithVersion(selector, sts.Namespace, version)
+	return r.getPodsWithVersion(ctx, selector, sts.Namespace, version)
 }
 
-func (r *BookkeeperClusterReconciler) getPodsWithVersion(selector labels.Selector, namespace string, version string) ([]*corev1.Pod, error) {
+func (r *BookkeeperClusterReconciler) getPodsWithVersion(ctx context.Context, selector labels.Selector, namespace string, version string) ([]*corev1.Pod, error) {
 	podList := &corev1.PodList{}
 	podlistOps := &client.ListOptions{
 		Namespace:     namespace,
 		LabelSelector: selector,
 	}
-	err := r.Client.List(context.TODO(), podList, podlistOps)
+	err := r.Client.List(ctx, podList, podlistOps)
 	if err != nil {
 		return nil, err
 	}
@@ -23,6 +23,7 @@ import (
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
@@ -58,6 +59,8 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					Namespace: Namespace,
 				},
 			}
+			// the metadata of the cluster has already been initialized
+			b.Status.InstanceID = "a1b2c3d4-0000-0000-0000-000000000000"
 			s.AddKnownTypes(v1alpha1.GroupVersion, b)
 		})
 
@@ -65,12 +68,11 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 			var (
 				client client.Client
 				err    error
-				ctx    context.Context
 			)
 			BeforeEach(func() {
 				client = fake.NewFakeClient(b)
-				r = &BookkeeperClusterReconciler{Client: client, Scheme: s}
-				_, err = r.Reconcile(ctx, req)
+				r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+				_, err = r.Reconcile(context.TODO(), req)
 			})
 			Context("First reconcile", func() {
 				It("shouldn't error", func() {
@@ -82,7 +84,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					foundBookeeper *v1alpha1.BookkeeperCluster
 				)
 				BeforeEach(func() {
-					_, err = r.Reconcile(ctx, req)
+					_, err = r.Reconcile(context.TODO(), req)
 					foundBookeeper = &v1alpha1.BookkeeperCluster{}
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 				})
@@ -106,7 +108,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 					foundBookeeper.Status.SetUpgradingConditionTrue("UpgradeBookeeper", "0")
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 				})
 				It("Error should be nil when the target version is Empty", func() {
 					Ω(err).Should(BeNil())
@@ -116,7 +118,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					foundBookeeper.Status.TargetVersion = "0.6.1"
 					foundBookeeper.Status.CurrentVersion = "0.6.1"
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 					Ω(err).Should(BeNil())
 				})
 				It("Error should be not nil when the target version is not equal to current version", func() {
@@ -124,13 +126,13 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					foundBookeeper.Status.TargetVersion = "0.7.1"
 					foundBookeeper.Status.CurrentVersion = "0.6.1"
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 					Ω(strings.ContainsAny(err.Error(), "failed to get statefulset ()")).Should(Equal(true))
 				})
 				It("Error should be nil when cluster is in rollbackfailedstate", func() {
 					b.Status.SetErrorConditionTrue("RollbackFailed", " ")
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 					Ω(err).Should(BeNil())
 				})
 			})
@@ -144,7 +146,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 					foundBookeeper.Status.SetErrorConditionTrue("UpgradeFailed", " ")
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 				})
 				It("Error should be nil", func() {
 					Ω(err).Should(BeNil())
@@ -161,7 +163,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					foundBookeeper.Status.Init()
 					foundBookeeper.Status.SetErrorConditionTrue("RollbackFailed", " ")
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 				})
 				It("Error should be nil", func() {
 					Ω(err).Should(BeNil())
@@ -173,7 +175,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					foundBookeeper *v1alpha1.BookkeeperCluster
 				)
 				BeforeEach(func() {
-					_, _ = r.Reconcile(ctx, req)
+					_, _ = r.Reconcile(context.TODO(), req)
 					foundBookeeper = &v1alpha1.BookkeeperCluster{}
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 					foundBookeeper.Status.TargetVersion = foundBookeeper.Spec.Version
@@ -189,7 +191,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					sts.Status.Replicas = sts.Status.UpdatedReplicas
 					sts.Status.ReadyReplicas = sts.Status.UpdatedReplicas
 					r.Client.Update(context.TODO(), sts)
-					err = r.syncClusterVersion(foundBookeeper)
+					err = r.syncClusterVersion(context.TODO(), foundBookeeper)
 				})
 				It("Error should be nil", func() {
 					Ω(err).Should(BeNil())
@@ -205,7 +207,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 					foundBookeeper.Status.UpdateProgress("UpgradeErrorReason", "")
 					r.Client.Update(context.TODO(), foundBookeeper)
-					err = r.rollbackClusterVersion(foundBookeeper, "0.6.1")
+					err = r.rollbackClusterVersion(context.TODO(), foundBookeeper, "0.6.1")
 				})
 				It("Error should not be nil", func() {
 					Ω(strings.ContainsAny(err.Error(), "failed to get statefulset ()")).Should(Equal(true))
@@ -217,7 +219,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					foundBookeeper *v1alpha1.BookkeeperCluster
 				)
 				BeforeEach(func() {
-					_, _ = r.Reconcile(ctx, req)
+					_, _ = r.Reconcile(context.TODO(), req)
 					foundBookeeper = &v1alpha1.BookkeeperCluster{}
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 					foundBookeeper.Status.TargetVersion = foundBookeeper.Spec.Version
@@ -231,7 +233,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					sts.Status.Replicas = sts.Status.UpdatedReplicas
 					sts.Status.ReadyReplicas = sts.Status.UpdatedReplicas
 					r.Client.Update(context.TODO(), sts)
-					err = r.rollbackClusterVersion(foundBookeeper, "0.6.1")
+					err = r.rollbackClusterVersion(context.TODO(), foundBookeeper, "0.6.1")
 
 				})
 				It("Error should be nil", func() {
@@ -244,7 +246,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					pod     []*corev1.Pod
 				)
 				BeforeEach(func() {
-					boolean, err = r.checkUpdatedPods(pod, "0.7.1")
+					boolean, err = r.checkUpdatedPods(context.TODO(), pod, "0.7.1")
 				})
 				It("Error should be nil and bool value should be true", func() {
 					Ω(err).Should(BeNil())
@@ -258,7 +260,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 				BeforeEach(func() {
 					sts = &appsv1.StatefulSet{}
 					r.Client.Get(context.TODO(), types.NamespacedName{Name: util.StatefulSetNameForBookie(b.Name), Namespace: b.Namespace}, sts)
-					_, err = r.getOneOutdatedPod(sts, "0.6.1", labels)
+					_, err = r.getOneOutdatedPod(context.TODO(), sts, "0.6.1", labels)
 				})
 				It("Error should be nil", func() {
 					Ω(err).Should(BeNil())
@@ -286,7 +288,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					r.Client.Create(context.TODO(), testpod)
 					r.Client.Get(context.TODO(), types.NamespacedName{Name: "test", Namespace: "default"}, testpod)
 					pod = append(pod, testpod)
-					boolean, err = r.checkUpdatedPods(pod, "0.7.1")
+					boolean, err = r.checkUpdatedPods(context.TODO(), pod, "0.7.1")
 				})
 				It("Error should not be nil and bool value should be false", func() {
 					Ω(err).ShouldNot(BeNil())
@@ -311,7 +313,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					r.Client.Create(context.TODO(), testpod)
 					r.Client.Get(context.TODO(), types.NamespacedName{Name: "test", Namespace: "default"}, testpod)
 					pod = append(pod, testpod)
-					boolean, err = r.checkUpdatedPods(pod, "0.7.1")
+					boolean, err = r.checkUpdatedPods(context.TODO(), pod, "0.7.1")
 				})
 				It("Error should be nil and bool value should be false", func() {
 					Ω(err).Should(BeNil())
@@ -324,7 +326,7 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 				BeforeEach(func() {
 					sts = &appsv1.StatefulSet{}
 					r.Client.Get(context.TODO(), types.NamespacedName{Name: util.StatefulSetNameForBookie(b.Name), Namespace: b.Namespace}, sts)
-					_, err = r.getStsPodsWithVersion(sts, "0.6.1")
+					_, err = r.getStsPodsWithVersion(context.TODO(), sts, "0.6.1")
 				})
 				It("Error should be nil", func() {
 					Ω(err).Should(BeNil())
@@ -338,13 +340,13 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					sts                   *appsv1.StatefulSet
 				)
 				BeforeEach(func() {
-					_, _ = r.Reconcile(ctx, req)
-					b1, err = r.syncBookkeeperVersion(b)
+					_, _ = r.Reconcile(context.TODO(), req)
+					b1, err = r.syncBookkeeperVersion(context.TODO(), b)
 					foundBookeeper = &v1alpha1.BookkeeperCluster{}
 					_ = client.Get(context.TODO(), req.NamespacedName, foundBookeeper)
 					foundBookeeper.Status.TargetVersion = "0.7.1"
 					r.Client.Update(context.TODO(), foundBookeeper)
-					b2, err1 = r.syncBookkeeperVersion(foundBookeeper)
+					b2, err1 = r.syncBookkeeperVersion(context.TODO(), foundBookeeper)
 					foundBookeeper.Status.TargetVersion = foundBookeeper.Spec.Version
 					r.Client.Update(context.TODO(), foundBookeeper)
 					name := util.StatefulSetNameForBookie(foundBookeeper.Name)
@@ -354,12 +356,12 @@ var _ = Describe("Bookkeeper Cluster Version Sync", func() {
 					sts.Status.Replicas = sts.Status.UpdatedReplicas
 					sts.Status.ReadyReplicas = sts.Status.UpdatedReplicas
 					r.Client.Update(context.TODO(), sts)
-					b3, err2 = r.syncBookkeeperVersion(foundBookeeper)
+					b3, err2 = r.syncBookkeeperVersion(context.TODO(), foundBookeeper)
 					name = util.StatefulSetNameForBookie(foundBookeeper.Name)
 					_ = r.Client.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: foundBookeeper.Namespace}, sts)
 					sts.Status.UpdatedReplicas = sts.Status.Replicas - 1
 					r.Client.Update(context.TODO(), sts)
-					b4, err3 = r.syncBookkeeperVersion(foundBookeeper)
+					b4, err3 = r.syncBookkeeperVersion(context.TODO(), foundBookeeper)
 
 				})
 				It("Error should not be nil and b1 is false", func() {
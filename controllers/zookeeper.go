@@ -0,0 +1,317 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package controllers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	// ZookeeperTLSMountPath is where the ZooKeeper TLS Secret is mounted in
+	// the bookie containers
+	ZookeeperTLSMountPath = "/etc/zookeeper/tls"
+
+	// ZookeeperAuthMountPath is where the JAAS configuration of the ZooKeeper
+	// client is mounted in the bookie containers
+	ZookeeperAuthMountPath = "/etc/zookeeper/auth"
+
+	zookeeperTLSVolume  = "zookeeper-tls"
+	zookeeperAuthVolume = "zookeeper-auth"
+
+	// zookeeperInspectTimeout bounds the inspection of ZooKeeper on every
+	// reconcile, so that an outage does not hold the reconcile worker
+	zookeeperInspectTimeout = 3 * time.Second
+)
+
+// zookeeperConfig returns the configuration of the ZooKeeper client of the
+// operator, with the credentials and certificates read from the Secrets
+// given in spec.zookeeper
+func (r *BookkeeperClusterReconciler) zookeeperConfig(ctx context.Context, bk *v1alpha1.BookkeeperCluster) (*util.ZookeeperConfig, error) {
+	config := &util.ZookeeperConfig{ConnectString: bk.Spec.ZookeeperUri, Namespace: bk.Namespace}
+	if auth := bk.ZookeeperAuth(); auth != nil {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: auth.SecretName, Namespace: bk.Namespace}, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret (%s): %v", auth.SecretName, err)
+		}
+		config.Username = string(secret.Data["username"])
+		config.Password = string(secret.Data["password"])
+		if auth.GetScheme() == v1alpha1.ZookeeperAuthDigest && config.Username == "" {
+			return nil, fmt.Errorf("secret (%s) has no username for the digest scheme", auth.SecretName)
+		}
+	}
+	if zkTLS := bk.ZookeeperTLS(); zkTLS != nil {
+		secret := &corev1.Secret{}
+		err := r.Client.Get(ctx, types.NamespacedName{Name: zkTLS.SecretName, Namespace: bk.Namespace}, secret)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get secret (%s): %v", zkTLS.SecretName, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(secret.Data["ca.crt"]) {
+			return nil, fmt.Errorf("secret (%s) has no valid ca.crt", zkTLS.SecretName)
+		}
+		config.TLS = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return config, nil
+}
+
+// Zookeeper runs the ZooKeeper operations of the reconciler
+type Zookeeper interface {
+	// Check makes sure ZooKeeper is reachable with the given configuration
+	Check(config *util.ZookeeperConfig) error
+
+	// ListRegisteredBookies returns the bookies registered in read-write
+	// and in read-only mode under the given ledgers root, or the error of
+	// the context once it is done
+	ListRegisteredBookies(ctx context.Context, config *util.ZookeeperConfig, ledgersRoot string) (readWrite []string, readOnly []string, err error)
+
+	// ListAllZnodes returns the znodes of the given Pravega cluster
+	ListAllZnodes(config *util.ZookeeperConfig, pravegaClusterName string) ([]string, error)
+
+	// DeleteAllZnodes deletes the znodes of the given Pravega cluster
+	DeleteAllZnodes(ctx context.Context, config *util.ZookeeperConfig, pravegaClusterName string) error
+
+	// DeleteBookieCookie deletes the cookie of the given bookie
+	DeleteBookieCookie(ctx context.Context, config *util.ZookeeperConfig, ledgersRoot string, bookie string) error
+}
+
+// zookeeper connects to ZooKeeper for each operation
+type zookeeper struct{}
+
+func (zookeeper) Check(config *util.ZookeeperConfig) error {
+	return util.CheckZookeeper(config)
+}
+
+func (zookeeper) ListRegisteredBookies(ctx context.Context, config *util.ZookeeperConfig, ledgersRoot string) ([]string, []string, error) {
+	type result struct {
+		readWrite, readOnly []string
+		err                 error
+	}
+	// the connection closes on its own once the session times out
+	done := make(chan result, 1)
+	go func() {
+		readWrite, readOnly, err := util.ListRegisteredBookies(config, ledgersRoot)
+		done <- result{readWrite, readOnly, err}
+	}()
+	select {
+	case res := <-done:
+		return res.readWrite, res.readOnly, res.err
+	case <-ctx.Done():
+		return nil, nil, fmt.Errorf("failed to list bookies (%s): %v", config.ConnectString, ctx.Err())
+	}
+}
+
+func (zookeeper) ListAllZnodes(config *util.ZookeeperConfig, pravegaClusterName string) ([]string, error) {
+	return util.ListAllZnodes(config, pravegaClusterName)
+}
+
+func (zookeeper) DeleteAllZnodes(ctx context.Context, config *util.ZookeeperConfig, pravegaClusterName string) error {
+	return util.DeleteAllZnodes(ctx, config, pravegaClusterName)
+}
+
+func (zookeeper) DeleteBookieCookie(ctx context.Context, config *util.ZookeeperConfig, ledgersRoot string, bookie string) error {
+	return util.DeleteBookieCookie(ctx, config, ledgersRoot, bookie)
+}
+
+// zookeeper returns the Zookeeper of the reconciler, which connects to
+// ZooKeeper unless replaced
+func (r *BookkeeperClusterReconciler) zookeeper() Zookeeper {
+	if r.Zookeeper == nil {
+		return zookeeper{}
+	}
+	return r.Zookeeper
+}
+
+// reconcileZookeeperReachable checks, until the cluster metadata is
+// initialized, that the operator reaches ZooKeeper with the configuration of
+// the bookies, and reports it in the ZookeeperReachable condition. It returns
+// false while the metadata cannot be initialized.
+func (r *BookkeeperClusterReconciler) reconcileZookeeperReachable(ctx context.Context, bk *v1alpha1.BookkeeperCluster) (bool, error) {
+	if bk.Status.InstanceID != "" {
+		return true, nil
+	}
+	config, err := r.zookeeperConfig(ctx, bk)
+	if err == nil {
+		err = r.zookeeper().Check(config)
+	}
+	_, previous := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperReachable)
+	if err != nil {
+		message := err.Error()
+		if previous == nil || previous.Status != corev1.ConditionFalse || previous.Message != message {
+			log.FromContext(ctx).Info("zookeeper is not reachable", "zookeeperUri", bk.Spec.ZookeeperUri, "error", message)
+			r.Recorder.Event(bk, corev1.EventTypeWarning, EventReasonZookeeperUnreachable, message)
+			bk.Status.SetZookeeperReachableConditionFalse(EventReasonZookeeperUnreachable, message)
+			if err = r.Client.Status().Update(ctx, bk); err != nil {
+				return false, fmt.Errorf("failed to update cluster status: %v", err)
+			}
+		}
+		return false, nil
+	}
+	if previous == nil || previous.Status != corev1.ConditionTrue {
+		bk.Status.SetZookeeperReachableConditionTrue()
+		if err = r.Client.Status().Update(ctx, bk); err != nil {
+			return false, fmt.Errorf("failed to update cluster status: %v", err)
+		}
+	}
+	return true, nil
+}
+
+// reconcileZookeeperHealth inspects ZooKeeper and updates the status of the
+// cluster when the ZookeeperHealthy condition or the counts have changed
+func (r *BookkeeperClusterReconciler) reconcileZookeeperHealth(ctx context.Context, bk *v1alpha1.BookkeeperCluster) error {
+	var previous v1alpha1.ClusterCondition
+	if _, c := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperHealthy); c != nil {
+		previous = *c
+	}
+	available, readOnly := bk.Status.AvailableBookies, bk.Status.ReadOnlyBookies
+	r.inspectZookeeper(ctx, bk)
+	_, current := bk.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperHealthy)
+	if current.Status == previous.Status && current.Message == previous.Message &&
+		bk.Status.AvailableBookies == available && bk.Status.ReadOnlyBookies == readOnly {
+		return nil
+	}
+	if err := r.Client.Status().Update(ctx, bk); err != nil {
+		return fmt.Errorf("failed to update cluster status: %v", err)
+	}
+	return nil
+}
+
+// inspectZookeeper counts the bookies registered in ZooKeeper and sets the
+// ZookeeperHealthy condition, so that a ZooKeeper outage is told apart from
+// failing bookies. The counts are kept while ZooKeeper is unhealthy.
+func (r *BookkeeperClusterReconciler) inspectZookeeper(ctx context.Context, bk *v1alpha1.BookkeeperCluster) {
+	config, err := r.zookeeperConfig(ctx, bk)
+	if err != nil {
+		bk.Status.SetZookeeperHealthyConditionFalse("InvalidConfiguration", err.Error())
+		return
+	}
+	ledgersRoot, err := ledgersRootPath(ctx, r.Client, bk)
+	if err != nil {
+		bk.Status.SetZookeeperHealthyConditionFalse("InvalidConfiguration", err.Error())
+		return
+	}
+	config.Timeout = zookeeperInspectTimeout
+	listCtx, cancel := context.WithTimeout(ctx, zookeeperInspectTimeout)
+	defer cancel()
+	readWrite, readOnly, err := r.zookeeper().ListRegisteredBookies(listCtx, config, ledgersRoot)
+	if err != nil {
+		log.FromContext(ctx).Info("zookeeper is not healthy", "zookeeperUri", bk.Spec.ZookeeperUri, "error", err.Error())
+		bk.Status.SetZookeeperHealthyConditionFalse(EventReasonZookeeperUnreachable, err.Error())
+		return
+	}
+	bk.Status.AvailableBookies = int32(len(readWrite))
+	bk.Status.ReadOnlyBookies = int32(len(readOnly))
+	bk.Status.SetZookeeperHealthyConditionTrue()
+}
+
+// makeZookeeperClientJVMOpts returns the JVM options configuring the
+// ZooKeeper client of the bookies as the one of the operator
+func makeZookeeperClientJVMOpts(bk *v1alpha1.BookkeeperCluster) []string {
+	var opts []string
+	if bk.ZookeeperTLS() != nil {
+		opts = append(opts,
+			"-Dzookeeper.client.secure=true",
+			"-Dzookeeper.clientCnxnSocket=org.apache.zookeeper.ClientCnxnSocketNetty",
+			"-Dzookeeper.ssl.trustStore.location="+filepath.Join(ZookeeperTLSMountPath, "ca.crt"),
+			"-Dzookeeper.ssl.trustStore.type=PEM",
+		)
+	}
+	if bk.ZookeeperAuth() != nil {
+		opts = append(opts, "-Djava.security.auth.login.config="+filepath.Join(ZookeeperAuthMountPath, "jaas.conf"))
+	}
+	return opts
+}
+
+// addZookeeperClientVolumes mounts the ZooKeeper TLS Secret and the JAAS
+// configuration in the first container of the given pod spec. With the digest
+// scheme, the JAAS configuration is written by an init container from the
+// username and password of the Secret.
+func addZookeeperClientVolumes(bk *v1alpha1.BookkeeperCluster, podSpec *corev1.PodSpec) {
+	if zkTLS := bk.ZookeeperTLS(); zkTLS != nil {
+		addSecretVolume(podSpec, zookeeperTLSVolume, zkTLS.SecretName, ZookeeperTLSMountPath)
+	}
+	auth := bk.ZookeeperAuth()
+	if auth == nil {
+		return
+	}
+	if auth.GetScheme() == v1alpha1.ZookeeperAuthJAAS {
+		addSecretVolume(podSpec, zookeeperAuthVolume, auth.SecretName, ZookeeperAuthMountPath)
+		return
+	}
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: zookeeperAuthVolume,
+		VolumeSource: corev1.VolumeSource{
+			EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory},
+		},
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      zookeeperAuthVolume,
+		MountPath: ZookeeperAuthMountPath,
+		ReadOnly:  true,
+	})
+	var env []corev1.EnvVar
+	for _, e := range []struct{ name, key string }{{"ZK_USERNAME", "username"}, {"ZK_PASSWORD", "password"}} {
+		env = append(env, corev1.EnvVar{
+			Name: e.name,
+			ValueFrom: &corev1.EnvVarSource{
+				SecretKeyRef: &corev1.SecretKeySelector{
+					LocalObjectReference: corev1.LocalObjectReference{Name: auth.SecretName},
+					Key:                  e.key,
+				},
+			},
+		})
+	}
+	podSpec.InitContainers = append(podSpec.InitContainers, corev1.Container{
+		Name:            "zookeeper-jaas",
+		Image:           bk.BookkeeperImage(),
+		ImagePullPolicy: bk.Spec.Image.PullPolicy,
+		Command:         []string{"/bin/sh", "-c", zookeeperDigestJAASScript},
+		Env:             env,
+		VolumeMounts: []corev1.VolumeMount{
+			{
+				Name:      zookeeperAuthVolume,
+				MountPath: ZookeeperAuthMountPath,
+			},
+		},
+		SecurityContext: bk.Spec.ContainerSecurityContext,
+	})
+}
+
+// zookeeperDigestJAASScript writes the JAAS configuration authenticating the
+// ZooKeeper client with the DigestLoginModule. The backslashes and double
+// quotes of the credentials are escaped, since they are quoted in the file.
+const zookeeperDigestJAASScript = `escape() { printf '%s' "$1" | sed 's/[\\"]/\\&/g'; }
+printf 'Client {\n  org.apache.zookeeper.server.auth.DigestLoginModule required\n  username="%s"\n  password="%s";\n};\n' "$(escape "$ZK_USERNAME")" "$(escape "$ZK_PASSWORD")" > ` + ZookeeperAuthMountPath + `/jaas.conf`
+
+func addSecretVolume(podSpec *corev1.PodSpec, volume string, secret string, mountPath string) {
+	podSpec.Volumes = append(podSpec.Volumes, corev1.Volume{
+		Name: volume,
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secret},
+		},
+	})
+	podSpec.Containers[0].VolumeMounts = append(podSpec.Containers[0].VolumeMounts, corev1.VolumeMount{
+		Name:      volume,
+		MountPath: mountPath,
+		ReadOnly:  true,
+	})
+}
@@ -0,0 +1,285 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package controllers
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func makeTestCACert() []byte {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "zookeeper-ca"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+// patchFailingClient fails the objects applied by the reconciler
+type patchFailingClient struct {
+	client.Client
+}
+
+func (c patchFailingClient) Patch(context.Context, client.Object, client.Patch, ...client.PatchOption) error {
+	return fmt.Errorf("connection refused")
+}
+
+var _ = Describe("ZooKeeper client", func() {
+	const (
+		Name      = "example"
+		Namespace = "default"
+	)
+
+	var (
+		s      = scheme.Scheme
+		r      *BookkeeperClusterReconciler
+		b      *v1alpha1.BookkeeperCluster
+		client client.Client
+	)
+
+	BeforeEach(func() {
+		b = &v1alpha1.BookkeeperCluster{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      Name,
+				Namespace: Namespace,
+			},
+			Spec: v1alpha1.BookkeeperClusterSpec{
+				ZookeeperUri: "zk-0.zk.zookeeper:2181,zk-1.zk.zookeeper:2181/bookkeeper",
+				Zookeeper: &v1alpha1.ZookeeperClientSpec{
+					Auth: &v1alpha1.ZookeeperAuthSpec{Scheme: v1alpha1.ZookeeperAuthJAAS, SecretName: "zk-auth"},
+					TLS:  &v1alpha1.ZookeeperTLSSpec{SecretName: "zk-tls"},
+				},
+			},
+		}
+		b.WithDefaults()
+		s.AddKnownTypes(v1alpha1.GroupVersion, b)
+		auth := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "zk-auth", Namespace: Namespace},
+			Data: map[string][]byte{
+				"username":  []byte("bookie"),
+				"password":  []byte("secret"),
+				"jaas.conf": []byte("Client {};"),
+			},
+		}
+		tls := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "zk-tls", Namespace: Namespace},
+			Data:       map[string][]byte{"ca.crt": makeTestCACert()},
+		}
+		client = fake.NewClientBuilder().WithScheme(scheme.Scheme).WithRuntimeObjects(b, auth, tls).Build()
+		r = &BookkeeperClusterReconciler{Client: applyClient{client}, Scheme: s, Zookeeper: &fakeZookeeper{}, Recorder: record.NewFakeRecorder(100)}
+	})
+
+	Context("zookeeperConfig", func() {
+		It("should read the credentials and the certificate authority from the secrets", func() {
+			config, err := r.zookeeperConfig(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(config.ConnectString).Should(Equal(b.Spec.ZookeeperUri))
+			Ω(config.Namespace).Should(Equal(Namespace))
+			Ω(config.Username).Should(Equal("bookie"))
+			Ω(config.Password).Should(Equal("secret"))
+			Ω(config.TLS).ShouldNot(BeNil())
+			Ω(config.TLS.RootCAs).ShouldNot(BeNil())
+		})
+		It("should fail without certificate authority", func() {
+			b.Spec.Zookeeper.TLS.SecretName = "zk-auth"
+			_, err := r.zookeeperConfig(context.TODO(), b)
+			Ω(err.Error()).Should(ContainSubstring("no valid ca.crt"))
+		})
+		It("should fail when the secret is missing", func() {
+			b.Spec.Zookeeper.Auth.SecretName = "missing"
+			_, err := r.zookeeperConfig(context.TODO(), b)
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("reconcileZookeeperReachable", func() {
+		var zk *fakeZookeeper
+
+		BeforeEach(func() {
+			zk = &fakeZookeeper{checkErr: fmt.Errorf("zk: could not connect to a server")}
+			r.Zookeeper = zk
+		})
+		It("should report an unreachable zookeeper in the status", func() {
+			reachable, err := r.reconcileZookeeperReachable(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(reachable).Should(BeFalse())
+			_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperReachable)
+			Ω(condition.Status).Should(Equal(corev1.ConditionFalse))
+			Ω(condition.Message).Should(ContainSubstring("could not connect"))
+		})
+		It("should set the condition once zookeeper is reachable", func() {
+			r.reconcileZookeeperReachable(context.TODO(), b)
+			zk.checkErr = nil
+			reachable, err := r.reconcileZookeeperReachable(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(reachable).Should(BeTrue())
+			_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperReachable)
+			Ω(condition.Status).Should(Equal(corev1.ConditionTrue))
+		})
+		It("should not check zookeeper once the metadata is initialized", func() {
+			b.Status.InstanceID = "instance"
+			reachable, err := r.reconcileZookeeperReachable(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			Ω(reachable).Should(BeTrue())
+		})
+		It("should not block the bookies deployed before", func() {
+			Ω(client.Create(context.TODO(), MakeBookieStatefulSet(b))).Should(Succeed())
+			err := r.run(context.TODO(), b)
+			Ω(err).Should(BeNil())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-metadata-init", Namespace: Namespace}, &batchv1.Job{})
+			Ω(errors.IsNotFound(err)).Should(BeTrue())
+			err = client.Get(context.TODO(), types.NamespacedName{Name: "example-bookie-headless", Namespace: Namespace}, &corev1.Service{})
+			Ω(err).Should(BeNil())
+		})
+	})
+
+	Context("inspectZookeeper", func() {
+		var zk *fakeZookeeper
+
+		BeforeEach(func() {
+			zk = &fakeZookeeper{
+				readWrite: []string{"bookie-0:3181", "bookie-1:3181"},
+				readOnly:  []string{"bookie-2:3181"},
+			}
+			r.Zookeeper = zk
+		})
+		It("should count the registered bookies", func() {
+			r.inspectZookeeper(context.TODO(), b)
+			Ω(b.Status.AvailableBookies).Should(Equal(int32(2)))
+			Ω(b.Status.ReadOnlyBookies).Should(Equal(int32(1)))
+			_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperHealthy)
+			Ω(condition.Status).Should(Equal(corev1.ConditionTrue))
+		})
+		It("should report a zookeeper outage and keep the counts", func() {
+			r.inspectZookeeper(context.TODO(), b)
+			zk.listErr = fmt.Errorf("zk: could not connect to a server")
+			r.inspectZookeeper(context.TODO(), b)
+			Ω(b.Status.AvailableBookies).Should(Equal(int32(2)))
+			_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperHealthy)
+			Ω(condition.Status).Should(Equal(corev1.ConditionFalse))
+			Ω(condition.Reason).Should(Equal(EventReasonZookeeperUnreachable))
+		})
+		It("should report the health of zookeeper when a phase fails", func() {
+			zk.listErr = fmt.Errorf("zk: could not connect to a server")
+			r.Client = patchFailingClient{applyClient{client}}
+			err := r.run(context.TODO(), b)
+			Ω(err).ShouldNot(BeNil())
+			found := &v1alpha1.BookkeeperCluster{}
+			client.Get(context.TODO(), types.NamespacedName{Name: Name, Namespace: Namespace}, found)
+			_, condition := found.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperHealthy)
+			Ω(condition).ShouldNot(BeNil())
+			Ω(condition.Status).Should(Equal(corev1.ConditionFalse))
+		})
+		It("should give up on an unresponsive zookeeper", func() {
+			zk.listErr = nil
+			zk.block = true
+			start := time.Now()
+			r.inspectZookeeper(context.TODO(), b)
+			Ω(time.Since(start)).Should(BeNumerically("<", 2*zookeeperInspectTimeout))
+			_, condition := b.Status.GetClusterCondition(v1alpha1.ClusterConditionZookeeperHealthy)
+			Ω(condition.Status).Should(Equal(corev1.ConditionFalse))
+		})
+	})
+
+	Context("Bookies", func() {
+		It("should configure the ZooKeeper client of the bookies", func() {
+			cm := MakeBookieConfigMap(b)
+			Ω(cm.Data["BOOKIE_EXTRA_OPTS"]).Should(ContainSubstring("-Dzookeeper.client.secure=true"))
+			Ω(cm.Data["BOOKIE_EXTRA_OPTS"]).Should(ContainSubstring("-Dzookeeper.ssl.trustStore.location=/etc/zookeeper/tls/ca.crt"))
+			Ω(cm.Data["BOOKIE_EXTRA_OPTS"]).Should(ContainSubstring("-Djava.security.auth.login.config=/etc/zookeeper/auth/jaas.conf"))
+			Ω(cm.Data["ZK_URL"]).Should(Equal(b.Spec.ZookeeperUri))
+		})
+		It("should mount the secrets in the bookies and the jobs", func() {
+			podSpec := makeBookiePodSpec(b)
+			jobSpec := MakeBookieMetadataInitJob(b).Spec.Template.Spec
+			for _, spec := range []*corev1.PodSpec{podSpec, &jobSpec} {
+				Ω(spec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "zookeeper-tls", MountPath: ZookeeperTLSMountPath, ReadOnly: true}))
+				Ω(spec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "zookeeper-auth", MountPath: ZookeeperAuthMountPath, ReadOnly: true}))
+			}
+		})
+		It("should only enable the zookeeper security of the bookies through the options", func() {
+			Ω(MakeBookieConfigMap(b).Data).ShouldNot(HaveKey("BK_zkEnableSecurity"))
+			b.Spec.Options["zkEnableSecurity"] = "true"
+			Ω(MakeBookieConfigMap(b).Data).Should(HaveKeyWithValue("BK_zkEnableSecurity", "true"))
+		})
+		It("should write the jaas configuration of the bookies with the digest scheme", func() {
+			b.Spec.Zookeeper.Auth.Scheme = v1alpha1.ZookeeperAuthDigest
+			podSpec := makeBookiePodSpec(b)
+			jobSpec := MakeBookieMetadataInitJob(b).Spec.Template.Spec
+			for _, spec := range []*corev1.PodSpec{podSpec, &jobSpec} {
+				Ω(spec.Volumes).Should(ContainElement(corev1.Volume{
+					Name:         "zookeeper-auth",
+					VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{Medium: corev1.StorageMediumMemory}},
+				}))
+				Ω(spec.Containers[0].VolumeMounts).Should(ContainElement(corev1.VolumeMount{Name: "zookeeper-auth", MountPath: ZookeeperAuthMountPath, ReadOnly: true}))
+				var initContainer *corev1.Container
+				for i := range spec.InitContainers {
+					if spec.InitContainers[i].Name == "zookeeper-jaas" {
+						initContainer = &spec.InitContainers[i]
+					}
+				}
+				Ω(initContainer).ShouldNot(BeNil())
+				Ω(initContainer.Command[2]).Should(ContainSubstring("DigestLoginModule"))
+				Ω(initContainer.Command[2]).Should(ContainSubstring("> /etc/zookeeper/auth/jaas.conf"))
+				Ω(initContainer.Env).Should(HaveLen(2))
+				Ω(initContainer.Env[1].ValueFrom.SecretKeyRef.Name).Should(Equal("zk-auth"))
+				Ω(initContainer.Env[1].ValueFrom.SecretKeyRef.Key).Should(Equal("password"))
+			}
+			Ω(MakeBookieConfigMap(b).Data["BOOKIE_EXTRA_OPTS"]).Should(ContainSubstring("-Djava.security.auth.login.config=/etc/zookeeper/auth/jaas.conf"))
+		})
+		It("should escape the credentials in the jaas configuration", func() {
+			dir, err := os.MkdirTemp("", "jaas")
+			Ω(err).Should(BeNil())
+			defer os.RemoveAll(dir)
+			cmd := exec.Command("/bin/sh", "-c", strings.Replace(zookeeperDigestJAASScript, ZookeeperAuthMountPath, dir, 1))
+			cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "ZK_USERNAME=bookkeeper", `ZK_PASSWORD=pa"ss\word`}
+			Ω(cmd.Run()).Should(Succeed())
+			jaas, err := os.ReadFile(filepath.Join(dir, "jaas.conf"))
+			Ω(err).Should(BeNil())
+			Ω(string(jaas)).Should(ContainSubstring(`username="bookkeeper"`))
+			Ω(string(jaas)).Should(ContainSubstring(`password="pa\"ss\\word";`))
+		})
+		It("should hash the secrets", func() {
+			_, secrets := bookieConfigReferences(b)
+			Ω(secrets).Should(Equal([]string{"zk-auth", "zk-tls"}))
+		})
+	})
+})
@@ -25,20 +25,25 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"time"
 
 	bookkeeperv1alpha1 "github.com/pravega/bookkeeper-operator/api/v1alpha1"
 	"github.com/pravega/bookkeeper-operator/controllers"
+	"github.com/pravega/bookkeeper-operator/pkg/backup"
 	controllerconfig "github.com/pravega/bookkeeper-operator/pkg/controller/config"
 	"github.com/pravega/bookkeeper-operator/pkg/util"
 	"github.com/pravega/bookkeeper-operator/pkg/version"
-	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
 	apimachineryruntime "k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/oidc"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/config"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	//+kubebuilder:scaffold:imports
@@ -56,6 +61,7 @@ func init() {
 	flag.BoolVar(&controllerconfig.TestMode, "test", false, "Enable test mode. Do not use this flag in production")
 	flag.BoolVar(&controllerconfig.DisableFinalizer, "disableFinalizer", false, "Disable finalizers for bookkeeperclusters. Use this flag with awareness of the consequences")
 	flag.BoolVar(&webhookFlag, "webhook", true, "Enable webhook, the default is enabled.")
+	flag.BoolVar(&controllerconfig.DryRun, "dry-run", false, "Log and record the pod, PVC and znode deletions and the bookie formatting of all bookkeeper clusters instead of running them.")
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(bookkeeperv1alpha1.AddToScheme(scheme))
 }
@@ -68,12 +74,35 @@ func printVersion() {
 }
 
 func main() {
+	// the backup and restore Jobs run the operator binary with a command
+	if len(os.Args) > 1 && (os.Args[1] == backup.CommandBackup || os.Args[1] == backup.CommandRestore) {
+		ctrl.SetLogger(zap.New())
+		if err := backup.Run(ctrl.LoggerInto(context.TODO(), ctrl.Log.WithName(os.Args[1])), os.Args[1], os.Args[2:]); err != nil {
+			log.Error(err, os.Args[1]+" failed")
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	var metricsAddr string
+	var maxConcurrentReconciles int
+	var operatorImage string
+	var leaderElect bool
+	var leaseDuration, renewDeadline time.Duration
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "127.0.0.1:6000", "The address the metric endpoint binds to.")
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 1, "The number of BookkeeperClusters reconciled concurrently.")
+	flag.StringVar(&operatorImage, "operator-image", os.Getenv("OPERATOR_IMAGE"), "The image of the operator, run by the metadata backup and restore Jobs. Defaults to the image of the operator pod.")
+	flag.BoolVar(&leaderElect, "leader-elect", false, "Elect the leader with a Lease instead of the leader-for-life ConfigMap lock.")
+	flag.DurationVar(&leaseDuration, "leader-election-lease-duration", 15*time.Second, "The duration the other operator pods wait before taking over a Lease that is not renewed.")
+	flag.DurationVar(&renewDeadline, "leader-election-renew-deadline", 10*time.Second, "The duration the leader retries renewing its Lease before stopping.")
 
+	// --zap-encoder selects json or console output, --zap-log-level the
+	// log level (debug, info, error or an integer verbosity)
+	opts := zap.Options{}
+	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseDevMode(false)))
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
 	printVersion()
 
@@ -82,17 +111,22 @@ func main() {
 	}
 
 	if controllerconfig.TestMode {
-		logrus.Warn("----- Running in test mode. Make sure you are NOT in production -----")
+		log.Info("----- Running in test mode. Make sure you are NOT in production -----")
 	}
 
 	if controllerconfig.DisableFinalizer {
-		logrus.Warn("----- Running with finalizer disabled. -----")
+		log.Info("----- Running with finalizer disabled. -----")
+	}
+
+	if controllerconfig.DryRun {
+		log.Info("----- Running in dry run mode, destructive operations are not executed. -----")
 	}
 
 	// Get a config to talk to the apiserver
 	cfg, err := config.GetConfig()
 	if err != nil {
-		logrus.Fatal(err)
+		log.Error(err, "failed to get the apiserver config")
+		os.Exit(1)
 	}
 
 	operatorNs, err := GetOperatorNamespace()
@@ -101,25 +135,70 @@ func main() {
 		os.Exit(1)
 	}
 
-	namespaces, err := getWatchNamespace()
-	if err != nil {
-		log.Error(err, "unable to get WatchNamespace, "+
-			"the manager will watch and manage resources in all namespaces")
+	if operatorImage == "" {
+		// the Jobs run the binary of the running operator
+		operatorImage, err = util.GetOperatorImage(context.TODO(), cfg, operatorNs)
+		if err != nil {
+			log.Error(err, "failed to get the operator image, the metadata backup and restore Jobs are disabled")
+		}
 	}
 
-	// Become the leader before proceeding
-	err = util.BecomeLeader(context.TODO(), cfg, "bookkeeper-operator-lock", operatorNs)
+	namespaces, err := getWatchNamespaces()
 	if err != nil {
-		log.Error(err, "")
+		log.Error(err, "failed to get the watched namespaces")
 		os.Exit(1)
 	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	options := ctrl.Options{
 		Scheme:             scheme,
-		Namespace:          namespaces,
 		MetricsBindAddress: metricsAddr,
 		Port:               9443,
-	})
+	}
+	leaderCtx := ctrl.LoggerInto(context.TODO(), ctrl.Log.WithName("leader"))
+	if leaderElect {
+		if renewDeadline >= leaseDuration {
+			log.Error(errors.New("--leader-election-renew-deadline must be shorter than --leader-election-lease-duration"), "invalid leader election durations")
+			os.Exit(1)
+		}
+		// an operator upgraded from the ConfigMap lock must not run next
+		// to its previous version
+		err = util.WaitForLegacyLeaderLock(leaderCtx, cfg, "bookkeeper-operator-lock", operatorNs)
+		if err != nil {
+			log.Error(err, "failed to wait for the leader lock")
+			os.Exit(1)
+		}
+		log.Info("electing the leader with a Lease", "leaseDuration", leaseDuration, "renewDeadline", renewDeadline)
+		options.LeaderElection = true
+		options.LeaderElectionResourceLock = resourcelock.LeasesResourceLock
+		options.LeaderElectionID = "bookkeeper-operator-leader-election"
+		options.LeaderElectionNamespace = operatorNs
+		options.LeaderElectionReleaseOnCancel = true
+		options.LeaseDuration = &leaseDuration
+		options.RenewDeadline = &renewDeadline
+	} else {
+		// Become the leader before proceeding
+		err = util.BecomeLeader(leaderCtx, cfg, "bookkeeper-operator-lock", operatorNs)
+		if err != nil {
+			log.Error(err, "")
+			os.Exit(1)
+		}
+	}
+	switch len(namespaces) {
+	case 0:
+		log.Info("watching all namespaces")
+	case 1:
+		log.Info("watching a single namespace", "namespace", namespaces[0])
+		options.Namespace = namespaces[0]
+	default:
+		log.Info("watching several namespaces", "namespaces", namespaces)
+		options.NewCache = cache.MultiNamespacedCacheBuilder(namespaces)
+	}
+	// only the labeled ConfigMaps and Secrets are watched, the others are
+	// read from the API server
+	options.NewCache = controllers.NewCacheFunc(options.NewCache)
+	options.ClientDisableCacheFor = []client.Object{&corev1.ConfigMap{}, &corev1.Secret{}}
+
+	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		log.Error(err, "unable to start manager")
 		os.Exit(1)
@@ -128,14 +207,27 @@ func main() {
 	log.Info("Registering Components")
 
 	if err = (&controllers.BookkeeperClusterReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("bookkeeper-operator"),
+
+		OperatorImage:           operatorImage,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		log.Error(err, "unable to create controller", "controller", "BookkeeperCluster")
 		os.Exit(1)
 	}
 
-	bookkeeperv1alpha1.Mgr = mgr
+	if err = (&controllers.BookkeeperBackupReconciler{
+		Client:        mgr.GetClient(),
+		Scheme:        mgr.GetScheme(),
+		Recorder:      mgr.GetEventRecorderFor("bookkeeper-operator"),
+		OperatorImage: operatorImage,
+	}).SetupWithManager(mgr); err != nil {
+		log.Error(err, "unable to create controller", "controller", "BookkeeperBackup")
+		os.Exit(1)
+	}
+
 	if webhookFlag {
 		if err = (&bookkeeperv1alpha1.BookkeeperCluster{}).SetupWebhookWithManager(mgr); err != nil {
 			log.Error(err, "unable to create webhook", "webhook", "BookkeeperCluster")
@@ -167,16 +259,16 @@ func GetOperatorNamespace() (string, error) {
 	return ns, nil
 }
 
-// getWatchNamespace returns the Namespace the operator should be watching for changes
-func getWatchNamespace() (string, error) {
+// getWatchNamespaces returns the Namespaces the operator should be watching for changes
+func getWatchNamespaces() ([]string, error) {
 	// WatchNamespaceEnvVar is the constant for env variable WATCH_NAMESPACE
-	// which specifies the Namespace to watch.
+	// which specifies the comma-separated Namespaces to watch.
 	// An empty value means the operator is running with cluster scope.
 	var watchNamespaceEnvVar = "WATCH_NAMESPACE"
 
 	ns, found := os.LookupEnv(watchNamespaceEnvVar)
 	if !found {
-		return "", fmt.Errorf("%s must be set", watchNamespaceEnvVar)
+		return nil, fmt.Errorf("%s must be set to the comma-separated namespaces to watch, or to an empty value to watch all namespaces", watchNamespaceEnvVar)
 	}
-	return ns, nil
+	return util.ParseWatchNamespaces(ns)
 }
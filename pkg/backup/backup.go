@@ -0,0 +1,220 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+// Package backup implements the backup and restore commands of the operator
+// binary, run by the backup and restore Jobs to copy the ZooKeeper metadata
+// of a cluster to and from a PVC or S3 compatible storage.
+package backup
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pravega/bookkeeper-operator/pkg/util"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+const (
+	CommandBackup  = "backup"
+	CommandRestore = "restore"
+)
+
+type options struct {
+	zookeeperUri    string
+	zookeeperCAFile string
+	ledgersRoot     string
+	location        string
+	snapshot        string
+	cluster         string
+	skipCookies     bool
+}
+
+// Run runs the backup or the restore command with the given arguments
+func Run(ctx context.Context, command string, args []string) error {
+	o := &options{}
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	fs.StringVar(&o.zookeeperUri, "zookeeper-uri", "", "The ZooKeeper connect string of the cluster.")
+	fs.StringVar(&o.zookeeperCAFile, "zookeeper-ca-file", "", "The certificate authority of the ZooKeeper servers, if TLS is enabled.")
+	fs.StringVar(&o.ledgersRoot, "ledgers-root", "", "The ledgers root path of the cluster.")
+	fs.StringVar(&o.location, "location", "", "Where the snapshots are stored, file:///<directory> or s3://<bucket>/<prefix>.")
+	fs.StringVar(&o.snapshot, "snapshot", "", "The name of the snapshot. Backups default to <cluster>-<timestamp>.")
+	fs.StringVar(&o.cluster, "cluster", "", "The name of the cluster.")
+	fs.BoolVar(&o.skipCookies, "skip-cookies", false, "Do not restore the cookies of the bookies, whose volumes are new.")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if o.zookeeperUri == "" || o.ledgersRoot == "" || o.location == "" {
+		return fmt.Errorf("--zookeeper-uri, --ledgers-root and --location should be set")
+	}
+	config, err := o.zookeeperConfig()
+	if err != nil {
+		return err
+	}
+	store, err := newStore(o.location)
+	if err != nil {
+		return err
+	}
+
+	switch command {
+	case CommandBackup:
+		return backup(ctx, config, store, o)
+	case CommandRestore:
+		return restore(ctx, config, store, o)
+	}
+	return fmt.Errorf("unknown command %s", command)
+}
+
+// zookeeperConfig returns the ZooKeeper client configuration, with the
+// digest credentials read from the ZK_USERNAME and ZK_PASSWORD variables
+func (o *options) zookeeperConfig() (*util.ZookeeperConfig, error) {
+	config := &util.ZookeeperConfig{
+		ConnectString: o.zookeeperUri,
+		Username:      os.Getenv("ZK_USERNAME"),
+		Password:      os.Getenv("ZK_PASSWORD"),
+	}
+	if o.zookeeperCAFile != "" {
+		ca, err := ioutil.ReadFile(o.zookeeperCAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate in %s", o.zookeeperCAFile)
+		}
+		config.TLS = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return config, nil
+}
+
+func backup(ctx context.Context, config *util.ZookeeperConfig, store store, o *options) error {
+	snapshot, err := util.BackupZnodes(ctx, config, o.ledgersRoot)
+	if err != nil {
+		return err
+	}
+	name := o.snapshot
+	if name == "" {
+		name = fmt.Sprintf("%s-%s", o.cluster, SnapshotTimestamp(snapshot.Time))
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err = store.write(ctx, name+".json", data); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("snapshot written", "snapshot", name, "location", o.location)
+	return nil
+}
+
+func restore(ctx context.Context, config *util.ZookeeperConfig, store store, o *options) error {
+	if o.snapshot == "" {
+		return fmt.Errorf("--snapshot should be set")
+	}
+	data, err := store.read(ctx, o.snapshot+".json")
+	if err != nil {
+		return err
+	}
+	snapshot := &util.MetadataSnapshot{}
+	if err = json.Unmarshal(data, snapshot); err != nil {
+		return fmt.Errorf("invalid snapshot %s: %v", o.snapshot, err)
+	}
+	relocate(snapshot, o.ledgersRoot)
+	if o.skipCookies {
+		dropCookies(snapshot)
+	}
+	if err = util.RestoreZnodes(ctx, config, snapshot); err != nil {
+		return err
+	}
+	log.FromContext(ctx).Info("snapshot restored", "snapshot", o.snapshot, "ledgersRoot", o.ledgersRoot)
+	return nil
+}
+
+// dropCookies removes the cookies of the bookies from the snapshot. Bookies
+// starting on new volumes refuse a cookie registered for their address, and
+// register a new one when there is none.
+func dropCookies(snapshot *util.MetadataSnapshot) {
+	prefix := snapshot.Root + "/cookies/"
+	znodes := snapshot.Znodes[:0]
+	for _, znode := range snapshot.Znodes {
+		if !strings.HasPrefix(znode.Path, prefix) {
+			znodes = append(znodes, znode)
+		}
+	}
+	snapshot.Znodes = znodes
+}
+
+// relocate moves the znodes of the snapshot under the given root, so that a
+// snapshot can be restored to a cluster with another ledgers root path
+func relocate(snapshot *util.MetadataSnapshot, root string) {
+	if snapshot.Root == root {
+		return
+	}
+	for i := range snapshot.Znodes {
+		snapshot.Znodes[i].Path = root + strings.TrimPrefix(snapshot.Znodes[i].Path, snapshot.Root)
+	}
+	snapshot.Root = root
+}
+
+// store is where the snapshots are kept
+type store interface {
+	write(ctx context.Context, name string, data []byte) error
+	read(ctx context.Context, name string) ([]byte, error)
+}
+
+func newStore(location string) (store, error) {
+	u, err := url.Parse(location)
+	if err != nil {
+		return nil, fmt.Errorf("invalid location %s: %v", location, err)
+	}
+	switch u.Scheme {
+	case "file":
+		return fileStore{dir: u.Path}, nil
+	case "s3":
+		return newS3Store(u.Host, strings.Trim(u.Path, "/"))
+	}
+	return nil, fmt.Errorf("unsupported location %s, expected file:// or s3://", location)
+}
+
+type fileStore struct {
+	dir string
+}
+
+func (s fileStore) write(ctx context.Context, name string, data []byte) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	// write to a temporary file first, so that a failed backup does not
+	// leave a truncated snapshot behind
+	tmp := filepath.Join(s.dir, "."+name+".tmp")
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(s.dir, name))
+}
+
+func (s fileStore) read(ctx context.Context, name string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(s.dir, name))
+}
+
+// SnapshotTimestamp returns the time formatted as in the generated names of
+// the snapshots
+func SnapshotTimestamp(t time.Time) string {
+	return t.UTC().Format("20060102-150405")
+}
@@ -0,0 +1,91 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// s3Store keeps the snapshots as objects of a bucket of S3 compatible storage
+type s3Store struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// newS3Store connects to the storage given by the S3_ENDPOINT and S3_REGION
+// environment variables. The credentials are the AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables if set,
+// and the web identity token of the service account (IRSA) or the instance
+// profile otherwise.
+func newS3Store(bucket string, prefix string) (store, error) {
+	region := os.Getenv("S3_REGION")
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := os.Getenv("S3_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint %s", endpoint)
+	}
+	client, err := minio.New(u.Host, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.IAM{Client: &http.Client{Transport: http.DefaultTransport}},
+		}),
+		Secure: u.Scheme != "http",
+		Region: region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint %s: %v", endpoint, err)
+	}
+	return s3Store{client: client, bucket: bucket, prefix: prefix}, nil
+}
+
+func (s s3Store) key(name string) string {
+	if s.prefix == "" {
+		return name
+	}
+	return s.prefix + "/" + name
+}
+
+func (s s3Store) write(ctx context.Context, name string, data []byte) error {
+	_, err := s.client.PutObject(ctx, s.bucket, s.key(name), bytes.NewReader(data), int64(len(data)),
+		minio.PutObjectOptions{ContentType: "application/json"})
+	if err != nil {
+		return fmt.Errorf("failed to put object (%s/%s): %v", s.bucket, s.key(name), err)
+	}
+	return nil
+}
+
+func (s s3Store) read(ctx context.Context, name string) ([]byte, error) {
+	object, err := s.client.GetObject(ctx, s.bucket, s.key(name), minio.GetObjectOptions{})
+	if err == nil {
+		defer object.Close()
+		var data []byte
+		if data, err = ioutil.ReadAll(object); err == nil {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("failed to get object (%s/%s): %v", s.bucket, s.key(name), err)
+}
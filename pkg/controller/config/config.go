@@ -21,3 +21,9 @@ var TestMode bool
 // NOTE: enabling this flag with caution! It causes stale znode data in zk and
 // leads to conflicts with subsequent bookkeeper clusters deployments
 var DisableFinalizer bool
+
+// DryRun makes the operator log and record as events the destructive
+// operations on all bookkeeper clusters, such as pod, PVC and znode
+// deletions, instead of running them. A single cluster is put in dry run
+// mode with the bookkeeper.pravega.io/dry-run annotation.
+var DryRun bool
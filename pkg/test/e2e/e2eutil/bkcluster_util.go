@@ -223,10 +223,10 @@ func GetBKCluster(t *testing.T, k8client client.Client, b *bkapi.BookkeeperClust
 	return bookkeeper, nil
 }
 
-func CheckEvents(t *testing.T, k8client client.Client, b *bkapi.BookkeeperCluster, event string) (bool, error) {
+func CheckEvents(t *testing.T, k8client client.Client, b *bkapi.BookkeeperCluster, reason string) (bool, error) {
 	listOptions := []client.ListOption{
 		client.InNamespace(b.GetNamespace()),
-		client.MatchingLabelsSelector{Selector: labels.SelectorFromSet(map[string]string{"bookkeeper_cluster": b.GetName()})},
+		client.MatchingFields{"involvedObject.kind": "BookkeeperCluster", "involvedObject.name": b.GetName()},
 	}
 
 	events := corev1.EventList{}
@@ -237,7 +237,7 @@ func CheckEvents(t *testing.T, k8client client.Client, b *bkapi.BookkeeperCluste
 	}
 
 	for _, e := range events.Items {
-		if strings.HasPrefix(e.Name, event) {
+		if e.Reason == reason {
 			return true, nil
 		}
 	}
@@ -42,10 +42,46 @@ func ConfigMapNameForBookie(clusterName string) string {
 	return fmt.Sprintf("%s-bookie", clusterName)
 }
 
+func MetadataInitJobNameForBookie(clusterName string) string {
+	return fmt.Sprintf("%s-bookie-metadata-init", clusterName)
+}
+
+func RepairJobNameForBookie(podName string) string {
+	return fmt.Sprintf("%s-repair", podName)
+}
+
+func DecommissionJobNameForBookie(podName string) string {
+	return fmt.Sprintf("%s-decommission", podName)
+}
+
+func WipeJobNameForBookie(podName string) string {
+	return fmt.Sprintf("%s-wipe", podName)
+}
+
+func WipeClaimNameForBookie(disk string, podName string) string {
+	return fmt.Sprintf("%s-%s-wipe", disk, podName)
+}
+
+func RestoreJobNameForBookie(clusterName string) string {
+	return fmt.Sprintf("%s-bookie-restore", clusterName)
+}
+
+func BackupJobName(backupName string) string {
+	return fmt.Sprintf("%s-backup", backupName)
+}
+
+func RackConfigMapNameForBookie(clusterName string) string {
+	return fmt.Sprintf("%s-bookie-racks", clusterName)
+}
+
 func StatefulSetNameForBookie(clusterName string) string {
 	return fmt.Sprintf("%s-bookie", clusterName)
 }
 
+func LocalPersistentVolumeNameForBookie(namespace string, clusterName string, disk string, ordinal int) string {
+	return fmt.Sprintf("%s-%s-bookie-%s-%d", namespace, clusterName, disk, ordinal)
+}
+
 func IsOrphan(k8sObjectName string, replicas int32) bool {
 	index := strings.LastIndexAny(k8sObjectName, "-")
 	if index == -1 {
@@ -64,6 +100,44 @@ func HealthcheckCommand(port int32) []string {
 	return []string{"/bin/sh", "-c", fmt.Sprintf("netstat -ltn 2> /dev/null | grep %d || ss -ltn 2> /dev/null | grep %d", port, port)}
 }
 
+// MetadataInitCommand looks up the cluster instance ID in the BookKeeper
+// metadata, initializing the metadata first if there is none, and writes the
+// instance ID to the termination message of the container
+func MetadataInitCommand() []string {
+	lookup := "id=$(/opt/bookkeeper/bin/bookkeeper shell whatisinstanceid 2>&1 | grep -o 'InstanceId: [0-9a-fA-F-]*' | tail -1 | cut -d' ' -f2)"
+	return []string{"/bin/bash", "-c", lookup + `
+if [ -z "$id" ]; then
+  /opt/bookkeeper/bin/bookkeeper shell initnewcluster || exit 1
+  ` + lookup + `
+fi
+[ -n "$id" ] || exit 1
+printf '%s' "$id" > /dev/termination-log
+`}
+}
+
+// FormatBookieCommand wipes the journal, ledger and index directories of a
+// bookie, so that it starts again as a new bookie
+func FormatBookieCommand() []string {
+	return []string{"/bin/bash", "-c", "/opt/bookkeeper/bin/bookkeeper shell bookieformat -nonInteractive -force"}
+}
+
+// DecommissionBookieCommand decommissions the bookie with the given ID, once
+// its ledgers have been re-replicated to the other bookies
+func DecommissionBookieCommand(bookieID string) []string {
+	return []string{"/bin/bash", "-c", "/opt/bookkeeper/bin/bookkeeper shell decommissionbookie -bookieid " + bookieID}
+}
+
+// PreStopCommand switches the bookie to read-only through the admin HTTP
+// server listening on httpPort, then waits drainSeconds for the in-flight
+// requests to complete
+func PreStopCommand(httpPort int32, drainSeconds int32) []string {
+	url := fmt.Sprintf("http://localhost:%d/api/v1/bookie/state/readonly", httpPort)
+	body := `{"readOnly":true}`
+	return []string{"/bin/sh", "-c", fmt.Sprintf(
+		"curl -s -X PUT -H 'Content-Type: application/json' -d '%s' %s || wget -q -O /dev/null --method=PUT --body-data='%s' %s; sleep %d",
+		body, url, body, url, drainSeconds)}
+}
+
 // Min returns the smaller of x or y.
 func Min(x, y int32) int32 {
 	if x > y {
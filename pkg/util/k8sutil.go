@@ -12,9 +12,11 @@ package util
 
 import (
 	"fmt"
+	"strings"
 
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 func DownwardAPIEnv() []corev1.EnvVar {
@@ -69,6 +71,25 @@ func PodAntiAffinity(component string, clusterName string) *corev1.Affinity {
 	}
 }
 
+// NodeAffinityForHostnames requires pods to be scheduled on one of the given nodes
+func NodeAffinityForHostnames(nodes []string) *corev1.NodeAffinity {
+	return &corev1.NodeAffinity{
+		RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{
+			NodeSelectorTerms: []corev1.NodeSelectorTerm{
+				{
+					MatchExpressions: []corev1.NodeSelectorRequirement{
+						{
+							Key:      "kubernetes.io/hostname",
+							Operator: corev1.NodeSelectorOpIn,
+							Values:   nodes,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func IsPodReady(pod *corev1.Pod) bool {
 	for _, condition := range pod.Status.Conditions {
 		if condition.Type == corev1.PodReady && condition.Status == corev1.ConditionTrue {
@@ -85,3 +106,59 @@ func IsPodFaulty(pod *corev1.Pod) (bool, error) {
 	}
 	return false, nil
 }
+
+// cookieMismatchErrors are logged by bookies whose cookie does not match the
+// one stored in ZooKeeper or the instance ID of the cluster
+var cookieMismatchErrors = []string{
+	"invalidcookieexception",
+	"cookie mismatch",
+	"is not matching with",
+	"instance id mismatch",
+	"instanceid mismatch",
+}
+
+// IsCookieMismatch checks the termination message of a bookie container that
+// is down, i.e. terminated or waiting to be restarted, for cookie and
+// instance ID errors and returns the offending line. The last termination of
+// a bookie that is running again is ignored.
+func IsCookieMismatch(pod *corev1.Pod) (bool, string) {
+	if len(pod.Status.ContainerStatuses) == 0 {
+		return false, ""
+	}
+	status := pod.Status.ContainerStatuses[0]
+	terminated := status.State.Terminated
+	if status.State.Waiting != nil {
+		terminated = status.LastTerminationState.Terminated
+	}
+	if status.Ready || terminated == nil || terminated.ExitCode == 0 {
+		return false, ""
+	}
+	for _, line := range strings.Split(terminated.Message, "\n") {
+		lower := strings.ToLower(line)
+		for _, e := range cookieMismatchErrors {
+			if strings.Contains(lower, e) {
+				return true, strings.TrimSpace(line)
+			}
+		}
+	}
+	return false, ""
+}
+
+// ParseWatchNamespaces returns the namespaces of the comma-separated list, in
+// order and without duplicates. An empty list means all the namespaces.
+func ParseWatchNamespaces(value string) ([]string, error) {
+	var namespaces []string
+	seen := map[string]bool{}
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		if errs := validation.IsDNS1123Label(ns); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid namespace %q: %s", ns, strings.Join(errs, ", "))
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
@@ -89,4 +89,77 @@ var _ = Describe("k8sutil", func() {
 		})
 	})
 
+	Context("IsCookieMismatch", func() {
+		var mismatch, other, running bool
+		var message string
+		BeforeEach(func() {
+			testpod := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{
+						{
+							Name: "bookie",
+							State: v1.ContainerState{
+								Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+							},
+							LastTerminationState: v1.ContainerState{
+								Terminated: &v1.ContainerStateTerminated{
+									ExitCode: 1,
+									Message:  "INFO - Starting bookie\nERROR - Cookie [4\nbookieHost: \"a:3181\"] is not matching with [4\nbookieHost: \"b:3181\"]\n",
+								},
+							},
+						},
+					}},
+			}
+			testpod1 := &v1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "test"},
+				Status: v1.PodStatus{
+					ContainerStatuses: []v1.ContainerStatus{
+						{
+							Name: "bookie",
+							State: v1.ContainerState{
+								Waiting: &v1.ContainerStateWaiting{Reason: "CrashLoopBackOff"},
+							},
+							LastTerminationState: v1.ContainerState{
+								Terminated: &v1.ContainerStateTerminated{
+									ExitCode: 1,
+									Message:  "ERROR - Journal directory is full",
+								},
+							},
+						},
+					}},
+			}
+			mismatch, message = IsCookieMismatch(testpod)
+			other, _ = IsCookieMismatch(testpod1)
+			testpod.Status.ContainerStatuses[0].State = v1.ContainerState{Running: &v1.ContainerStateRunning{}}
+			testpod.Status.ContainerStatuses[0].Ready = true
+			running, _ = IsCookieMismatch(testpod)
+		})
+		It("should detect the cookie mismatch", func() {
+			Ω(mismatch).To(Equal(true))
+			Ω(message).To(ContainSubstring("is not matching with"))
+		})
+		It("should ignore other errors", func() {
+			Ω(other).To(Equal(false))
+		})
+		It("should ignore bookies running again", func() {
+			Ω(running).To(Equal(false))
+		})
+	})
+
+	Context("ParseWatchNamespaces", func() {
+		It("should watch all namespaces with an empty list", func() {
+			namespaces, err := ParseWatchNamespaces("")
+			Ω(err).Should(BeNil())
+			Ω(namespaces).Should(BeEmpty())
+		})
+		It("should split the list", func() {
+			namespaces, err := ParseWatchNamespaces(" tenant-a,tenant-b,,tenant-a ")
+			Ω(err).Should(BeNil())
+			Ω(namespaces).Should(Equal([]string{"tenant-a", "tenant-b"}))
+		})
+		It("should refuse invalid namespaces", func() {
+			_, err := ParseWatchNamespaces("tenant-a,Tenant_B")
+			Ω(err).ShouldNot(BeNil())
+			Ω(err.Error()).Should(ContainSubstring("Tenant_B"))
+		})
+	})
 })
@@ -14,15 +14,16 @@ import (
 	"context"
 	"fmt"
 	"os"
-
-	"log"
+	"time"
 
 	"github.com/operator-framework/operator-lib/leader"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/rest"
 	k8sClient "sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 // BecomeLeader with pre-check cluster status - is there a previous pod in bad state?
@@ -31,7 +32,7 @@ func BecomeLeader(ctx context.Context, cfg *rest.Config, lockName, namespace str
 
 	err := precheckLeaderLock(ctx, client, lockName, namespace)
 	if err != nil {
-		log.Printf("Error while pre-checking leader lock: %v", err)
+		log.FromContext(ctx).Error(err, "Error while pre-checking leader lock")
 	}
 
 	// pre-checks done, proceed with SDK-provided election procedure
@@ -39,6 +40,68 @@ func BecomeLeader(ctx context.Context, cfg *rest.Config, lockName, namespace str
 	return err
 }
 
+// legacyLockPollInterval is how often the ConfigMap lock is checked while
+// waiting for the previous leader
+var legacyLockPollInterval = 5 * time.Second
+
+// WaitForLegacyLeaderLock waits until the ConfigMap lock taken by BecomeLeader
+// is no longer held by a running pod. An operator using the Lease based leader
+// election calls it before starting, so that it does not run next to an
+// operator still using the ConfigMap lock during a rollout.
+func WaitForLegacyLeaderLock(ctx context.Context, cfg *rest.Config, lockName, namespace string) error {
+	client, err := k8sClient.New(cfg, k8sClient.Options{})
+	if err != nil {
+		return err
+	}
+	return waitForLegacyLeaderLock(ctx, client, lockName, namespace)
+}
+
+func waitForLegacyLeaderLock(ctx context.Context, client k8sClient.Client, lockName, ns string) error {
+	return wait.PollImmediateUntil(legacyLockPollInterval, func() (bool, error) {
+		holder, err := legacyLeaderLockHolder(ctx, client, lockName, ns)
+		if err != nil {
+			return false, err
+		}
+		if holder != "" {
+			log.FromContext(ctx).Info("Waiting for the leader lock to be released", "lock", lockName, "pod", holder)
+			return false, nil
+		}
+		return true, nil
+	}, ctx.Done())
+}
+
+// legacyLeaderLockHolder returns the name of the running pod holding the
+// ConfigMap lock, or an empty string if the lock is missing, held by the
+// current pod or left by a pod that is gone, being deleted or terminated. A
+// leader on an unreachable node is marked for deletion once evicted, so it
+// does not block the election.
+func legacyLeaderLockHolder(ctx context.Context, client k8sClient.Client, lockName, ns string) (string, error) {
+	existingConfigMap, err := getConfigMapWithLock(ctx, client, lockName, ns)
+	if existingConfigMap == nil || err != nil {
+		return "", err
+	}
+
+	for _, lockOwner := range existingConfigMap.GetOwnerReferences() {
+		if lockOwner.Kind != "Pod" || lockOwner.Name == os.Getenv("POD_NAME") {
+			continue
+		}
+		leaderPod := &corev1.Pod{}
+		err := client.Get(ctx, k8sClient.ObjectKey{Namespace: ns, Name: lockOwner.Name}, leaderPod)
+		if err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return "", err
+		}
+		if leaderPod.UID != lockOwner.UID || leaderPod.DeletionTimestamp != nil ||
+			leaderPod.Status.Phase == corev1.PodFailed || leaderPod.Status.Phase == corev1.PodSucceeded {
+			continue
+		}
+		return leaderPod.Name, nil
+	}
+	return "", nil
+}
+
 func precheckLeaderLock(ctx context.Context, client k8sClient.Client, lockName, ns string) error {
 	existingConfigMap, e := getConfigMapWithLock(ctx, client, lockName, ns)
 	if existingConfigMap == nil || e != nil {
@@ -50,14 +113,14 @@ func precheckLeaderLock(ctx context.Context, client k8sClient.Client, lockName,
 		return fmt.Errorf("required env POD_NAME not set")
 	}
 
-	log.Printf("Current pod name: %s", currentPod)
+	log.FromContext(ctx).Info("Current pod name", "pod", currentPod)
 
 	for _, lockOwner := range existingConfigMap.GetOwnerReferences() {
 		if lockOwner.Name == currentPod {
-			log.Printf("Leader lock is owned by current pod - am I restarted?")
+			log.FromContext(ctx).Info("Leader lock is owned by current pod - am I restarted?")
 			return nil
 		}
-		log.Printf("Leader lock owner is %s %s", lockOwner.Kind, lockOwner.Name)
+		log.FromContext(ctx).Info("Leader lock owner", "kind", lockOwner.Kind, "name", lockOwner.Name)
 		e := checkupLeaderPodStatus(ctx, client, lockOwner, existingConfigMap, ns)
 		if e != nil {
 			return e
@@ -71,7 +134,7 @@ func precheckLeaderLock(ctx context.Context, client k8sClient.Client, lockName,
 // then deletes lock and pod
 func checkupLeaderPodStatus(ctx context.Context, client k8sClient.Client, leaderRef metav1.OwnerReference, existingLock *corev1.ConfigMap, ns string) error {
 	if leaderRef.Kind != "Pod" {
-		log.Printf("Existing lock references non-pod object! Kind: %s", leaderRef.Kind)
+		log.FromContext(ctx).Info("Existing lock references non-pod object!", "kind", leaderRef.Kind)
 		return nil
 	}
 
@@ -79,17 +142,17 @@ func checkupLeaderPodStatus(ctx context.Context, client k8sClient.Client, leader
 	err := client.Get(ctx, k8sClient.ObjectKey{Namespace: ns, Name: leaderRef.Name}, leaderPod)
 	if err != nil {
 		if apierrors.IsNotFound(err) {
-			log.Printf("Leader pod %s not found in namespace %s", leaderRef.Name, ns)
+			log.FromContext(ctx).Info("Leader pod not found", "pod", leaderRef.Name, "namespace", ns)
 			return nil
 		}
-		log.Printf("Error while reading leader pod: %v", err)
+		log.FromContext(ctx).Error(err, "Error while reading leader pod")
 		return err
 	}
 
-	log.Printf("Leader pod is in %s:%s status", leaderPod.Status.Phase, leaderPod.Status.Reason)
+	log.FromContext(ctx).Info("Leader pod status", "phase", leaderPod.Status.Phase, "reason", leaderPod.Status.Reason)
 
 	if leaderPod.Status.Reason == "ProviderFailed" {
-		log.Printf("Leader pod status reason is '%s' - deleting pod and lock config map to unblock leader election", leaderPod.Status.Reason)
+		log.FromContext(ctx).Info("Deleting leader pod and lock config map to unblock leader election", "reason", leaderPod.Status.Reason)
 		if err := deleteLeader(ctx, client, leaderPod, existingLock); err != nil {
 			return err
 		}
@@ -103,10 +166,10 @@ func getConfigMapWithLock(ctx context.Context, client k8sClient.Client, lockName
 	e := client.Get(ctx, k8sClient.ObjectKey{Namespace: ns, Name: lockName}, existingConfigMap)
 	if e != nil {
 		if apierrors.IsNotFound(e) {
-			log.Printf("Leader lock %s not found in namespace %s", lockName, ns)
+			log.FromContext(ctx).Info("Leader lock not found", "lock", lockName, "namespace", ns)
 			return nil, nil
 		}
-		log.Printf("Unknown error trying to get lock config map: %v", e)
+		log.FromContext(ctx).Error(e, "Unknown error trying to get lock config map")
 		return nil, e
 	}
 	return existingConfigMap, nil
@@ -116,14 +179,14 @@ func getConfigMapWithLock(ctx context.Context, client k8sClient.Client, lockName
 func deleteLeader(ctx context.Context, client k8sClient.Client, leaderPod *corev1.Pod, configMapWithLock *corev1.ConfigMap) error {
 	err := client.Delete(ctx, leaderPod)
 	if err != nil {
-		log.Printf("Error deleting leader pod %s: %v", leaderPod.Name, err)
+		log.FromContext(ctx).Error(err, "Error deleting leader pod", "pod", leaderPod.Name)
 		return err
 	}
 
 	err = client.Delete(ctx, configMapWithLock)
 	switch {
 	case apierrors.IsNotFound(err):
-		log.Printf("Config map has already been deleted")
+		log.FromContext(ctx).Info("Config map has already been deleted")
 		return nil
 	case err != nil:
 		return err
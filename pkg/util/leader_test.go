@@ -13,6 +13,7 @@ package util
 import (
 	"context"
 	"os"
+	"time"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -142,4 +143,109 @@ var _ = Describe("Leader election utils", func() {
 			})
 		})
 	})
+
+	Context("Waiting for the ConfigMap lock", func() {
+		var (
+			client        k8sClient.Client
+			holder        string
+			err           error
+			ctx           context.Context
+			lockConfigMap *corev1.ConfigMap
+			otherPod      *corev1.Pod
+		)
+		BeforeEach(func() {
+			otherPod = &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      otherPodName,
+					UID:       "Uid-" + otherPodName,
+					Namespace: namespace,
+				},
+				Status: corev1.PodStatus{Phase: corev1.PodRunning},
+			}
+			lockConfigMap = &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      configmapName,
+					Namespace: namespace,
+					OwnerReferences: []metav1.OwnerReference{
+						{Name: otherPodName, Kind: "Pod", UID: "Uid-" + otherPodName},
+					},
+				},
+			}
+			_ = os.Setenv("POD_NAME", currentPodName)
+			ctx = context.TODO()
+		})
+		JustBeforeEach(func() {
+			client = fake.NewClientBuilder().WithScheme(clientscheme.Scheme).WithRuntimeObjects(otherPod, lockConfigMap).Build()
+			holder, err = legacyLeaderLockHolder(ctx, client, configmapName, namespace)
+		})
+
+		When("the lock is held by another running pod", func() {
+			It("should report that pod", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(holder).To(Equal(otherPodName))
+			})
+			It("should wait until the context is done", func() {
+				legacyLockPollInterval = 10 * time.Millisecond
+				defer func() { legacyLockPollInterval = 5 * time.Second }()
+				waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+				defer cancel()
+				err = waitForLegacyLeaderLock(waitCtx, client, configmapName, namespace)
+				Expect(err).Should(HaveOccurred())
+			})
+		})
+
+		When("the lock is held by the current pod", func() {
+			BeforeEach(func() {
+				lockConfigMap.OwnerReferences[0].Name = currentPodName
+			})
+			It("should not wait", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(holder).To(BeEmpty())
+			})
+		})
+
+		When("the pod holding the lock is being deleted", func() {
+			BeforeEach(func() {
+				now := metav1.Now()
+				otherPod.DeletionTimestamp = &now
+				otherPod.Finalizers = []string{"kubernetes"}
+			})
+			It("should not wait", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(holder).To(BeEmpty())
+			})
+		})
+
+		When("the pod holding the lock has failed", func() {
+			BeforeEach(func() {
+				otherPod.Status.Phase = corev1.PodFailed
+			})
+			It("should not wait", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(holder).To(BeEmpty())
+			})
+		})
+
+		When("the pod holding the lock was replaced by a pod with the same name", func() {
+			BeforeEach(func() {
+				otherPod.UID = "Uid-new"
+			})
+			It("should not wait", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(holder).To(BeEmpty())
+			})
+		})
+
+		When("there is no lock", func() {
+			BeforeEach(func() {
+				lockConfigMap.Name = "other-map"
+			})
+			It("should not wait", func() {
+				Expect(err).ShouldNot(HaveOccurred())
+				Expect(holder).To(BeEmpty())
+				err = waitForLegacyLeaderLock(ctx, client, configmapName, namespace)
+				Expect(err).ShouldNot(HaveOccurred())
+			})
+		})
+	})
 })
@@ -0,0 +1,54 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	k8sClient "sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// OperatorContainerName is the name of the container of the operator in its
+// deployment
+const OperatorContainerName = "bookkeeper-operator"
+
+// GetOperatorImage returns the image the operator pod named by the POD_NAME
+// environment variable runs, so that the Jobs run the same operator binary
+func GetOperatorImage(ctx context.Context, cfg *rest.Config, namespace string) (string, error) {
+	client, err := k8sClient.New(cfg, k8sClient.Options{})
+	if err != nil {
+		return "", err
+	}
+	podName := os.Getenv("POD_NAME")
+	if podName == "" {
+		return "", fmt.Errorf("required env POD_NAME not set")
+	}
+	return getOperatorImage(ctx, client, podName, namespace)
+}
+
+func getOperatorImage(ctx context.Context, client k8sClient.Client, podName, namespace string) (string, error) {
+	pod := &corev1.Pod{}
+	err := client.Get(ctx, types.NamespacedName{Name: podName, Namespace: namespace}, pod)
+	if err != nil {
+		return "", fmt.Errorf("failed to get operator pod (%s): %v", podName, err)
+	}
+	for _, container := range pod.Spec.Containers {
+		if container.Name == OperatorContainerName {
+			return container.Image, nil
+		}
+	}
+	return "", fmt.Errorf("operator pod (%s) has no %s container", podName, OperatorContainerName)
+}
@@ -0,0 +1,43 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+package util
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientscheme "k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("operator", func() {
+	Context("getOperatorImage", func() {
+		It("should return the image of the operator container", func() {
+			pod := &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "bookkeeper-operator-0", Namespace: "operators"},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{Name: "proxy", Image: "proxy:1.0"},
+						{Name: OperatorContainerName, Image: "pravega/bookkeeper-operator:0.1.8"},
+					},
+				},
+			}
+			client := fake.NewClientBuilder().WithScheme(clientscheme.Scheme).WithRuntimeObjects(pod).Build()
+			image, err := getOperatorImage(context.TODO(), client, pod.Name, pod.Namespace)
+			Ω(err).Should(BeNil())
+			Ω(image).Should(Equal("pravega/bookkeeper-operator:0.1.8"))
+			_, err = getOperatorImage(context.TODO(), client, "missing", pod.Namespace)
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+})
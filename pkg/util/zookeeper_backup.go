@@ -0,0 +1,137 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package util
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/samuel/go-zookeeper/zk"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// ZnodeSnapshot is the copy of a znode held by a metadata snapshot
+type ZnodeSnapshot struct {
+	Path string     `json:"path"`
+	Data []byte     `json:"data,omitempty"`
+	ACL  []ZnodeACL `json:"acl"`
+}
+
+// ZnodeACL is an entry of the ACL of a znode
+type ZnodeACL struct {
+	Perms  int32  `json:"perms"`
+	Scheme string `json:"scheme"`
+	ID     string `json:"id"`
+}
+
+// MetadataSnapshot is a copy of the znode subtree holding the metadata of a
+// BookKeeper cluster, with the parents listed before their children
+type MetadataSnapshot struct {
+	Root   string          `json:"root"`
+	Time   time.Time       `json:"time"`
+	Znodes []ZnodeSnapshot `json:"znodes"`
+}
+
+// BackupZnodes copies the data and the ACLs of the persistent znodes of the
+// subtree at the given root. Ephemeral znodes, such as the registrations of
+// the running bookies, are left out.
+func BackupZnodes(ctx context.Context, config *ZookeeperConfig, root string) (*MetadataSnapshot, error) {
+	conn, err := NewZookeeperClient(config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	tree, err := ListSubTreeBFS(conn, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct BFS tree: %v", err)
+	}
+	snapshot := &MetadataSnapshot{Root: root, Time: time.Now().UTC()}
+	for e := tree.Front(); e != nil; e = e.Next() {
+		path := e.Value.(string)
+		data, stat, err := conn.Get(path)
+		if err == zk.ErrNoNode {
+			// deleted while walking the tree
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to get znode (%s): %v", path, err)
+		}
+		if stat.EphemeralOwner != 0 {
+			continue
+		}
+		acl, _, err := conn.GetACL(path)
+		if err != nil && err != zk.ErrNoNode {
+			return nil, fmt.Errorf("failed to get acl of znode (%s): %v", path, err)
+		}
+		znode := ZnodeSnapshot{Path: path, Data: data}
+		for _, a := range acl {
+			znode.ACL = append(znode.ACL, ZnodeACL{Perms: a.Perms, Scheme: a.Scheme, ID: a.ID})
+		}
+		snapshot.Znodes = append(snapshot.Znodes, znode)
+	}
+	log.FromContext(ctx).Info("zookeeper metadata backed up", "path", root, "znodes", len(snapshot.Znodes))
+	return snapshot, nil
+}
+
+// RestoreZnodes writes the znodes of the snapshot back to ZooKeeper. The
+// missing parents of the snapshot root are created with an open ACL. It
+// fails if the root already exists, so that live metadata is never
+// overwritten.
+func RestoreZnodes(ctx context.Context, config *ZookeeperConfig, snapshot *MetadataSnapshot) error {
+	conn, err := NewZookeeperClient(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	exist, _, err := conn.Exists(snapshot.Root)
+	if err != nil {
+		return fmt.Errorf("failed to check if zookeeper path exists: %v", err)
+	}
+	if exist {
+		return fmt.Errorf("zookeeper path (%s) already exists", snapshot.Root)
+	}
+	for _, parent := range parentPaths(snapshot.Root) {
+		err = conn.Create(parent, nil, zk.WorldACL(zk.PermAll))
+		if err != nil && err != zk.ErrNodeExists {
+			return fmt.Errorf("failed to create znode (%s): %v", parent, err)
+		}
+	}
+	for _, znode := range snapshot.Znodes {
+		var acl []zk.ACL
+		for _, a := range znode.ACL {
+			acl = append(acl, zk.ACL{Perms: a.Perms, Scheme: a.Scheme, ID: a.ID})
+		}
+		if len(acl) == 0 {
+			acl = zk.WorldACL(zk.PermAll)
+		}
+		err = conn.Create(znode.Path, znode.Data, acl)
+		if err != nil {
+			return fmt.Errorf("failed to create znode (%s): %v", znode.Path, err)
+		}
+	}
+	log.FromContext(ctx).Info("zookeeper metadata restored", "path", snapshot.Root, "znodes", len(snapshot.Znodes))
+	return nil
+}
+
+// parentPaths returns the paths of the ancestors of the given znode, the
+// root excluded, from the top down
+func parentPaths(path string) []string {
+	var parents []string
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for i := 1; i < len(parts); i++ {
+		parents = append(parents, "/"+strings.Join(parts[:i], "/"))
+	}
+	return parents
+}
@@ -12,12 +12,17 @@ package util
 
 import (
 	"container/list"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
-	"log"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/samuel/go-zookeeper/zk"
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const (
@@ -26,24 +31,209 @@ const (
 	ZkFinalizer = "cleanUpZookeeper"
 )
 
+// ZookeeperConfig is the configuration of the ZooKeeper client of the operator
+type ZookeeperConfig struct {
+	// ConnectString is the ZooKeeper connect string, in the format
+	// "hostname:port[,hostname:port...][/chroot]"
+	ConnectString string
+
+	// Namespace is the namespace the ZooKeeper hostnames which are not fully
+	// qualified are resolved in first, as they are by the bookies
+	Namespace string
+
+	// Username and Password authenticate the client with the digest scheme
+	Username string
+	Password string
+
+	// TLS encrypts the connections to ZooKeeper, if set
+	TLS *tls.Config
+
+	// Timeout is the session timeout of the client, which bounds how long
+	// an operation waits for an unresponsive server. It defaults to 5s.
+	Timeout time.Duration
+}
+
+// ZookeeperClient is a connection to ZooKeeper which resolves the paths
+// relative to the chroot of the connect string
+type ZookeeperClient struct {
+	conn   *zk.Conn
+	chroot string
+}
+
+// ParseZookeeperConnectString splits a ZooKeeper connect string into the
+// "hostname:port" addresses of the servers and the chroot path. The port
+// defaults to 2181.
+func ParseZookeeperConnectString(connectString string) (servers []string, chroot string, err error) {
+	hosts := connectString
+	if i := strings.Index(connectString, "/"); i >= 0 {
+		hosts, chroot = connectString[:i], connectString[i:]
+		if chroot == "/" {
+			chroot = ""
+		} else if strings.HasSuffix(chroot, "/") || strings.Contains(chroot, "//") {
+			return nil, "", fmt.Errorf("invalid chroot path in zookeeper connect string (%s)", connectString)
+		}
+	}
+	for _, server := range strings.Split(hosts, ",") {
+		server = strings.TrimSpace(server)
+		host, port, err := net.SplitHostPort(server)
+		if err != nil {
+			host, port = server, "2181"
+		}
+		if host == "" {
+			return nil, "", fmt.Errorf("missing hostname in zookeeper connect string (%s)", connectString)
+		}
+		if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+			return nil, "", fmt.Errorf("invalid port (%s) in zookeeper connect string (%s)", port, connectString)
+		}
+		servers = append(servers, net.JoinHostPort(host, port))
+	}
+	return servers, chroot, nil
+}
+
+// lookupHost resolves the ZooKeeper hostnames, replaced in tests
+var lookupHost = net.LookupHost
+
+// qualifyZookeeperServers resolves the hostnames which are not fully
+// qualified in the given namespace first, as the resolver of the bookies of
+// the cluster does, and keeps them as given if they are not found there
+func qualifyZookeeperServers(servers []string, namespace string) []string {
+	if namespace == "" {
+		return servers
+	}
+	for i, server := range servers {
+		host, port, _ := net.SplitHostPort(server)
+		if strings.HasSuffix(host, ".") || strings.HasSuffix(host, ".svc.cluster.local") || net.ParseIP(host) != nil {
+			continue
+		}
+		qualified := host + "." + namespace + ".svc.cluster.local"
+		if _, err := lookupHost(qualified); err == nil {
+			servers[i] = net.JoinHostPort(qualified, port)
+		}
+	}
+	return servers
+}
+
+// NewZookeeperClient connects to ZooKeeper with the given configuration
+func NewZookeeperClient(config *ZookeeperConfig) (*ZookeeperClient, error) {
+	servers, chroot, err := ParseZookeeperConnectString(config.ConnectString)
+	if err != nil {
+		return nil, err
+	}
+	servers = qualifyZookeeperServers(servers, config.Namespace)
+	dialer := net.DialTimeout
+	if config.TLS != nil {
+		dialer = func(network, address string, timeout time.Duration) (net.Conn, error) {
+			return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, address, config.TLS)
+		}
+	}
+	timeout := config.Timeout
+	if timeout == 0 {
+		timeout = time.Second * 5
+	}
+	conn, _, err := zk.Connect(servers, timeout, zk.WithDialer(dialer))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to zookeeper (%s): %v", strings.Join(servers, ","), err)
+	}
+	if config.Username != "" {
+		err = conn.AddAuth("digest", []byte(config.Username+":"+config.Password))
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("failed to authenticate to zookeeper (%s): %v", strings.Join(servers, ","), err)
+		}
+	}
+	return &ZookeeperClient{conn: conn, chroot: chroot}, nil
+}
+
+// Close closes the connection to ZooKeeper
+func (c *ZookeeperClient) Close() {
+	c.conn.Close()
+}
+
+func (c *ZookeeperClient) path(p string) string {
+	if c.chroot != "" && p == "/" {
+		return c.chroot
+	}
+	return c.chroot + p
+}
+
+// Exists returns true if the znode at the given path exists
+func (c *ZookeeperClient) Exists(path string) (bool, *zk.Stat, error) {
+	return c.conn.Exists(c.path(path))
+}
+
+// Children returns the names of the children of the znode at the given path
+func (c *ZookeeperClient) Children(path string) ([]string, *zk.Stat, error) {
+	return c.conn.Children(c.path(path))
+}
+
+// Get returns the data of the znode at the given path
+func (c *ZookeeperClient) Get(path string) ([]byte, *zk.Stat, error) {
+	return c.conn.Get(c.path(path))
+}
+
+// GetACL returns the ACL of the znode at the given path
+func (c *ZookeeperClient) GetACL(path string) ([]zk.ACL, *zk.Stat, error) {
+	return c.conn.GetACL(c.path(path))
+}
+
+// Create creates a persistent znode at the given path
+func (c *ZookeeperClient) Create(path string, data []byte, acl []zk.ACL) error {
+	_, err := c.conn.Create(c.path(path), data, 0, acl)
+	return err
+}
+
+// Delete deletes the znode at the given path if its version matches, or
+// whatever its version if version is -1
+func (c *ZookeeperClient) Delete(path string, version int32) error {
+	return c.conn.Delete(c.path(path), version)
+}
+
+// CheckZookeeper connects to ZooKeeper and reads the root of the connect
+// string, to make sure the servers are reachable with the given configuration
+func CheckZookeeper(config *ZookeeperConfig) error {
+	conn, err := NewZookeeperClient(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, _, err = conn.Exists("/"); err != nil {
+		return fmt.Errorf("failed to reach zookeeper (%s): %v", config.ConnectString, err)
+	}
+	return nil
+}
+
+// ListRegisteredBookies returns the IDs of the bookies registered under the
+// ledgers root path as available, in read-write and in read-only mode
+func ListRegisteredBookies(config *ZookeeperConfig, ledgersRoot string) (readWrite []string, readOnly []string, err error) {
+	conn, err := NewZookeeperClient(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+
+	availablePath := ledgersRoot + "/available"
+	children, _, err := conn.Children(availablePath)
+	if err != nil && err != zk.ErrNoNode {
+		return nil, nil, fmt.Errorf("failed to list available bookies (%s): %v", availablePath, err)
+	}
+	for _, child := range children {
+		if child != "readonly" {
+			readWrite = append(readWrite, child)
+		}
+	}
+	readOnly, _, err = conn.Children(availablePath + "/readonly")
+	if err != nil && err != zk.ErrNoNode {
+		return nil, nil, fmt.Errorf("failed to list read-only bookies (%s/readonly): %v", availablePath, err)
+	}
+	return readWrite, readOnly, nil
+}
+
 // Delete all znodes related to a specific Bookkeeper cluster
-func DeleteAllZnodes(uri string, namespace string, pravegaClusterName string) (err error) {
-	zkUri := strings.Split(uri, ":")
-	zkSvcName := ""
-	zkSvcPort := ""
-	if len(zkUri) >= 1 {
-		zkSvcName = zkUri[0]
-		if len(zkUri) == 1 {
-			zkSvcPort = "2181"
-		} else {
-			zkSvcPort = zkUri[1]
-		}
-	}
-	hostname := zkSvcName + "." + namespace + ".svc.cluster.local:" + zkSvcPort
-	host := []string{hostname}
-	conn, _, err := zk.Connect(host, time.Second*5)
+func DeleteAllZnodes(ctx context.Context, config *ZookeeperConfig, pravegaClusterName string) (err error) {
+	conn, err := NewZookeeperClient(config)
 	if err != nil {
-		return fmt.Errorf("failed to connect to zookeeper (%s): %v", hostname, err)
+		return err
 	}
 	defer conn.Close()
 
@@ -57,25 +247,91 @@ func DeleteAllZnodes(uri string, namespace string, pravegaClusterName string) (e
 		// Construct BFS tree to delete all znodes recursively
 		tree, err := ListSubTreeBFS(conn, root)
 		if err != nil {
-			return fmt.Errorf("failed to construct BFS tree: %v", err)
+			return fmt.Errorf("failed to construct BFS tree: %w", err)
 		}
 
 		for tree.Len() != 0 {
 			err := conn.Delete(tree.Back().Value.(string), -1)
 			if err != nil {
-				return fmt.Errorf("failed to delete znode (%s): %v", tree.Back().Value.(string), err)
+				return fmt.Errorf("failed to delete znode (%s): %w", tree.Back().Value.(string), err)
 			}
 			tree.Remove(tree.Back())
 		}
-		log.Println("zookeeper metadata deleted")
+		log.FromContext(ctx).Info("zookeeper metadata deleted", "path", root)
 	} else {
-		log.Println("zookeeper metadata not found")
+		log.FromContext(ctx).Info("zookeeper metadata not found", "path", root)
+	}
+	return nil
+}
+
+// ListAllZnodes returns the znodes DeleteAllZnodes would delete, the parents
+// before their children
+func ListAllZnodes(config *ZookeeperConfig, pravegaClusterName string) ([]string, error) {
+	conn, err := NewZookeeperClient(config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	root := fmt.Sprintf("/%s/%s", PravegaPath, pravegaClusterName)
+	exist, _, err := conn.Exists(root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check if zookeeper path exists: %v", err)
+	}
+	if !exist {
+		return nil, nil
+	}
+	tree, err := ListSubTreeBFS(conn, root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct BFS tree: %v", err)
+	}
+	var znodes []string
+	for e := tree.Front(); e != nil; e = e.Next() {
+		znodes = append(znodes, e.Value.(string))
+	}
+	return znodes, nil
+}
+
+// DeleteBookieCookie deletes the cookies of the given bookie pod registered
+// under the ledgers root path
+func DeleteBookieCookie(ctx context.Context, config *ZookeeperConfig, ledgersRoot string, bookie string) error {
+	conn, err := NewZookeeperClient(config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	cookiesPath := ledgersRoot + "/cookies"
+	cookies, _, err := conn.Children(cookiesPath)
+	if err != nil {
+		if err == zk.ErrNoNode {
+			return nil
+		}
+		return fmt.Errorf("failed to list cookies (%s): %w", cookiesPath, err)
+	}
+	for _, cookie := range cookies {
+		// bookie IDs are either <host>:<port> or the FQDN of the pod
+		if !strings.HasPrefix(cookie, bookie+":") && !strings.HasPrefix(cookie, bookie+".") {
+			continue
+		}
+		err = conn.Delete(cookiesPath+"/"+cookie, -1)
+		if err != nil && err != zk.ErrNoNode {
+			return fmt.Errorf("failed to delete cookie (%s): %w", cookie, err)
+		}
+		log.FromContext(ctx).Info("deleted bookie cookie", "cookie", cookie)
 	}
 	return nil
 }
 
+// IsZookeeperNoAuth returns true if the error comes from a znode the operator
+// may not change with its credentials, e.g. a znode created by the bookies
+// with zkEnableSecurity, which only their SASL identity can access
+func IsZookeeperNoAuth(err error) bool {
+	return errors.Is(err, zk.ErrNoAuth)
+}
+
 // Construct a BFS tree
-func ListSubTreeBFS(conn *zk.Conn, root string) (*list.List, error) {
+func ListSubTreeBFS(conn *ZookeeperClient, root string) (*list.List, error) {
 	queue := list.New()
 	tree := list.New()
 	queue.PushBack(root)
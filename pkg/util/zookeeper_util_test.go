@@ -10,17 +10,101 @@
 package util
 
 import (
+	"context"
+	"fmt"
+	"net"
+
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
+	"github.com/samuel/go-zookeeper/zk"
 )
 
 var _ = Describe("zookeeperutil", func() {
+	Context("ParseZookeeperConnectString", func() {
+		It("should split the servers and the chroot", func() {
+			servers, chroot, err := ParseZookeeperConnectString("zk-0.zk:2181,zk-1.zk:2182,zk-2.zk/pravega/bk")
+			Ω(err).Should(BeNil())
+			Ω(servers).Should(Equal([]string{"zk-0.zk:2181", "zk-1.zk:2182", "zk-2.zk:2181"}))
+			Ω(chroot).Should(Equal("/pravega/bk"))
+		})
+		It("should accept a root chroot", func() {
+			_, chroot, err := ParseZookeeperConnectString("zookeeper-client:2181/")
+			Ω(err).Should(BeNil())
+			Ω(chroot).Should(BeEmpty())
+		})
+		It("should refuse invalid connect strings", func() {
+			_, _, err := ParseZookeeperConnectString("zk:2181,")
+			Ω(err).ShouldNot(BeNil())
+			_, _, err = ParseZookeeperConnectString("zk:99999")
+			Ω(err).ShouldNot(BeNil())
+			_, _, err = ParseZookeeperConnectString("zk:2181/chroot/")
+			Ω(err).ShouldNot(BeNil())
+		})
+	})
+
+	Context("qualifyZookeeperServers", func() {
+		var looked []string
+		BeforeEach(func() {
+			looked = nil
+			lookupHost = func(host string) ([]string, error) {
+				looked = append(looked, host)
+				switch host {
+				case "zookeeper-client.bk.svc.cluster.local", "zk-0.zk.bk.svc.cluster.local":
+					return []string{"10.0.0.2"}, nil
+				}
+				return nil, fmt.Errorf("no such host")
+			}
+		})
+		AfterEach(func() {
+			lookupHost = net.LookupHost
+		})
+		It("should resolve the hostnames in the namespace of the cluster first", func() {
+			servers := qualifyZookeeperServers([]string{"zookeeper-client:2181", "zk-0.zk:2181"}, "bk")
+			Ω(servers).Should(Equal([]string{"zookeeper-client.bk.svc.cluster.local:2181", "zk-0.zk.bk.svc.cluster.local:2181"}))
+		})
+		It("should keep the hostnames not found in the namespace of the cluster", func() {
+			servers := qualifyZookeeperServers([]string{"zk-0.zk:2181", "zk.zookeeper:2181"}, "other")
+			Ω(servers).Should(Equal([]string{"zk-0.zk:2181", "zk.zookeeper:2181"}))
+		})
+		It("should keep the fully qualified hostnames and the addresses", func() {
+			servers := qualifyZookeeperServers([]string{"zk.example.com.:2181", "zk.zookeeper.svc.cluster.local:2181", "10.0.0.1:2181"}, "bk")
+			Ω(servers).Should(Equal([]string{"zk.example.com.:2181", "zk.zookeeper.svc.cluster.local:2181", "10.0.0.1:2181"}))
+			Ω(looked).Should(BeEmpty())
+			Ω(qualifyZookeeperServers([]string{"zookeeper-client:2181"}, "")).Should(Equal([]string{"zookeeper-client:2181"}))
+		})
+	})
+
+	Context("ZookeeperClient", func() {
+		It("should resolve the paths under the chroot", func() {
+			c := &ZookeeperClient{chroot: "/pravega/bk"}
+			Ω(c.path("/")).Should(Equal("/pravega/bk"))
+			Ω(c.path("/ledgers")).Should(Equal("/pravega/bk/ledgers"))
+			c = &ZookeeperClient{}
+			Ω(c.path("/")).Should(Equal("/"))
+		})
+	})
+
+	Context("parentPaths", func() {
+		It("should list the ancestors from the top down", func() {
+			Ω(parentPaths("/pravega/bk/ledgers")).Should(Equal([]string{"/pravega", "/pravega/bk"}))
+			Ω(parentPaths("/ledgers")).Should(BeEmpty())
+		})
+	})
+
+	Context("IsZookeeperNoAuth", func() {
+		It("should detect wrapped NoAuth errors", func() {
+			Ω(IsZookeeperNoAuth(fmt.Errorf("failed to delete cookie (bk-0:3181): %w", zk.ErrNoAuth))).Should(BeTrue())
+			Ω(IsZookeeperNoAuth(zk.ErrNoNode)).Should(BeFalse())
+			Ω(IsZookeeperNoAuth(nil)).Should(BeFalse())
+		})
+	})
+
 	Context("DeleteAllZnodes", func() {
 
 		var err error
 		BeforeEach(func() {
 
-			err = DeleteAllZnodes("zookeeper-client:2181", "default", "bookie")
+			err = DeleteAllZnodes(context.TODO(), &ZookeeperConfig{ConnectString: "zookeeper-client:2181"}, "bookie")
 		})
 		It("should not be nil", func() {
 			Ω(err).ShouldNot(BeNil())
@@ -0,0 +1,78 @@
+/**
+ * Copyright (c) 2018 Dell Inc., or its subsidiaries. All Rights Reserved.
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ */
+
+package e2e
+
+import (
+	"sync"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	bkapi "github.com/pravega/bookkeeper-operator/api/v1alpha1"
+	bookkeeper_e2eutil "github.com/pravega/bookkeeper-operator/pkg/test/e2e/e2eutil"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var _ = Describe("Concurrent BK Clusters", func() {
+	Context("Check BK clusters reconciled in parallel", func() {
+		It("Create, Scale and Delete operations are successful", func() {
+			names := []string{"bk-parallel-1", "bk-parallel-2", "bk-parallel-3"}
+			clusters := make([]*bkapi.BookkeeperCluster, len(names))
+
+			// Create all clusters at once, so that they are reconciled concurrently
+			for i, name := range names {
+				cluster := bookkeeper_e2eutil.NewDefaultCluster(testNamespace)
+				cluster.ObjectMeta.Name = name
+				cluster.WithDefaults()
+
+				bk, err := bookkeeper_e2eutil.CreateBKCluster(&t, k8sClient, cluster)
+				Expect(err).NotTo(HaveOccurred())
+				clusters[i] = bk
+			}
+			waitForAll(clusters, bookkeeper_e2eutil.WaitForBookkeeperClusterToBecomeReady)
+
+			// Scale all clusters at once
+			for i, bk := range clusters {
+				bk, err := bookkeeper_e2eutil.GetBKCluster(&t, k8sClient, bk)
+				Expect(err).NotTo(HaveOccurred())
+				bk.Spec.Replicas = 4
+
+				err = bookkeeper_e2eutil.UpdateBKCluster(&t, k8sClient, bk)
+				Expect(err).NotTo(HaveOccurred())
+				clusters[i] = bk
+			}
+			waitForAll(clusters, bookkeeper_e2eutil.WaitForBookkeeperClusterToBecomeReady)
+
+			// Delete all clusters at once
+			for _, bk := range clusters {
+				err := bookkeeper_e2eutil.DeleteBKCluster(&t, k8sClient, bk)
+				Expect(err).NotTo(HaveOccurred())
+			}
+			waitForAll(clusters, bookkeeper_e2eutil.WaitForBKClusterToTerminate)
+		})
+	})
+})
+
+// waitForAll runs wait for all clusters in parallel
+func waitForAll(clusters []*bkapi.BookkeeperCluster, wait func(*testing.T, client.Client, *bkapi.BookkeeperCluster) error) {
+	var wg sync.WaitGroup
+	for _, bk := range clusters {
+		wg.Add(1)
+		go func(bk *bkapi.BookkeeperCluster) {
+			defer GinkgoRecover()
+			defer wg.Done()
+			err := wait(&t, k8sClient, bk)
+			Expect(err).NotTo(HaveOccurred())
+		}(bk)
+	}
+	wg.Wait()
+}
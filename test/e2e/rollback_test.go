@@ -63,7 +63,7 @@ var _ = Describe("Rollback", func() {
 			Expect(errorCondition.Message).To(ContainSubstring("pod bookkeeper-bookie-0 update failed because of ImagePullBackOff"))
 
 			// checking whether upgrade error event is sent out to the kubernetes event queue
-			event, err := bookkeeper_e2eutil.CheckEvents(&t, k8sClient, bookkeeper, "UPGRADE_ERROR")
+			event, err := bookkeeper_e2eutil.CheckEvents(&t, k8sClient, bookkeeper, "UpgradeFailed")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(event).To(BeTrue())
 
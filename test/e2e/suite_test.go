@@ -94,8 +94,11 @@ var _ = BeforeSuite(func() {
 		Expect(err).ToNot(HaveOccurred())
 
 		err = (&bookkeepercontroller.BookkeeperClusterReconciler{
-			Client: k8sManager.GetClient(),
-			Scheme: k8sManager.GetScheme(),
+			Client:   k8sManager.GetClient(),
+			Scheme:   k8sManager.GetScheme(),
+			Recorder: k8sManager.GetEventRecorderFor("bookkeeper-operator"),
+
+			MaxConcurrentReconciles: 3,
 		}).SetupWithManager(k8sManager)
 		Expect(err).ToNot(HaveOccurred())
 